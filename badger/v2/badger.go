@@ -5,8 +5,14 @@ package badger
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/dgraph-io/badger/v2"
 	"github.com/dgraph-io/badger/v2/options"
@@ -16,7 +22,15 @@ import (
 
 // DB is a wrapper over *badger/v2.DB,
 type DB struct {
-	db *badger.DB
+	db         *badger.DB
+	closed     atomic.Bool
+	autoCreate bool
+
+	// seqMu guards the leased sequences, and it's held by the operations
+	// that delete or rename tables, so a lease is never written back to a
+	// deleted table.
+	seqMu     sync.Mutex
+	sequences map[string]*badger.Sequence
 }
 
 // Open opens or creates a BoltDB database in the given path.
@@ -43,38 +57,129 @@ func (db *DB) Open(dir string, opt ...database.Option) (err error) {
 	default:
 		return badger.ErrInvalidLoadingMode
 	}
+	if opts.NoSync {
+		bo.SyncWrites = false
+	}
 
+	db.autoCreate = opts.AutoCreateTables
 	db.db, err = badger.Open(bo)
-	return errors.Wrap(err, "error opening Badger database")
+	if err != nil {
+		return errors.Wrap(err, "error opening Badger database")
+	}
+
+	// Finish the renames and copies interrupted by a crash.
+	if err := db.resumePending(); err != nil {
+		db.db.Close()
+		return errors.Wrap(err, "error resuming pending table renames")
+	}
+	return nil
 }
 
 // Close closes the DB database.
 func (db *DB) Close() error {
+	// Return the unused numbers of the leases, so the sequences continue
+	// without gaps.
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+	if !db.closed.Load() {
+		for bucket, seq := range db.sequences {
+			if err := seq.Release(); err != nil {
+				return errors.Wrapf(mapError(err), "error releasing sequence of table %s", bucket)
+			}
+		}
+	}
+	db.sequences = nil
+
+	db.closed.Store(true)
 	return errors.Wrap(db.db.Close(), "error closing Badger database")
 }
 
 // CreateTable creates a token element with the 'bucket' prefix so that such
 // that their appears to be a table.
 func (db *DB) CreateTable(bucket []byte) error {
-	bk, err := badgerEncode(bucket)
-	if err != nil {
+	return db.update(func(txn *badger.Txn) error {
+		return createTable(txn, bucket)
+	})
+}
+
+// DeleteTable deletes a table and the tables nested in it. Returns an error if
+// the table cannot be found.
+func (db *DB) DeleteTable(bucket []byte) error {
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+	defer db.forgetSequences(bucket)
+
+	var tables [][]byte
+	if err := db.view(func(txn *badger.Txn) error {
+		if err := checkTable(txn, bucket); err != nil {
+			return err
+		}
+		all, err := listTables(txn)
+		tables = append([][]byte{bucket}, database.NestedBuckets(all, bucket)...)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// Delete the nested tables first, so they are never left without their
+	// parents.
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := db.deleteTableKeys(tables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameTable moves a table, and the tables nested in it, to a new name. The
+// keys are moved in one transaction if possible. If the tables are too large
+// for a transaction, the keys are copied and then deleted in batches, but
+// first the rename is recorded, so Open can finish a rename interrupted by a
+// crash.
+func (db *DB) RenameTable(oldBucket, newBucket []byte) error {
+	if err := validateMove(oldBucket, newBucket); err != nil {
 		return err
 	}
-	return db.db.Update(func(txn *badger.Txn) error {
-		return errors.Wrapf(txn.Set(bk, []byte{}), "failed to create %s/", bucket)
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+	defer db.forgetSequences(oldBucket)
+
+	err := db.update(func(txn *badger.Txn) error {
+		return moveTables(txn, oldBucket, newBucket, true)
 	})
+	if database.IsErrTxTooLarge(err) {
+		return db.runPending(pendingRename, oldBucket, newBucket)
+	}
+	return err
 }
 
-// DeleteTable deletes a root or embedded bucket. Returns an error if the
-// bucket cannot be found or if the key represents a non-bucket value.
-func (db *DB) DeleteTable(bucket []byte) error {
+// CopyTable copies a table, and the tables nested in it, to a new table. The
+// keys are copied in one transaction if possible. If the tables are too large
+// for a transaction, the keys are copied in batches, but first the copy is
+// recorded, so Open can finish a copy interrupted by a crash.
+func (db *DB) CopyTable(srcBucket, dstBucket []byte) error {
+	if err := validateMove(srcBucket, dstBucket); err != nil {
+		return err
+	}
+	err := db.update(func(txn *badger.Txn) error {
+		return moveTables(txn, srcBucket, dstBucket, false)
+	})
+	if database.IsErrTxTooLarge(err) {
+		return db.runPending(pendingCopy, srcBucket, dstBucket)
+	}
+	return err
+}
+
+// deleteTableKeys deletes all the keys of a table in batches. Returns an
+// error if the table has no keys.
+func (db *DB) deleteTableKeys(bucket []byte) error {
 	var tableExists bool
 	prefix, err := badgerEncode(bucket)
 	if err != nil {
 		return err
 	}
 	deleteKeys := func(keysForDelete [][]byte) error {
-		if err := db.db.Update(func(txn *badger.Txn) error {
+		if err := db.update(func(txn *badger.Txn) error {
 			for _, key := range keysForDelete {
 				tableExists = true
 				if err := txn.Delete(key); err != nil {
@@ -89,7 +194,7 @@ func (db *DB) DeleteTable(bucket []byte) error {
 	}
 
 	collectSize := 1000
-	err = db.db.View(func(txn *badger.Txn) error {
+	err = db.view(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.AllVersions = false
 		opts.PrefetchValues = false
@@ -115,12 +220,17 @@ func (db *DB) DeleteTable(bucket []byte) error {
 			}
 		}
 		if !tableExists {
-			return errors.Wrapf(database.ErrNotFound, "table %s does not exist", bucket)
+			return errors.Wrapf(database.ErrBucketNotFound, "table %s does not exist", bucket)
 		}
 
 		return nil
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	return db.update(func(txn *badger.Txn) error {
+		return errors.Wrapf(txn.Delete(sequenceKey(bucket)), "error deleting sequence of table %s", bucket)
+	})
 }
 
 // badgerGetV2 is a helper for the Get method.
@@ -149,20 +259,137 @@ func (db *DB) Get(bucket, key []byte) (ret []byte, err error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, key)
 	}
-	err = db.db.View(func(txn *badger.Txn) error {
+	err = db.view(func(txn *badger.Txn) error {
+		if err := checkTable(txn, bucket); err != nil {
+			return err
+		}
 		ret, err = badgerGetV2(txn, bk)
 		return err
 	})
 	return
 }
 
+// GetMulti returns the values stored in the given bucket and keys. The keys
+// that do not exist are not in the result.
+func (db *DB) GetMulti(bucket []byte, keys [][]byte) (ret map[string][]byte, err error) {
+	err = db.view(func(txn *badger.Txn) error {
+		ret, err = getMulti(txn, bucket, keys)
+		return err
+	})
+	return
+}
+
+// GetMultiBuckets returns the values stored in the given buckets and keys,
+// reading all the buckets in the same transaction.
+func (db *DB) GetMultiBuckets(keys map[string][][]byte) (ret map[string]map[string][]byte, err error) {
+	err = db.view(func(txn *badger.Txn) error {
+		ret = make(map[string]map[string][]byte, len(keys))
+		for bucket, bucketKeys := range keys {
+			values, err := getMulti(txn, []byte(bucket), bucketKeys)
+			if err != nil {
+				return err
+			}
+			ret[bucket] = values
+		}
+		return nil
+	})
+	return
+}
+
+// getMulti returns the values stored in the given bucket and keys in the
+// given transaction.
+func getMulti(txn *badger.Txn, bucket []byte, keys [][]byte) (map[string][]byte, error) {
+	if err := checkTable(txn, bucket); err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		bk, err := toBadgerKey(bucket, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, key)
+		}
+		v, err := badgerGetV2(txn, bk)
+		switch {
+		case database.IsErrNotFound(err):
+		case err != nil:
+			return nil, err
+		default:
+			ret[string(key)] = v
+		}
+	}
+	return ret, nil
+}
+
 // Set stores the given value on bucket and key.
 func (db *DB) Set(bucket, key, value []byte) error {
 	bk, err := toBadgerKey(bucket, key)
 	if err != nil {
 		return errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, key)
 	}
-	return db.db.Update(func(txn *badger.Txn) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	return db.update(func(txn *badger.Txn) error {
+		if err := checkTable(txn, bucket); err != nil {
+			return err
+		}
+		return errors.Wrapf(txn.Set(bk, value), "failed to set %s/%s", bucket, key)
+	})
+}
+
+// GetVersioned returns the value stored in the given bucket and key, and its
+// version. The version is the version of the Badger item, the timestamp of
+// the transaction that wrote it.
+func (db *DB) GetVersioned(bucket, key []byte) (ret []byte, version uint64, err error) {
+	bk, err := toBadgerKey(bucket, key)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, key)
+	}
+	err = db.view(func(txn *badger.Txn) error {
+		if err := checkTable(txn, bucket); err != nil {
+			return err
+		}
+		item, err := txn.Get(bk)
+		switch {
+		case errors.Is(err, badger.ErrKeyNotFound):
+			return errors.Wrapf(database.ErrNotFound, "%s/%s not found", bucket, key)
+		case err != nil:
+			return errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+		}
+		if ret, err = item.ValueCopy(nil); err != nil {
+			return errors.Wrap(err, "error accessing value returned by database")
+		}
+		version = item.Version()
+		return nil
+	})
+	return
+}
+
+// SetIfVersion stores the given value on bucket and key only if the entry
+// has the given version, or if it does not exist and the version is 0.
+func (db *DB) SetIfVersion(bucket, key, value []byte, version uint64) error {
+	bk, err := toBadgerKey(bucket, key)
+	if err != nil {
+		return errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, key)
+	}
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	return db.update(func(txn *badger.Txn) error {
+		if err := checkTable(txn, bucket); err != nil {
+			return err
+		}
+		var current uint64
+		item, err := txn.Get(bk)
+		switch {
+		case err == nil:
+			current = item.Version()
+		case !errors.Is(err, badger.ErrKeyNotFound):
+			return errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+		}
+		if current != version {
+			return errors.Wrapf(database.ErrVersionMismatch, "%s/%s has version %d", bucket, key, current)
+		}
 		return errors.Wrapf(txn.Set(bk, value), "failed to set %s/%s", bucket, key)
 	})
 }
@@ -173,7 +400,13 @@ func (db *DB) Del(bucket, key []byte) error {
 	if err != nil {
 		return errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, key)
 	}
-	return db.db.Update(func(txn *badger.Txn) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	return db.update(func(txn *badger.Txn) error {
+		if err := checkTable(txn, bucket); err != nil {
+			return err
+		}
 		return errors.Wrapf(txn.Delete(bk), "failed to delete %s/%s", bucket, key)
 	})
 }
@@ -184,7 +417,7 @@ func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
 		entries     []*database.Entry
 		tableExists bool
 	)
-	err := db.db.View(func(txn *badger.Txn) error {
+	err := db.view(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 		prefix, err := badgerEncode(bucket)
@@ -217,13 +450,186 @@ func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
 			})
 		}
 		if !tableExists {
-			return errors.Wrapf(database.ErrNotFound, "bucket %s not found", bucket)
+			return errors.Wrapf(database.ErrBucketNotFound, "bucket %s not found", bucket)
 		}
 		return nil
 	})
 	return entries, err
 }
 
+// ListKeys returns the keys of all the entries in a bucket, in order. It
+// does not read the values.
+func (db *DB) ListKeys(bucket []byte) ([][]byte, error) {
+	var keys [][]byte
+	err := db.view(func(txn *badger.Txn) error {
+		return scanKeys(txn, bucket, func(key []byte) {
+			keys = append(keys, cloneBytes(key))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	return keys, nil
+}
+
+// Count returns the number of entries in a bucket. It does not read the
+// values.
+func (db *DB) Count(bucket []byte) (n int, err error) {
+	err = db.view(func(txn *badger.Txn) error {
+		return scanKeys(txn, bucket, func([]byte) {
+			n++
+		})
+	})
+	return
+}
+
+// scanKeys calls fn with the keys of all the entries in a bucket, without
+// fetching the values. The keys are only valid during the call.
+func scanKeys(txn *badger.Txn, bucket []byte, fn func(key []byte)) error {
+	prefix, err := badgerEncode(bucket)
+	if err != nil {
+		return err
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var tableExists bool
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		tableExists = true
+		bk := it.Item().Key()
+		if isBadgerTable(bk) {
+			continue
+		}
+		_bucket, key, err := fromBadgerKey(bk)
+		if err != nil {
+			return errors.Wrapf(err, "error converting from badgerKey %s", bk)
+		}
+		if !bytes.Equal(_bucket, bucket) {
+			return errors.Errorf("bucket names do not match; want %v, but got %v",
+				bucket, _bucket)
+		}
+		fn(key)
+	}
+	if !tableExists {
+		return errors.Wrapf(database.ErrBucketNotFound, "bucket %s not found", bucket)
+	}
+	return nil
+}
+
+// BulkLoad writes the entries of the iterator in the given bucket, using a
+// write batch per batch of entries. The stream writer is faster, but it can
+// only be used on empty databases.
+func (db *DB) BulkLoad(bucket []byte, iter database.Iterator, opts ...database.BulkOption) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	if err := db.view(func(txn *badger.Txn) error {
+		return checkTable(txn, bucket)
+	}); err != nil {
+		return err
+	}
+
+	o := database.NewBulkOptions(opts...)
+	return o.Run(iter, 0, func(batch []*database.Entry) error {
+		if db.closed.Load() {
+			return errors.WithStack(database.ErrClosed)
+		}
+		wb := db.db.NewWriteBatch()
+		defer wb.Cancel()
+		for _, e := range batch {
+			bk, err := toBadgerKey(bucket, e.Key)
+			if err != nil {
+				return errors.Wrapf(err, "error converting %s/%s to badgerKey", bucket, e.Key)
+			}
+			if err := wb.Set(bk, e.Value); err != nil {
+				return errors.Wrapf(mapError(err), "failed to set %s/%s", bucket, e.Key)
+			}
+		}
+		return errors.Wrap(mapError(wb.Flush()), "error writing batch")
+	})
+}
+
+// Truncate deletes all the entries in a table, but keeps the table and the
+// tables nested in it. The entries are deleted in batches.
+func (db *DB) Truncate(bucket []byte) error {
+	return db.deleteEntries(bucket, keyMatcher(database.Truncate, nil, nil))
+}
+
+// DeleteRange deletes the entries in a table with a key in the range from
+// start, inclusive, to end, exclusive. The keys of a table are not sorted by
+// key, so all the keys of the table are scanned, and the ones in the range
+// are deleted in batches.
+func (db *DB) DeleteRange(bucket, start, end []byte) error {
+	return db.deleteEntries(bucket, keyMatcher(database.DeleteRange, start, end))
+}
+
+// DeletePrefix deletes the entries in a table with a key that starts with the
+// given prefix. As on DeleteRange, all the keys of the table are scanned, and
+// the ones with the prefix are deleted in batches.
+func (db *DB) DeletePrefix(bucket, prefix []byte) error {
+	return db.deleteEntries(bucket, keyMatcher(database.DeletePrefix, prefix, nil))
+}
+
+// deleteEntries deletes in batches the entries in a table with a key accepted
+// by match.
+func (db *DB) deleteEntries(bucket []byte, match func(key []byte) bool) error {
+	prefix, err := badgerEncode(bucket)
+	if err != nil {
+		return err
+	}
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+
+	deleteKeys := func(keys [][]byte) error {
+		return db.update(func(txn *badger.Txn) error {
+			for _, key := range keys {
+				if err := txn.Delete(key); err != nil {
+					return errors.Wrapf(err, "error deleting key %s", key)
+				}
+			}
+			return nil
+		})
+	}
+
+	collectSize := 1000
+	return db.view(func(txn *badger.Txn) error {
+		if err := checkTable(txn, bucket); err != nil {
+			return err
+		}
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		keys := make([][]byte, 0, collectSize)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			// The table token is not an entry.
+			_, key, err := fromBadgerKey(it.Item().Key())
+			if err != nil || !match(key) {
+				continue
+			}
+			keys = append(keys, it.Item().KeyCopy(nil))
+			if len(keys) == collectSize {
+				if err := deleteKeys(keys); err != nil {
+					return err
+				}
+				keys = keys[:0]
+			}
+		}
+		if len(keys) > 0 {
+			return deleteKeys(keys)
+		}
+		return nil
+	})
+}
+
 // CmpAndSwap modifies the value at the given bucket and key (to newValue)
 // only if the existing (current) value matches oldValue.
 func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
@@ -232,16 +638,26 @@ func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool,
 		return nil, false, err
 	}
 
+	if err := db.autoCreateTables(bucket); err != nil {
+		return nil, false, err
+	}
+	if db.closed.Load() {
+		return nil, false, errors.WithStack(database.ErrClosed)
+	}
 	badgerTxn := db.db.NewTransaction(true)
 	defer badgerTxn.Discard()
 
+	if err := checkTable(badgerTxn, bucket); err != nil {
+		return nil, false, mapError(err)
+	}
+
 	val, swapped, err := cmpAndSwapV2(badgerTxn, bk, oldValue, newValue)
 	switch {
 	case err != nil:
-		return nil, false, err
+		return nil, false, mapError(err)
 	case swapped:
 		if err := badgerTxn.Commit(); err != nil {
-			return nil, false, errors.Wrapf(err, "failed to commit badger transaction")
+			return nil, false, errors.Wrapf(mapError(err), "failed to commit badger transaction")
 		}
 		return val, swapped, nil
 	default:
@@ -265,18 +681,149 @@ func cmpAndSwapV2(badgerTxn *badger.Txn, bk, oldValue, newValue []byte) ([]byte,
 	return newValue, true, nil
 }
 
+// Incr adds delta to the counter stored in the given bucket and key, and
+// returns the new value. The transaction is retried until it commits, so
+// concurrent increments of the same key do not fail with
+// database.ErrConflict.
+func (db *DB) Incr(bucket, key []byte, delta int64) (n int64, err error) {
+	bk, err := toBadgerKey(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.autoCreateTables(bucket); err != nil {
+		return 0, err
+	}
+	// A conflicting transaction did not commit, it's safe to run it again.
+	err = database.RetryConflict(context.Background(), func() error {
+		return db.update(func(txn *badger.Txn) error {
+			if err := checkTable(txn, bucket); err != nil {
+				return err
+			}
+			n, err = incrV2(txn, bk, delta)
+			return errors.Wrapf(err, "failed to increment %s/%s", bucket, key)
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func incrV2(txn *badger.Txn, bk []byte, delta int64) (int64, error) {
+	current, err := badgerGetV2(txn, bk)
+	if err != nil && !database.IsErrNotFound(err) {
+		return 0, err
+	}
+	n, err := database.AddCounter(current, delta)
+	if err != nil {
+		return 0, err
+	}
+	return n, txn.Set(bk, database.FormatCounter(n))
+}
+
+// NextSequence returns the next number of the sequence of the given table.
+// The numbers are leased from the database in blocks of sequenceBandwidth, so
+// a crash leaves a gap in the sequence.
+func (db *DB) NextSequence(bucket []byte) (uint64, error) {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return 0, err
+	}
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+	if err := db.view(func(txn *badger.Txn) error {
+		return checkTable(txn, bucket)
+	}); err != nil {
+		return 0, err
+	}
+	return db.nextSequence(bucket)
+}
+
+// nextSequence returns the next number of the leased sequence of a table, the
+// caller must hold db.seqMu and check that the table exists.
+func (db *DB) nextSequence(bucket []byte) (uint64, error) {
+	seq, ok := db.sequences[string(bucket)]
+	if !ok {
+		var err error
+		if seq, err = db.db.GetSequence(sequenceKey(bucket), sequenceBandwidth); err != nil {
+			return 0, errors.Wrapf(mapError(err), "error leasing sequence of table %s", bucket)
+		}
+		if db.sequences == nil {
+			db.sequences = make(map[string]*badger.Sequence)
+		}
+		db.sequences[string(bucket)] = seq
+	}
+	n, err := seq.Next()
+	if err != nil {
+		return 0, errors.Wrapf(mapError(err), "error getting next sequence of table %s", bucket)
+	}
+	// Badger sequences start at 0.
+	return n + 1, nil
+}
+
+// forgetSequences drops the leases of the sequences of a table and of the
+// tables nested in it, after they are deleted or renamed. The caller must hold
+// db.seqMu.
+func (db *DB) forgetSequences(bucket []byte) {
+	for name := range db.sequences {
+		if name == string(bucket) || database.IsNestedBucket([]byte(name), bucket) {
+			delete(db.sequences, name)
+		}
+	}
+}
+
 // Update performs multiple commands on one read-write transaction.
 func (db *DB) Update(txn *database.Tx) error {
-	return db.db.Update(func(badgerTxn *badger.Txn) (err error) {
+	var (
+		buckets, deleted [][]byte
+		sequences        bool
+	)
+	for _, q := range txn.Operations {
+		if q.Cmd.IsWrite() {
+			buckets = append(buckets, q.Bucket)
+		}
+		switch q.Cmd {
+		case database.DeleteTable:
+			deleted = append(deleted, q.Bucket)
+		case database.NextSequence:
+			sequences = true
+		}
+	}
+	if err := db.autoCreateTables(buckets...); err != nil {
+		return err
+	}
+	if sequences || len(deleted) > 0 {
+		db.seqMu.Lock()
+		defer db.seqMu.Unlock()
+		for _, bucket := range deleted {
+			defer db.forgetSequences(bucket)
+		}
+	}
+
+	return db.update(func(badgerTxn *badger.Txn) (err error) {
 		for _, q := range txn.Operations {
 			switch q.Cmd {
 			case database.CreateTable:
-				if err := db.CreateTable(q.Bucket); err != nil {
+				if err := createTable(badgerTxn, q.Bucket); err != nil {
 					return err
 				}
 				continue
 			case database.DeleteTable:
-				if err := db.DeleteTable(q.Bucket); err != nil {
+				if err := deleteTable(badgerTxn, q.Bucket); err != nil {
+					return err
+				}
+				continue
+			case database.Truncate, database.DeleteRange, database.DeletePrefix:
+				if err := deleteEntries(badgerTxn, q.Bucket, keyMatcher(q.Cmd, q.Key, q.End)); err != nil {
+					return err
+				}
+				continue
+			case database.NextSequence:
+				// The lease is not part of the transaction, so a rolled
+				// back transaction leaves a gap in the sequence.
+				if err := checkTable(badgerTxn, q.Bucket); err != nil {
+					return err
+				}
+				if q.Sequence, err = db.nextSequence(q.Bucket); err != nil {
 					return err
 				}
 				continue
@@ -285,6 +832,9 @@ func (db *DB) Update(txn *database.Tx) error {
 			if err != nil {
 				return err
 			}
+			if err := checkTable(badgerTxn, q.Bucket); err != nil {
+				return err
+			}
 			switch q.Cmd {
 			case database.Get:
 				if q.Result, err = badgerGetV2(badgerTxn, bk); err != nil {
@@ -303,6 +853,10 @@ func (db *DB) Update(txn *database.Tx) error {
 				if err != nil {
 					return errors.Wrapf(err, "failed to CmpAndSwap %s/%s", q.Bucket, q.Key)
 				}
+			case database.Incr:
+				if q.Counter, err = incrV2(badgerTxn, bk, q.Delta); err != nil {
+					return errors.Wrapf(err, "failed to increment %s/%s", q.Bucket, q.Key)
+				}
 			case database.CmpOrRollback:
 				return database.ErrOpNotSupported
 			default:
@@ -313,23 +867,630 @@ func (db *DB) Update(txn *database.Tx) error {
 	})
 }
 
-// Compact triggers a value log garbage collection.
-func (db *DB) Compact(discardRatio float64) error {
-	return db.db.RunValueLogGC(discardRatio)
-}
-
-// toBadgerKey returns the Badger database key using the following algorithm:
-// First 2 bytes are the length of the bucket/table name in little endian format,
-// followed by the bucket/table name,
-// followed by 2 bytes representing the length of the key in little endian format,
-// followed by the key.
-func toBadgerKey(bucket, key []byte) ([]byte, error) {
-	first, err := badgerEncode(bucket)
-	if err != nil {
-		return nil, err
+// View performs multiple read commands on one read-only transaction, all of
+// them reading the same snapshot of the database.
+func (db *DB) View(txn *database.Tx) error {
+	if err := txn.CheckReadOnly(); err != nil {
+		return err
 	}
-	second, err := badgerEncode(key)
-	if err != nil {
+
+	return db.view(func(badgerTxn *badger.Txn) (err error) {
+		for _, q := range txn.Operations {
+			bk, err := toBadgerKey(q.Bucket, q.Key)
+			if err != nil {
+				return err
+			}
+			if err := checkTable(badgerTxn, q.Bucket); err != nil {
+				return err
+			}
+			switch q.Cmd {
+			case database.Get:
+				if q.Result, err = badgerGetV2(badgerTxn, bk); err != nil {
+					return errors.Wrapf(err, "failed to get %s/%s", q.Bucket, q.Key)
+				}
+			default:
+				return database.ErrOpNotSupported
+			}
+		}
+		return nil
+	})
+}
+
+// Compact triggers a value log garbage collection.
+func (db *DB) Compact(discardRatio float64) error {
+	if db.closed.Load() {
+		return errors.WithStack(database.ErrClosed)
+	}
+	return db.db.RunValueLogGC(discardRatio)
+}
+
+// ListTables returns the names of the tables in the database. Besides the
+// tables with a token element, it returns the tables created implicitly by
+// older versions, that only have keys.
+func (db *DB) ListTables() ([][]byte, error) {
+	var tables [][]byte
+	err := db.view(func(txn *badger.Txn) (err error) {
+		tables, err = listTables(txn)
+		return
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing tables")
+	}
+	return tables, nil
+}
+
+// TableExists returns true if the table exists in the database.
+func (db *DB) TableExists(bucket []byte) (exists bool, err error) {
+	err = db.view(func(txn *badger.Txn) error {
+		err := checkTable(txn, bucket)
+		switch {
+		case err == nil:
+			exists = true
+		case !database.IsErrBucketNotFound(err):
+			return err
+		}
+		return nil
+	})
+	return
+}
+
+// autoCreateTables creates the given tables if they do not exist and the
+// database was opened with database.WithAutoCreateTables.
+func (db *DB) autoCreateTables(buckets ...[]byte) error {
+	if !db.autoCreate || len(buckets) == 0 {
+		return nil
+	}
+
+	// Avoid a write transaction if all the tables exist.
+	var missing [][]byte
+	if err := db.view(func(txn *badger.Txn) error {
+		for _, bucket := range buckets {
+			err := checkTable(txn, bucket)
+			switch {
+			case database.IsErrBucketNotFound(err):
+				missing = append(missing, bucket)
+			case err != nil:
+				return err
+			}
+		}
+		return nil
+	}); err != nil || len(missing) == 0 {
+		return err
+	}
+
+	return db.update(func(txn *badger.Txn) error {
+		for _, bucket := range missing {
+			if err := createTable(txn, bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createTable creates the token element of a table, and the ones of its
+// parents, in the given transaction.
+func createTable(txn *badger.Txn, bucket []byte) error {
+	if err := database.ValidateBucket(bucket); err != nil {
+		return err
+	}
+	for _, table := range append(database.ParentBuckets(bucket), bucket) {
+		bk, err := badgerEncode(table)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set(bk, []byte{}); err != nil {
+			return errors.Wrapf(err, "failed to create %s/", table)
+		}
+	}
+	return nil
+}
+
+// deleteTable deletes all the keys of a table, and of the tables nested in
+// it, in the given transaction. Unlike DeleteTable, all the keys are deleted
+// in the same transaction, so large tables might exceed the size of a
+// transaction.
+func deleteTable(txn *badger.Txn, bucket []byte) error {
+	if err := checkTable(txn, bucket); err != nil {
+		return err
+	}
+	tables, err := listTables(txn)
+	if err != nil {
+		return err
+	}
+
+	var keys [][]byte
+	for _, table := range append([][]byte{bucket}, database.NestedBuckets(tables, bucket)...) {
+		keys = append(keys, sequenceKey(table))
+		prefix, err := badgerEncode(table)
+		if err != nil {
+			return err
+		}
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		it.Close()
+	}
+
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return errors.Wrapf(err, "error deleting key %s", key)
+		}
+	}
+	return nil
+}
+
+// deleteEntries deletes the entries in a table with a key accepted by match
+// in the given transaction.
+func deleteEntries(txn *badger.Txn, bucket []byte, match func(key []byte) bool) error {
+	prefix, err := badgerEncode(bucket)
+	if err != nil {
+		return err
+	}
+	if err := checkTable(txn, bucket); err != nil {
+		return err
+	}
+
+	var keys [][]byte
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		// The table token is not an entry.
+		if _, key, err := fromBadgerKey(it.Item().Key()); err == nil && match(key) {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+	}
+	it.Close()
+
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return errors.Wrapf(err, "error deleting key %s", key)
+		}
+	}
+	return nil
+}
+
+// keyMatcher returns a function that accepts the keys deleted by the
+// Truncate, DeleteRange or DeletePrefix commands with the given key and end.
+func keyMatcher(cmd database.TxCmd, key, end []byte) func(key []byte) bool {
+	switch cmd {
+	case database.DeleteRange:
+		return func(k []byte) bool {
+			return database.InRange(k, key, end)
+		}
+	case database.DeletePrefix:
+		return func(k []byte) bool {
+			return bytes.HasPrefix(k, key)
+		}
+	default:
+		return func([]byte) bool {
+			return true
+		}
+	}
+}
+
+// validateMove returns database.ErrInvalidKey if a table cannot be renamed
+// or copied to dst.
+func validateMove(src, dst []byte) error {
+	if bytes.Equal(src, dst) || database.IsNestedBucket(dst, src) {
+		return errors.Wrapf(database.ErrInvalidKey, "cannot move table %s to %s", src, dst)
+	}
+	return database.ValidateBucket(dst)
+}
+
+// checkMove returns an error if the table src does not exist or the table dst
+// exists in the given transaction.
+func checkMove(txn *badger.Txn, src, dst []byte) error {
+	if err := checkTable(txn, src); err != nil {
+		return err
+	}
+	err := checkTable(txn, dst)
+	switch {
+	case err == nil:
+		return errors.Wrapf(database.ErrBucketExists, "table %s already exists", dst)
+	case !database.IsErrBucketNotFound(err):
+		return err
+	}
+	return nil
+}
+
+// moveTables copies the keys of the table src, and of the tables nested in
+// it, to the table dst in the given transaction, and deletes them if
+// deleteSrc is true.
+func moveTables(txn *badger.Txn, src, dst []byte, deleteSrc bool) error {
+	if err := checkMove(txn, src, dst); err != nil {
+		return err
+	}
+	if parents := database.ParentBuckets(dst); len(parents) > 0 {
+		if err := createTable(txn, parents[len(parents)-1]); err != nil {
+			return err
+		}
+	}
+	all, err := listTables(txn)
+	if err != nil {
+		return err
+	}
+	for _, table := range append([][]byte{src}, database.NestedBuckets(all, src)...) {
+		moved := append(cloneBytes(dst), table[len(src):]...)
+		if err := moveTableKeys(txn, table, moved, deleteSrc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveTableKeys copies all the keys of a table to a new table in the given
+// transaction, and deletes them if deleteSrc is true. It does not move the
+// tables nested in it.
+func moveTableKeys(txn *badger.Txn, src, dst []byte, deleteSrc bool) error {
+	srcPrefix, err := badgerEncode(src)
+	if err != nil {
+		return err
+	}
+	dstPrefix, err := badgerEncode(dst)
+	if err != nil {
+		return err
+	}
+
+	var keys, values [][]byte
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	for it.Seek(srcPrefix); it.ValidForPrefix(srcPrefix); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			it.Close()
+			return errors.Wrap(err, "error retrieving contents from database value")
+		}
+		keys = append(keys, item.KeyCopy(nil))
+		values = append(values, v)
+	}
+	it.Close()
+
+	for i, key := range keys {
+		if deleteSrc {
+			if err := txn.Delete(key); err != nil {
+				return errors.Wrapf(err, "error deleting key %s", key)
+			}
+		}
+		bk := append(cloneBytes(dstPrefix), key[len(srcPrefix):]...)
+		if err := txn.Set(bk, values[i]); err != nil {
+			return errors.Wrapf(err, "error setting key %s", bk)
+		}
+	}
+	return moveSequence(txn, src, dst, deleteSrc)
+}
+
+// moveSequence copies the lease of the sequence of the table src to the table
+// dst in the given transaction, and deletes it if deleteSrc is true.
+func moveSequence(txn *badger.Txn, src, dst []byte, deleteSrc bool) error {
+	item, err := txn.Get(sequenceKey(src))
+	switch {
+	case errors.Is(err, badger.ErrKeyNotFound):
+		return nil
+	case err != nil:
+		return errors.Wrapf(err, "failed to get sequence of table %s", src)
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return errors.Wrap(err, "error retrieving contents from database value")
+	}
+	if deleteSrc {
+		if err := txn.Delete(sequenceKey(src)); err != nil {
+			return errors.Wrapf(err, "error deleting sequence of table %s", src)
+		}
+	}
+	return errors.Wrapf(txn.Set(sequenceKey(dst), v), "error setting sequence of table %s", dst)
+}
+
+// Operations recorded while they run in batches.
+const (
+	pendingRename = "rename"
+	pendingCopy   = "copy"
+)
+
+// pendingPrefix is the prefix of the keys that record the renames and copies
+// that run in batches. Table names cannot be empty, so these keys never
+// collide with the keys of a table, and ListTables ignores them.
+var pendingPrefix = []byte("\x00\x00pending/")
+
+// sequencePrefix is the prefix of the keys that store the leases of the
+// sequences of the tables. As with pendingPrefix, these keys never collide
+// with the keys of a table.
+var sequencePrefix = []byte("\x00\x00sequence/")
+
+// sequenceBandwidth is the number of sequence numbers leased at once.
+const sequenceBandwidth = 100
+
+// sequenceKey returns the key that stores the lease of the sequence of a
+// table.
+func sequenceKey(bucket []byte) []byte {
+	return append(cloneBytes(sequencePrefix), bucket...)
+}
+
+// pendingOp is a rename or a copy that runs in batches.
+type pendingOp struct {
+	Op  string `json:"op"`
+	Src []byte `json:"src"`
+	Dst []byte `json:"dst"`
+}
+
+// pendingKey returns the key that records an operation on the table dst.
+func pendingKey(dst []byte) []byte {
+	return append(cloneBytes(pendingPrefix), dst...)
+}
+
+// runPending records an operation and runs it in batches. The table dst is
+// created with the record, so concurrent operations on it fail.
+func (db *DB) runPending(op string, src, dst []byte) error {
+	p := &pendingOp{Op: op, Src: src, Dst: dst}
+	value, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "error encoding pending operation")
+	}
+	if err := db.update(func(txn *badger.Txn) error {
+		if err := checkMove(txn, src, dst); err != nil {
+			return err
+		}
+		if err := createTable(txn, dst); err != nil {
+			return err
+		}
+		return errors.Wrap(txn.Set(pendingKey(dst), value), "failed to set pending operation")
+	}); err != nil {
+		return err
+	}
+	return db.finishPending(p)
+}
+
+// finishPending copies in batches the keys of the tables of a recorded
+// operation, deletes the source keys if it's a rename, and then deletes the
+// record. All the steps can be repeated, so it can finish an operation
+// interrupted at any point.
+func (db *DB) finishPending(p *pendingOp) error {
+	var tables [][]byte
+	if err := db.view(func(txn *badger.Txn) error {
+		all, err := listTables(txn)
+		// The table src is deleted last on renames.
+		if containsTable(all, p.Src) {
+			tables = append(tables, p.Src)
+		}
+		tables = append(tables, database.NestedBuckets(all, p.Src)...)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if err := db.copyTableKeys(table, append(cloneBytes(p.Dst), table[len(p.Src):]...)); err != nil {
+			return err
+		}
+	}
+	if p.Op == pendingRename {
+		for i := len(tables) - 1; i >= 0; i-- {
+			if err := db.deleteTableKeys(tables[i]); err != nil && !database.IsErrBucketNotFound(err) {
+				return err
+			}
+		}
+	}
+	return db.update(func(txn *badger.Txn) error {
+		return errors.Wrap(txn.Delete(pendingKey(p.Dst)), "failed to delete pending operation")
+	})
+}
+
+// resumePending finishes the recorded operations, the ones interrupted by a
+// crash.
+func (db *DB) resumePending() error {
+	var ops []*pendingOp
+	if err := db.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(pendingPrefix); it.ValidForPrefix(pendingPrefix); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return errors.Wrap(err, "error retrieving contents from database value")
+			}
+			p := new(pendingOp)
+			if err := json.Unmarshal(v, p); err != nil {
+				return errors.Wrapf(err, "error decoding pending operation %s", it.Item().Key())
+			}
+			ops = append(ops, p)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, p := range ops {
+		if err := db.finishPending(p); err != nil {
+			return errors.Wrapf(err, "error resuming %s of table %s to %s", p.Op, p.Src, p.Dst)
+		}
+	}
+	return nil
+}
+
+// copyTableKeys copies all the keys of a table to another table using a
+// write batch, so the keys are not copied in the same transaction.
+func (db *DB) copyTableKeys(src, dst []byte) error {
+	srcPrefix, err := badgerEncode(src)
+	if err != nil {
+		return err
+	}
+	dstPrefix, err := badgerEncode(dst)
+	if err != nil {
+		return err
+	}
+	if db.closed.Load() {
+		return errors.WithStack(database.ErrClosed)
+	}
+
+	wb := db.db.NewWriteBatch()
+	defer wb.Cancel()
+	if err := db.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(srcPrefix); it.ValidForPrefix(srcPrefix); it.Next() {
+			item := it.Item()
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return errors.Wrap(err, "error retrieving contents from database value")
+			}
+			bk := append(cloneBytes(dstPrefix), item.Key()[len(srcPrefix):]...)
+			if err := wb.Set(bk, v); err != nil {
+				return errors.Wrapf(err, "error setting key %s", bk)
+			}
+		}
+		return copySequence(txn, wb, src, dst)
+	}); err != nil {
+		return err
+	}
+	return errors.Wrap(mapError(wb.Flush()), "error writing batch")
+}
+
+// copySequence copies the lease of the sequence of the table src, if any, to
+// the table dst using a write batch.
+func copySequence(txn *badger.Txn, wb *badger.WriteBatch, src, dst []byte) error {
+	item, err := txn.Get(sequenceKey(src))
+	switch {
+	case errors.Is(err, badger.ErrKeyNotFound):
+		return nil
+	case err != nil:
+		return errors.Wrapf(err, "failed to get sequence of table %s", src)
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return errors.Wrap(err, "error retrieving contents from database value")
+	}
+	return errors.Wrapf(wb.Set(sequenceKey(dst), v), "error setting sequence of table %s", dst)
+}
+
+// containsTable returns true if tables contains bucket.
+func containsTable(tables [][]byte, bucket []byte) bool {
+	for _, table := range tables {
+		if bytes.Equal(table, bucket) {
+			return true
+		}
+	}
+	return false
+}
+
+// listTables returns the names of the tables in the given transaction, see
+// ListTables.
+func listTables(txn *badger.Txn) ([][]byte, error) {
+	var tables [][]byte
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	it.Rewind()
+	for it.Valid() {
+		bk := it.Item().Key()
+		bucket, _ := parseBadgerEncode(bk)
+		if len(bucket) == 0 {
+			it.Next()
+			continue
+		}
+		tables = append(tables, cloneBytes(bucket))
+		// Skip the rest of the keys of the table.
+		next := database.PrefixEnd(bk[:2+len(bucket)])
+		if next == nil {
+			break
+		}
+		it.Seek(next)
+	}
+
+	// Keys are sorted by the length of the table name first.
+	sort.Slice(tables, func(i, j int) bool {
+		return bytes.Compare(tables[i], tables[j]) < 0
+	})
+	return tables, nil
+}
+
+// checkTable returns database.ErrBucketNotFound if the table does not exist
+// in the given transaction. A table exists if its token element exists or, as
+// the token was optional on older versions, if it has any key.
+func checkTable(txn *badger.Txn, bucket []byte) error {
+	prefix, err := badgerEncode(bucket)
+	if err != nil {
+		return err
+	}
+	_, err = txn.Get(prefix)
+	switch {
+	case err == nil:
+		return nil
+	case !errors.Is(err, badger.ErrKeyNotFound):
+		return errors.Wrapf(err, "failed to get table %s", bucket)
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	if it.Seek(prefix); !it.ValidForPrefix(prefix) {
+		return errors.Wrapf(database.ErrBucketNotFound, "table %s does not exist", bucket)
+	}
+	return nil
+}
+
+// view runs fn in a read-only transaction, it fails with database.ErrClosed
+// if the database is closed, as Badger does not check it on reads.
+func (db *DB) view(fn func(txn *badger.Txn) error) error {
+	if db.closed.Load() {
+		return errors.WithStack(database.ErrClosed)
+	}
+	return mapError(db.db.View(fn))
+}
+
+// update runs fn in a read-write transaction, it fails with
+// database.ErrClosed if the database is closed.
+func (db *DB) update(fn func(txn *badger.Txn) error) error {
+	if db.closed.Load() {
+		return errors.WithStack(database.ErrClosed)
+	}
+	return mapError(db.db.Update(fn))
+}
+
+// mapError returns an error that matches the database error equivalent to
+// the given Badger error, and keeps the Badger error as its cause.
+func mapError(err error) error {
+	var target error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, badger.ErrConflict):
+		target = database.ErrConflict
+	case errors.Is(err, badger.ErrTxnTooBig):
+		target = database.ErrTxTooLarge
+	case errors.Is(err, badger.ErrEmptyKey), errors.Is(err, badger.ErrInvalidKey):
+		target = database.ErrInvalidKey
+	case errors.Is(err, badger.ErrDBClosed), errors.Is(err, badger.ErrBlockedWrites):
+		target = database.ErrClosed
+	case errors.Is(err, badger.ErrReadOnlyTxn):
+		target = database.ErrReadOnly
+	default:
+		return err
+	}
+	if errors.Is(err, target) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", target, err)
+}
+
+// toBadgerKey returns the Badger database key using the following algorithm:
+// First 2 bytes are the length of the bucket/table name in little endian format,
+// followed by the bucket/table name,
+// followed by 2 bytes representing the length of the key in little endian format,
+// followed by the key.
+func toBadgerKey(bucket, key []byte) ([]byte, error) {
+	first, err := badgerEncode(bucket)
+	if err != nil {
+		return nil, err
+	}
+	second, err := badgerEncode(key)
+	if err != nil {
 		return nil, err
 	}
 	return append(first, second...), nil
@@ -368,9 +1529,9 @@ func badgerEncode(val []byte) ([]byte, error) {
 	l := len(val)
 	switch {
 	case l == 0:
-		return nil, errors.Errorf("input cannot be empty")
+		return nil, errors.Wrap(database.ErrInvalidKey, "input cannot be empty")
 	case l > 65535:
-		return nil, errors.Errorf("length of input cannot be greater than 65535")
+		return nil, errors.Wrap(database.ErrInvalidKey, "length of input cannot be greater than 65535")
 	default:
 		lb := new(bytes.Buffer)
 		if err := binary.Write(lb, binary.LittleEndian, uint16(l)); err != nil {
@@ -380,24 +1541,21 @@ func badgerEncode(val []byte) ([]byte, error) {
 	}
 }
 
+// parseBadgerEncode parses a section of a BadgerKey, returning its value and
+// the remainder of the slice. If bk does not start with a complete section it
+// returns a nil value and bk. See documentation for toBadgerKey.
 func parseBadgerEncode(bk []byte) (value, rest []byte) {
-	var (
-		keyLen uint16
-		start  = uint16(2)
-		length = uint16(len(bk))
-	)
-	if uint16(len(bk)) < start {
-		return nil, bk
-	}
-	// First 2 bytes stores the length of the value.
-	if err := binary.Read(bytes.NewReader(bk[:2]), binary.LittleEndian, &keyLen); err != nil {
+	const start = 2
+	if len(bk) < start {
 		return nil, bk
 	}
-	end := start + keyLen
+	// First 2 bytes stores the length of the value. The end is computed
+	// using int to avoid overflows on long values.
+	end := start + int(binary.LittleEndian.Uint16(bk[:start]))
 	switch {
-	case length < end:
+	case len(bk) < end:
 		return nil, bk
-	case length == end:
+	case len(bk) == end:
 		return bk[start:end], nil
 	default:
 		return bk[start:end], bk[end:]
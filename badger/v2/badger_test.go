@@ -1,10 +1,15 @@
 package badger
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"testing"
 
+	"github.com/dgraph-io/badger/v2"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
 )
 
 func Test_badgerEncode(t *testing.T) {
@@ -167,6 +172,11 @@ func Test_parseBadgerEncode(t *testing.T) {
 			args: args{[]byte{5, 0, 111, 111}},
 			want: ret{nil, []byte{5, 0, 111, 111}},
 		},
+		{
+			name: "fail/keylen-overflow",
+			args: args{[]byte{255, 255, 111, 111}},
+			want: ret{nil, []byte{255, 255, 111, 111}},
+		},
 		{
 			name: "ok/exact-length",
 			args: args{[]byte{5, 0, 104, 101, 108, 108, 111}},
@@ -223,3 +233,204 @@ func Test_isBadgerTable(t *testing.T) {
 		})
 	}
 }
+
+func FuzzBadgerKey(f *testing.F) {
+	f.Add([]byte("hello"), []byte("goodbye"))
+	f.Add([]byte{0}, []byte{255, 255})
+	f.Add(make([]byte, 65535), []byte{1})
+	f.Fuzz(func(t *testing.T, bucket, key []byte) {
+		bk, err := toBadgerKey(bucket, key)
+		if err != nil {
+			assert.True(t, len(bucket) == 0 || len(bucket) > 65535 || len(key) == 0 || len(key) > 65535)
+			return
+		}
+
+		// Round-trip.
+		b, k, err := fromBadgerKey(bk)
+		assert.FatalError(t, err)
+		assert.Equals(t, bucket, b)
+		assert.Equals(t, key, k)
+
+		// The key is in the bucket prefix, but it's not the table token.
+		prefix, err := badgerEncode(bucket)
+		assert.FatalError(t, err)
+		assert.True(t, bytes.HasPrefix(bk, prefix))
+		assert.True(t, isBadgerTable(prefix))
+		assert.False(t, isBadgerTable(bk))
+	})
+}
+
+func FuzzBadgerKey_prefix(f *testing.F) {
+	f.Add([]byte("a"), []byte("ab"), []byte("key"))
+	f.Add([]byte{1, 0, 'a'}, []byte{1, 0, 'a', 1, 0, 'b'}, []byte("key"))
+	f.Fuzz(func(t *testing.T, bucket, other, key []byte) {
+		if bytes.Equal(bucket, other) {
+			return
+		}
+		prefix, err := badgerEncode(bucket)
+		if err != nil {
+			return
+		}
+		// The prefix scans of a bucket never include the table token or
+		// the keys of other buckets.
+		if token, err := badgerEncode(other); err == nil {
+			assert.False(t, bytes.HasPrefix(token, prefix))
+		}
+		if bk, err := toBadgerKey(other, key); err == nil {
+			assert.False(t, bytes.HasPrefix(bk, prefix))
+		}
+	})
+}
+
+func FuzzParseBadgerEncode(f *testing.F) {
+	f.Add([]byte{5, 0, 104, 101, 108, 108, 111, 7, 0, 103, 111, 111, 100, 98, 121, 101})
+	f.Add([]byte{255, 255, 111, 111})
+	f.Add([]byte{254, 255})
+	f.Fuzz(func(t *testing.T, bk []byte) {
+		value, rest := parseBadgerEncode(bk)
+		if value == nil {
+			assert.Equals(t, bk, rest)
+		} else {
+			assert.Equals(t, len(bk), 2+len(value)+len(rest))
+		}
+
+		// Valid keys are the canonical encoding of their bucket and key.
+		if bucket, key, err := fromBadgerKey(bk); err == nil {
+			got, err := toBadgerKey(bucket, key)
+			assert.FatalError(t, err)
+			assert.Equals(t, bk, got)
+			assert.False(t, isBadgerTable(bk))
+		}
+	})
+}
+
+func openDB(t *testing.T, dir string) *DB {
+	t.Helper()
+	db := new(DB)
+	assert.FatalError(t, db.Open(dir, database.WithNoSync()))
+	return db
+}
+
+func listEntries(t *testing.T, db *DB, bucket []byte) map[string]string {
+	t.Helper()
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err, "List(%s)", bucket)
+	m := make(map[string]string, len(entries))
+	for _, e := range entries {
+		m[string(e.Key)] = string(e.Value)
+	}
+	return m
+}
+
+func TestDB_runPending(t *testing.T) {
+	src, nested := []byte("src"), []byte("src/nested")
+	dst := []byte("parent/dst")
+	for _, op := range []string{pendingRename, pendingCopy} {
+		t.Run(op, func(t *testing.T) {
+			db := openDB(t, t.TempDir())
+			defer db.Close()
+
+			assert.FatalError(t, db.CreateTable(nested))
+			want := make(map[string]string)
+			for i := 0; i < 100; i++ {
+				key, value := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+				assert.FatalError(t, db.Set(src, []byte(key), []byte(value)))
+				assert.FatalError(t, db.Set(nested, []byte(key), []byte(value)))
+				want[key] = value
+			}
+			seq, err := db.NextSequence(src)
+			assert.FatalError(t, err)
+			assert.FatalError(t, db.CreateTable(dst))
+			assert.True(t, database.IsErrBucketExists(db.runPending(op, src, dst)))
+			assert.FatalError(t, db.DeleteTable(dst))
+
+			assert.FatalError(t, db.runPending(op, src, dst))
+			assert.Equals(t, want, listEntries(t, db, dst))
+			next, err := db.NextSequence(dst)
+			assert.FatalError(t, err)
+			assert.True(t, next > seq, "sequence %d is not greater than %d", next, seq)
+			assert.Equals(t, want, listEntries(t, db, []byte("parent/dst/nested")))
+			tables, err := db.ListTables()
+			assert.FatalError(t, err)
+			if op == pendingRename {
+				assert.Equals(t, [][]byte{[]byte("parent"), dst, []byte("parent/dst/nested")}, tables)
+			} else {
+				assert.Equals(t, [][]byte{[]byte("parent"), dst, []byte("parent/dst/nested"), src, nested}, tables)
+				assert.Equals(t, want, listEntries(t, db, src))
+			}
+		})
+	}
+}
+
+func TestDB_resumePending(t *testing.T) {
+	src, dst := []byte("src"), []byte("dst")
+	for _, op := range []string{pendingRename, pendingCopy} {
+		t.Run(op, func(t *testing.T) {
+			dir := t.TempDir()
+			db := openDB(t, dir)
+			assert.FatalError(t, db.CreateTable(src))
+			want := map[string]string{"a": "1", "b": "2", "c": "3"}
+			for k, v := range want {
+				assert.FatalError(t, db.Set(src, []byte(k), []byte(v)))
+			}
+
+			// Simulate a crash after copying one key.
+			value, err := json.Marshal(&pendingOp{Op: op, Src: src, Dst: dst})
+			assert.FatalError(t, err)
+			assert.FatalError(t, db.update(func(txn *badger.Txn) error {
+				if err := createTable(txn, dst); err != nil {
+					return err
+				}
+				bk, err := toBadgerKey(dst, []byte("a"))
+				if err != nil {
+					return err
+				}
+				if err := txn.Set(bk, []byte("1")); err != nil {
+					return err
+				}
+				return txn.Set(pendingKey(dst), value)
+			}))
+			tables, err := db.ListTables()
+			assert.FatalError(t, err)
+			assert.Equals(t, [][]byte{dst, src}, tables)
+			assert.FatalError(t, db.Close())
+
+			db = openDB(t, dir)
+			defer db.Close()
+			assert.Equals(t, want, listEntries(t, db, dst))
+			exists, err := db.TableExists(src)
+			assert.FatalError(t, err)
+			assert.Equals(t, op == pendingCopy, exists)
+			assert.FatalError(t, db.view(func(txn *badger.Txn) error {
+				_, err := txn.Get(pendingKey(dst))
+				assert.True(t, errors.Is(err, badger.ErrKeyNotFound))
+				return nil
+			}))
+		})
+	}
+}
+
+func TestDB_NextSequence(t *testing.T) {
+	bucket := []byte("bucket")
+	dir := t.TempDir()
+	db := openDB(t, dir)
+	assert.FatalError(t, db.CreateTable(bucket))
+	for i := uint64(1); i <= 3; i++ {
+		seq, err := db.NextSequence(bucket)
+		assert.FatalError(t, err)
+		assert.Equals(t, i, seq)
+	}
+
+	// Close returns the unused numbers of the lease.
+	assert.FatalError(t, db.Close())
+	db = openDB(t, dir)
+	seq, err := db.NextSequence(bucket)
+	assert.FatalError(t, err)
+	assert.Equals(t, uint64(4), seq)
+
+	// The sequence is not an entry of the table.
+	assert.Equals(t, map[string]string{}, listEntries(t, db, bucket))
+	assert.FatalError(t, db.Close())
+	_, err = db.NextSequence(bucket)
+	assert.True(t, database.IsErrClosed(err))
+}
@@ -0,0 +1,32 @@
+package nosql_test
+
+import (
+	"testing"
+
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/nosqltest"
+)
+
+var benchDrivers = []string{
+	nosql.BadgerV1Driver,
+	nosql.BadgerV2Driver,
+	nosql.BBoltDriver,
+	nosql.MySQLDriver,
+	nosql.PostgreSQLDriver,
+}
+
+// BenchmarkDrivers runs the nosqltest benchmarks on all the compiled-in
+// drivers. SQL drivers use the same servers as the integration tests. The
+// results can be compared using:
+//
+//	go test -run '^$' -bench BenchmarkDrivers . | go run ./cmd/nosqlbench
+func BenchmarkDrivers(b *testing.B) {
+	for _, driver := range benchDrivers {
+		driver := driver
+		b.Run(driver, func(b *testing.B) {
+			nosqltest.RunBenchmarks(b, func(b *testing.B) nosql.DB {
+				return nosqltest.NewDB(b, driver, ciOptions(driver)...)
+			})
+		})
+	}
+}
@@ -5,6 +5,9 @@ package bolt
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/pkg/errors"
@@ -12,11 +15,12 @@ import (
 	bolt "go.etcd.io/bbolt"
 )
 
-var boltDBSep = []byte("/")
+var boltDBSep = []byte(database.BucketSeparator)
 
 // DB is a wrapper over bolt.DB,
 type DB struct {
-	db *bolt.DB
+	db         *bolt.DB
+	autoCreate bool
 }
 
 type boltBucket interface {
@@ -34,8 +38,12 @@ func (db *DB) Open(dataSourceName string, opt ...database.Option) (err error) {
 			return err
 		}
 	}
-	db.db, err = bolt.Open(dataSourceName, 0600, &bolt.Options{Timeout: 5 * time.Second})
-	return errors.WithStack(err)
+	db.db, err = bolt.Open(dataSourceName, 0600, &bolt.Options{
+		Timeout: 5 * time.Second,
+		NoSync:  opts.NoSync,
+	})
+	db.autoCreate = opts.AutoCreateTables
+	return errors.WithStack(mapError(err))
 }
 
 // Close closes the DB database.
@@ -45,7 +53,7 @@ func (db *DB) Close() error {
 
 // CreateTable creates a bucket or an embedded bucket if it does not exists.
 func (db *DB) CreateTable(bucket []byte) error {
-	return db.db.Update(func(tx *bolt.Tx) error {
+	return db.update(func(tx *bolt.Tx) error {
 		return db.createBucket(tx, bucket)
 	})
 }
@@ -53,14 +61,83 @@ func (db *DB) CreateTable(bucket []byte) error {
 // DeleteTable deletes a root or embedded bucket. Returns an error if the
 // bucket cannot be found or if the key represents a non-bucket value.
 func (db *DB) DeleteTable(bucket []byte) error {
-	return db.db.Update(func(tx *bolt.Tx) error {
+	return db.update(func(tx *bolt.Tx) error {
 		return db.deleteBucket(tx, bucket)
 	})
 }
 
+// RenameTable moves a root or embedded bucket, with all its keys and embedded
+// buckets, to a new name. Bolt cannot move buckets, so the bucket is copied
+// and then deleted in the same transaction.
+func (db *DB) RenameTable(oldBucket, newBucket []byte) error {
+	return db.update(func(tx *bolt.Tx) error {
+		if err := db.copyTable(tx, oldBucket, newBucket); err != nil {
+			return err
+		}
+		return db.deleteBucket(tx, oldBucket)
+	})
+}
+
+// CopyTable copies a root or embedded bucket, with all its keys and embedded
+// buckets, to a new bucket in one transaction.
+func (db *DB) CopyTable(srcBucket, dstBucket []byte) error {
+	return db.update(func(tx *bolt.Tx) error {
+		return db.copyTable(tx, srcBucket, dstBucket)
+	})
+}
+
+// ListTables returns the names of the root and nested buckets, the names of
+// the nested buckets are the names of their parents and their own name
+// separated by '/'.
+func (db *DB) ListTables() ([][]byte, error) {
+	var tables [][]byte
+	var walk func(prefix []byte, b *bolt.Bucket) error
+	walk = func(prefix []byte, b *bolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			if bytes.Equal(k, versionsBucket) {
+				return nil
+			}
+			if nested := b.Bucket(k); nested != nil {
+				name := joinBucket(prefix, k)
+				tables = append(tables, name)
+				return walk(name, nested)
+			}
+			return nil
+		})
+	}
+	err := db.view(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			name = cloneBytes(name)
+			tables = append(tables, name)
+			return walk(name, b)
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing buckets")
+	}
+	// Bolt sorts the buckets by level, "a/b" must go after "a-".
+	sort.Slice(tables, func(i, j int) bool {
+		return bytes.Compare(tables[i], tables[j]) < 0
+	})
+	return tables, nil
+}
+
+// TableExists returns true if the root or nested bucket exists.
+func (db *DB) TableExists(bucket []byte) (exists bool, err error) {
+	err = db.view(func(tx *bolt.Tx) error {
+		_, err := db.getBucket(tx, bucket)
+		exists = err == nil
+		return nil
+	})
+	return
+}
+
 // Get returns the value stored in the given bucked and key.
 func (db *DB) Get(bucket, key []byte) (ret []byte, err error) {
-	err = db.db.View(func(tx *bolt.Tx) error {
+	err = db.view(func(tx *bolt.Tx) error {
 		b, err := db.getBucket(tx, bucket)
 		if err != nil {
 			return err
@@ -77,32 +154,145 @@ func (db *DB) Get(bucket, key []byte) (ret []byte, err error) {
 	return
 }
 
+// GetMulti returns the values stored in the given bucket and keys. The keys
+// that do not exist are not in the result.
+func (db *DB) GetMulti(bucket []byte, keys [][]byte) (ret map[string][]byte, err error) {
+	err = db.view(func(tx *bolt.Tx) error {
+		ret, err = db.getMulti(tx, bucket, keys)
+		return err
+	})
+	return
+}
+
+// GetMultiBuckets returns the values stored in the given buckets and keys,
+// reading all the buckets in the same transaction.
+func (db *DB) GetMultiBuckets(keys map[string][][]byte) (ret map[string]map[string][]byte, err error) {
+	err = db.view(func(tx *bolt.Tx) error {
+		ret = make(map[string]map[string][]byte, len(keys))
+		for bucket, bucketKeys := range keys {
+			values, err := db.getMulti(tx, []byte(bucket), bucketKeys)
+			if err != nil {
+				return err
+			}
+			ret[bucket] = values
+		}
+		return nil
+	})
+	return
+}
+
+// getMulti returns the values stored in the given bucket and keys in the
+// given transaction.
+func (db *DB) getMulti(tx *bolt.Tx, bucket []byte, keys [][]byte) (map[string][]byte, error) {
+	b, err := db.getBucket(tx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if v := b.Get(key); v != nil {
+			ret[string(key)] = cloneBytes(v)
+		}
+	}
+	return ret, nil
+}
+
 // Set stores the given value on bucket and key.
 func (db *DB) Set(bucket, key, value []byte) error {
-	return db.db.Update(func(tx *bolt.Tx) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	return db.update(func(tx *bolt.Tx) error {
+		b, err := db.getBucket(tx, bucket)
+		if err != nil {
+			return err
+		}
+		return errors.WithStack(put(b, key, value))
+	})
+}
+
+// GetVersioned returns the value stored in the given bucket and key, and its
+// version. The first versioned read of a bucket creates its versions bucket,
+// so the following writes change the versions.
+func (db *DB) GetVersioned(bucket, key []byte) (ret []byte, version uint64, err error) {
+	var versioned bool
+	get := func(tx *bolt.Tx) error {
+		b, err := db.getBucket(tx, bucket)
+		if err != nil {
+			return err
+		}
+		v := b.Get(key)
+		if v == nil {
+			return errors.WithStack(database.ErrNotFound)
+		}
+		ret, version = cloneBytes(v), getVersion(b, key)
+		versioned = b.Bucket(versionsBucket) != nil
+		return nil
+	}
+	if err = db.view(get); err != nil || versioned {
+		return
+	}
+	err = db.update(func(tx *bolt.Tx) error {
+		if err := get(tx); err != nil {
+			return err
+		}
 		b, err := db.getBucket(tx, bucket)
 		if err != nil {
 			return err
 		}
-		return errors.WithStack(b.Put(key, value))
+		_, err = createVersions(b)
+		return errors.WithStack(err)
+	})
+	// Nothing can be written on a read-only database.
+	if database.IsErrReadOnly(err) {
+		err = nil
+	}
+	return
+}
+
+// SetIfVersion stores the given value on bucket and key only if the entry
+// has the given version, or if it does not exist and the version is 0.
+func (db *DB) SetIfVersion(bucket, key, value []byte, version uint64) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	return db.update(func(tx *bolt.Tx) error {
+		b, err := db.getBucket(tx, bucket)
+		if err != nil {
+			return err
+		}
+		var current uint64
+		if b.Get(key) != nil {
+			current = getVersion(b, key)
+		}
+		if current != version {
+			return errors.Wrapf(database.ErrVersionMismatch, "%s/%s has version %d", bucket, key, current)
+		}
+		if _, err := createVersions(b); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(put(b, key, value))
 	})
 }
 
 // Del deletes the value stored in the given bucked and key.
 func (db *DB) Del(bucket, key []byte) error {
-	return db.db.Update(func(tx *bolt.Tx) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	return db.update(func(tx *bolt.Tx) error {
 		b, err := db.getBucket(tx, bucket)
 		if err != nil {
 			return err
 		}
-		return errors.WithStack(b.Delete(key))
+		return errors.WithStack(del(b, key))
 	})
 }
 
 // List returns the full list of entries in a bucket.
 func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
 	var entries []*database.Entry
-	err := db.db.View(func(tx *bolt.Tx) error {
+	err := db.view(func(tx *bolt.Tx) error {
 		b, err := db.getBucket(tx, bucket)
 		if err != nil {
 			return errors.Wrap(err, "getBucket failed")
@@ -110,6 +300,10 @@ func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
 
 		c := b.Cursor()
 		for k, v := c.First(); k != nil; k, v = c.Next() {
+			// Skip nested buckets.
+			if v == nil && b.Bucket(k) != nil {
+				continue
+			}
 			entries = append(entries, &database.Entry{
 				Bucket: bucket,
 				Key:    cloneBytes(k),
@@ -121,17 +315,131 @@ func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
 	return entries, err
 }
 
+// ListKeys returns the keys of all the entries in a bucket, in order.
+func (db *DB) ListKeys(bucket []byte) ([][]byte, error) {
+	var keys [][]byte
+	err := db.view(func(tx *bolt.Tx) error {
+		b, err := db.getBucket(tx, bucket)
+		if err != nil {
+			return err
+		}
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			// Skip nested buckets.
+			if v == nil && b.Bucket(k) != nil {
+				continue
+			}
+			keys = append(keys, cloneBytes(k))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Count returns the number of entries in a bucket. It uses the stats of the
+// bucket, that only read the page headers, discounting the keys of the
+// nested buckets.
+func (db *DB) Count(bucket []byte) (n int, err error) {
+	err = db.view(func(tx *bolt.Tx) error {
+		b, err := db.getBucket(tx, bucket)
+		if err != nil {
+			return err
+		}
+
+		n = b.Stats().KeyN
+		return b.ForEachBucket(func(k []byte) error {
+			n -= 1 + b.Bucket(k).Stats().KeyN
+			return nil
+		})
+	})
+	return
+}
+
+// BulkLoad writes the entries of the iterator in the given bucket, using a
+// read-write transaction per batch. The entries of each batch are written in
+// order, and if they are appended after the existing keys, the pages are
+// filled completely instead of leaving room for later inserts.
+func (db *DB) BulkLoad(bucket []byte, iter database.Iterator, opts ...database.BulkOption) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	if err := db.view(func(tx *bolt.Tx) error {
+		_, err := db.getBucket(tx, bucket)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	o := database.NewBulkOptions(opts...)
+	return o.Run(iter, 0, func(batch []*database.Entry) error {
+		// A stable sort keeps the last value of duplicated keys.
+		sort.SliceStable(batch, func(i, j int) bool {
+			return bytes.Compare(batch[i].Key, batch[j].Key) < 0
+		})
+		return db.update(func(tx *bolt.Tx) error {
+			b, err := db.getBucket(tx, bucket)
+			if err != nil {
+				return err
+			}
+			if last, _ := b.Cursor().Last(); last == nil || bytes.Compare(last, batch[0].Key) < 0 {
+				b.FillPercent = 1.0
+			}
+			for _, e := range batch {
+				if err := put(b, e.Key, e.Value); err != nil {
+					return errors.Wrapf(err, "failed to set %s/%s", bucket, e.Key)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Truncate deletes all the entries in a bucket, but keeps the bucket and the
+// buckets nested in it.
+func (db *DB) Truncate(bucket []byte) error {
+	return db.DeleteRange(bucket, nil, nil)
+}
+
+// DeleteRange deletes the entries in a bucket with a key in the range from
+// start, inclusive, to end, exclusive.
+func (db *DB) DeleteRange(bucket, start, end []byte) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	return db.update(func(tx *bolt.Tx) error {
+		b, err := db.getBucket(tx, bucket)
+		if err != nil {
+			return err
+		}
+		return deleteRange(b, start, end)
+	})
+}
+
+// DeletePrefix deletes the entries in a bucket with a key that starts with
+// the given prefix.
+func (db *DB) DeletePrefix(bucket, prefix []byte) error {
+	return db.DeleteRange(bucket, prefix, database.PrefixEnd(prefix))
+}
+
 // CmpAndSwap modifies the value at the given bucket and key (to newValue)
 // only if the existing (current) value matches oldValue.
 func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return nil, false, err
+	}
+
 	boltTx, err := db.db.Begin(true)
 	if err != nil {
-		return nil, false, errors.Wrap(err, "error creating Bolt transaction")
+		return nil, false, errors.Wrap(mapError(err), "error creating Bolt transaction")
 	}
 
-	boltBucket := boltTx.Bucket(bucket)
-	if boltBucket == nil {
-		return nil, false, errors.Errorf("failed to get bucket %s", bucket)
+	boltBucket, err := db.getBucket(boltTx, bucket)
+	if err != nil {
+		if err := boltTx.Rollback(); err != nil {
+			return nil, false, errors.Wrapf(err, "failed to rollback CmpAndSwap transaction on %s/%s", bucket, key)
+		}
+		return nil, false, err
 	}
 
 	val, swapped, err := cmpAndSwap(boltBucket, key, oldValue, newValue)
@@ -140,10 +448,10 @@ func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool,
 		if err := boltTx.Rollback(); err != nil {
 			return nil, false, errors.Wrapf(err, "failed to execute CmpAndSwap transaction on %s/%s and failed to rollback transaction", bucket, key)
 		}
-		return nil, false, err
+		return nil, false, mapError(err)
 	case swapped:
 		if err := boltTx.Commit(); err != nil {
-			return nil, false, errors.Wrapf(err, "failed to commit badger transaction")
+			return nil, false, errors.Wrapf(mapError(err), "failed to commit Bolt transaction")
 		}
 		return val, swapped, nil
 	default:
@@ -160,15 +468,70 @@ func cmpAndSwap(boltBucket *bolt.Bucket, key, oldValue, newValue []byte) ([]byte
 		return cloneBytes(current), false, nil
 	}
 
-	if err := boltBucket.Put(key, newValue); err != nil {
+	if err := put(boltBucket, key, newValue); err != nil {
 		return nil, false, errors.Wrapf(err, "failed to set key %s", key)
 	}
 	return newValue, true, nil
 }
 
+// Incr adds delta to the counter stored in the given bucket and key, and
+// returns the new value.
+func (db *DB) Incr(bucket, key []byte, delta int64) (n int64, err error) {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return 0, err
+	}
+	err = db.update(func(tx *bolt.Tx) error {
+		b, err := db.getBucket(tx, bucket)
+		if err != nil {
+			return err
+		}
+		n, err = incr(b, key, delta)
+		return err
+	})
+	return
+}
+
+// NextSequence returns the next number of the sequence of the given bucket,
+// the native sequence of the Bolt bucket.
+func (db *DB) NextSequence(bucket []byte) (seq uint64, err error) {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return 0, err
+	}
+	err = db.update(func(tx *bolt.Tx) error {
+		b, err := db.getBucket(tx, bucket)
+		if err != nil {
+			return err
+		}
+		seq, err = b.NextSequence()
+		return errors.Wrapf(err, "error getting next sequence of bucket %s", bucket)
+	})
+	return
+}
+
+func incr(b *bolt.Bucket, key []byte, delta int64) (int64, error) {
+	n, err := database.AddCounter(b.Get(key), delta)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to increment key %s", key)
+	}
+	if err := put(b, key, database.FormatCounter(n)); err != nil {
+		return 0, errors.Wrapf(err, "failed to set key %s", key)
+	}
+	return n, nil
+}
+
 // Update performs multiple commands on one read-write transaction.
 func (db *DB) Update(tx *database.Tx) error {
-	return db.db.Update(func(boltTx *bolt.Tx) (err error) {
+	var buckets [][]byte
+	for _, q := range tx.Operations {
+		if q.Cmd.IsWrite() {
+			buckets = append(buckets, q.Bucket)
+		}
+	}
+	if err := db.autoCreateTables(buckets...); err != nil {
+		return err
+	}
+
+	return db.update(func(boltTx *bolt.Tx) (err error) {
 		var b *bolt.Bucket
 		for _, q := range tx.Operations {
 			// create or delete buckets
@@ -188,7 +551,9 @@ func (db *DB) Update(tx *database.Tx) error {
 			}
 
 			// For other operations, get bucket and perform operation
-			b = boltTx.Bucket(q.Bucket)
+			if b, err = db.getBucket(boltTx, q.Bucket); err != nil {
+				return err
+			}
 
 			switch q.Cmd {
 			case database.Get:
@@ -198,11 +563,11 @@ func (db *DB) Update(tx *database.Tx) error {
 				}
 				q.Result = cloneBytes(ret)
 			case database.Set:
-				if err = b.Put(q.Key, q.Value); err != nil {
+				if err = put(b, q.Key, q.Value); err != nil {
 					return errors.WithStack(err)
 				}
 			case database.Delete:
-				if err = b.Delete(q.Key); err != nil {
+				if err = del(b, q.Key); err != nil {
 					return errors.WithStack(err)
 				}
 			case database.CmpAndSwap:
@@ -210,6 +575,26 @@ func (db *DB) Update(tx *database.Tx) error {
 				if err != nil {
 					return errors.Wrapf(err, "failed to execute CmpAndSwap on %s/%s", q.Bucket, q.Key)
 				}
+			case database.Truncate:
+				if err = deleteRange(b, nil, nil); err != nil {
+					return err
+				}
+			case database.DeleteRange:
+				if err = deleteRange(b, q.Key, q.End); err != nil {
+					return err
+				}
+			case database.DeletePrefix:
+				if err = deleteRange(b, q.Key, database.PrefixEnd(q.Key)); err != nil {
+					return err
+				}
+			case database.Incr:
+				if q.Counter, err = incr(b, q.Key, q.Delta); err != nil {
+					return err
+				}
+			case database.NextSequence:
+				if q.Sequence, err = b.NextSequence(); err != nil {
+					return errors.Wrapf(err, "error getting next sequence of bucket %s", q.Bucket)
+				}
 			case database.CmpOrRollback:
 				return errors.Errorf("operation '%s' is not yet implemented", q.Cmd)
 			default:
@@ -220,6 +605,100 @@ func (db *DB) Update(tx *database.Tx) error {
 	})
 }
 
+// View performs multiple read commands on one read-only transaction. Unlike
+// Update, it does not wait for other writers.
+func (db *DB) View(tx *database.Tx) error {
+	if err := tx.CheckReadOnly(); err != nil {
+		return err
+	}
+
+	return db.view(func(boltTx *bolt.Tx) error {
+		for _, q := range tx.Operations {
+			b, err := db.getBucket(boltTx, q.Bucket)
+			if err != nil {
+				return err
+			}
+
+			switch q.Cmd {
+			case database.Get:
+				ret := b.Get(q.Key)
+				if ret == nil {
+					return errors.WithStack(database.ErrNotFound)
+				}
+				q.Result = cloneBytes(ret)
+			default:
+				return errors.Errorf("operation '%s' is not supported", q.Cmd)
+			}
+		}
+		return nil
+	})
+}
+
+// autoCreateTables creates the given buckets if they do not exist and the
+// database was opened with database.WithAutoCreateTables.
+func (db *DB) autoCreateTables(buckets ...[]byte) error {
+	if !db.autoCreate || len(buckets) == 0 {
+		return nil
+	}
+
+	// Avoid a write transaction if all the buckets exist.
+	var missing [][]byte
+	if err := db.view(func(tx *bolt.Tx) error {
+		for _, bucket := range buckets {
+			if _, err := db.getBucket(tx, bucket); err != nil {
+				missing = append(missing, bucket)
+			}
+		}
+		return nil
+	}); err != nil || len(missing) == 0 {
+		return err
+	}
+
+	return db.update(func(tx *bolt.Tx) error {
+		for _, bucket := range missing {
+			if err := db.createBucket(tx, bucket); err != nil {
+				return errors.Wrapf(err, "error creating bucket %s", bucket)
+			}
+		}
+		return nil
+	})
+}
+
+// copyTable copies the bucket src, with all its keys and embedded buckets, to
+// the new bucket dst in the given transaction.
+func (db *DB) copyTable(tx *bolt.Tx, src, dst []byte) error {
+	srcBucket, err := db.getBucket(tx, src)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(src, dst) || database.IsNestedBucket(dst, src) {
+		return errors.Wrapf(database.ErrInvalidKey, "cannot copy bucket %s to %s", src, dst)
+	}
+	if err := database.ValidateBucket(dst); err != nil {
+		return err
+	}
+	if _, err := db.getBucket(tx, dst); err == nil {
+		return errors.Wrapf(database.ErrBucketExists, "bucket %s already exists", dst)
+	}
+
+	parent := boltBucket(tx)
+	name := dst
+	if i := bytes.LastIndex(dst, boltDBSep); i >= 0 {
+		if err := db.createBucket(tx, dst[:i]); err != nil {
+			return err
+		}
+		if parent, err = db.getBucket(tx, dst[:i]); err != nil {
+			return err
+		}
+		name = dst[i+len(boltDBSep):]
+	}
+	dstBucket, err := parent.CreateBucket(name)
+	if err != nil {
+		return errors.Wrapf(err, "error creating bucket %s", dst)
+	}
+	return errors.Wrapf(copyBucket(srcBucket, dstBucket), "error copying bucket %s to %s", src, dst)
+}
+
 // getBucket returns the bucket supporting nested buckets, nested buckets are
 // bucket names separated by '/'.
 func (db *DB) getBucket(tx *bolt.Tx, name []byte) (b *bolt.Bucket, err error) {
@@ -231,7 +710,7 @@ func (db *DB) getBucket(tx *bolt.Tx, name []byte) (b *bolt.Bucket, err error) {
 			b = b.Bucket(n)
 		}
 		if b == nil {
-			return nil, database.ErrNotFound
+			return nil, errors.Wrapf(database.ErrBucketNotFound, "bucket %s does not exist", bytes.Join(buckets[:i+1], boltDBSep))
 		}
 	}
 	return
@@ -239,6 +718,9 @@ func (db *DB) getBucket(tx *bolt.Tx, name []byte) (b *bolt.Bucket, err error) {
 
 // createBucket creates a bucket or a nested bucket in the given transaction.
 func (db *DB) createBucket(tx *bolt.Tx, name []byte) (err error) {
+	if err := database.ValidateBucket(name); err != nil {
+		return err
+	}
 	b := boltBucket(tx)
 	buckets := bytes.Split(name, boltDBSep)
 	for _, name := range buckets {
@@ -251,20 +733,193 @@ func (db *DB) createBucket(tx *bolt.Tx, name []byte) (err error) {
 }
 
 // deleteBucket deletes a bucket or a nested bucked in the given transaction.
+// Bolt deletes the buckets nested in it.
 func (db *DB) deleteBucket(tx *bolt.Tx, name []byte) (err error) {
 	b := boltBucket(tx)
 	buckets := bytes.Split(name, boltDBSep)
 	last := len(buckets) - 1
 	for i := 0; i < last; i++ {
-		if buck := b.Bucket(buckets[i]); buck == nil {
-			return errors.Wrapf(database.ErrNotFound, "bucket %s does not exist", bytes.Join(buckets[0:i+1], boltDBSep))
+		buck := b.Bucket(buckets[i])
+		if buck == nil {
+			return errors.Wrapf(database.ErrBucketNotFound, "bucket %s does not exist", bytes.Join(buckets[0:i+1], boltDBSep))
 		}
+		b = buck
 	}
 	err = b.DeleteBucket(buckets[last])
 	if errors.Is(err, bolt.ErrBucketNotFound) {
-		return errors.Wrapf(database.ErrNotFound, "bucket %s does not exist", name)
+		return errors.Wrapf(mapError(err), "bucket %s does not exist", name)
 	}
-	return
+	return errors.WithStack(err)
+}
+
+// deleteRange deletes the keys of a bucket in the range from start, inclusive,
+// to end, exclusive, skipping the nested buckets.
+func deleteRange(b *bolt.Bucket, start, end []byte) error {
+	c := b.Cursor()
+	k, v := c.First()
+	if start != nil {
+		k, v = c.Seek(start)
+	}
+	for k != nil && database.InRange(k, nil, end) {
+		if v == nil && b.Bucket(k) != nil {
+			k, v = c.Next()
+			continue
+		}
+		// Deleting moves the cursor, seek the next key instead.
+		key := cloneBytes(k)
+		if err := c.Delete(); err != nil {
+			return errors.Wrapf(err, "error deleting key %s", key)
+		}
+		if err := deleteVersion(b, key); err != nil {
+			return errors.Wrapf(err, "error deleting version of key %s", key)
+		}
+		k, v = c.Seek(key)
+	}
+	return nil
+}
+
+// versionsBucket is the name of the bucket that stores the versions of the
+// entries of its parent. It's created by the first GetVersioned or
+// SetIfVersion on a bucket, the writes on the buckets without it only write
+// the values. Its name cannot be used on nested buckets because it has the
+// bucket separator, put rejects it as a key, and the operations on the
+// entries skip it like the rest of the nested buckets. The versions are kept
+// on a separate bucket instead of a header in the values, so the values
+// written before versions are still valid.
+var versionsBucket = []byte("\x00/versions")
+
+// getVersion returns the version of the given key. It returns 1 if the key
+// does not have a version, the version of the entries written before the
+// bucket was versioned.
+func getVersion(b *bolt.Bucket, key []byte) uint64 {
+	if vb := b.Bucket(versionsBucket); vb != nil {
+		if v := vb.Get(key); len(v) == 8 {
+			return binary.BigEndian.Uint64(v)
+		}
+	}
+	return 1
+}
+
+// createVersions returns the versions bucket of b, and creates it if it does
+// not exist. Its sequence starts at 1, the version of the existing entries.
+func createVersions(b *bolt.Bucket) (*bolt.Bucket, error) {
+	if vb := b.Bucket(versionsBucket); vb != nil {
+		return vb, nil
+	}
+	vb, err := b.CreateBucket(versionsBucket)
+	if err != nil {
+		return nil, err
+	}
+	return vb, vb.SetSequence(1)
+}
+
+// put stores the given value, with a new version if the bucket is versioned.
+// The versions come from the sequence of the versions bucket, so an entry
+// that is deleted and created again never reuses a version.
+func put(b *bolt.Bucket, key, value []byte) error {
+	if bytes.Equal(key, versionsBucket) {
+		return errors.Wrapf(database.ErrInvalidKey, "key %q is reserved", key)
+	}
+	if err := b.Put(key, value); err != nil {
+		return err
+	}
+	vb := b.Bucket(versionsBucket)
+	if vb == nil {
+		return nil
+	}
+	seq, err := vb.NextSequence()
+	if err != nil {
+		return err
+	}
+	version := make([]byte, 8)
+	binary.BigEndian.PutUint64(version, seq)
+	return vb.Put(key, version)
+}
+
+// del deletes the given key and its version.
+func del(b *bolt.Bucket, key []byte) error {
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+	return deleteVersion(b, key)
+}
+
+func deleteVersion(b *bolt.Bucket, key []byte) error {
+	if vb := b.Bucket(versionsBucket); vb != nil {
+		return vb.Delete(key)
+	}
+	return nil
+}
+
+// copyBucket copies the keys, the nested buckets and the sequence of src to
+// dst.
+func copyBucket(src, dst *bolt.Bucket) error {
+	err := src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			if nested := src.Bucket(k); nested != nil {
+				b, err := dst.CreateBucket(cloneBytes(k))
+				if err != nil {
+					return err
+				}
+				return copyBucket(nested, b)
+			}
+		}
+		// The values must be valid until the transaction is committed, and
+		// the source bucket might be deleted.
+		return dst.Put(cloneBytes(k), cloneBytes(v))
+	})
+	if err != nil {
+		return err
+	}
+	return dst.SetSequence(src.Sequence())
+}
+
+// view runs fn in a read-only transaction.
+func (db *DB) view(fn func(tx *bolt.Tx) error) error {
+	return mapError(db.db.View(fn))
+}
+
+// update runs fn in a read-write transaction.
+func (db *DB) update(fn func(tx *bolt.Tx) error) error {
+	return mapError(db.db.Update(fn))
+}
+
+// mapError returns an error that matches the database error equivalent to
+// the given Bolt error, and keeps the Bolt error as its cause.
+func mapError(err error) error {
+	var target error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, bolt.ErrBucketNotFound):
+		target = database.ErrBucketNotFound
+	case errors.Is(err, bolt.ErrBucketExists):
+		target = database.ErrBucketExists
+	case errors.Is(err, bolt.ErrKeyRequired), errors.Is(err, bolt.ErrKeyTooLarge),
+		errors.Is(err, bolt.ErrBucketNameRequired), errors.Is(err, bolt.ErrIncompatibleValue):
+		target = database.ErrInvalidKey
+	case errors.Is(err, bolt.ErrDatabaseNotOpen):
+		target = database.ErrClosed
+	case errors.Is(err, bolt.ErrDatabaseReadOnly), errors.Is(err, bolt.ErrTxNotWritable):
+		target = database.ErrReadOnly
+	case errors.Is(err, bolt.ErrTimeout):
+		// The file is locked by another process.
+		target = database.ErrConflict
+	default:
+		return err
+	}
+	if errors.Is(err, target) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", target, err)
+}
+
+// joinBucket returns the name of the nested bucket name in the parent bucket.
+func joinBucket(parent, name []byte) []byte {
+	b := make([]byte, 0, len(parent)+len(boltDBSep)+len(name))
+	b = append(b, parent...)
+	b = append(b, boltDBSep...)
+	return append(b, name...)
 }
 
 // cloneBytes returns a copy of a given slice.
@@ -0,0 +1,207 @@
+// Command nosqlbench renders the results of the driver benchmarks as a table
+// with a row per workload and a column per driver.
+//
+// It reads the output of go test from the files given as arguments, or from
+// the standard input:
+//
+//	go test -run '^$' -bench BenchmarkDrivers -count 5 . | go run ./cmd/nosqlbench
+//
+// Benchmark names are expected to have the form Benchmark<Suite>/<driver>/<workload>,
+// repeated results of the same benchmark are averaged.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: nosqlbench [-metric unit] [-markdown] [file ...]")
+	flag.PrintDefaults()
+}
+
+func main() {
+	metric := flag.String("metric", "ns/op", "the `unit` of the metric to compare, e.g. ns/op, B/op or allocs/op")
+	markdown := flag.Bool("markdown", false, "render the table using markdown")
+	flag.Usage = usage
+	flag.Parse()
+
+	var readers []io.Reader
+	if flag.NArg() == 0 {
+		readers = append(readers, os.Stdin)
+	}
+	for _, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	results, err := parse(io.MultiReader(readers...))
+	if err != nil {
+		fatal(err)
+	}
+	t := newTable(results, *metric)
+	if len(t.rows) == 0 {
+		fatal(fmt.Errorf("no benchmark results with %s found", *metric))
+	}
+	if *markdown {
+		t.writeMarkdown(os.Stdout)
+	} else {
+		t.writeText(os.Stdout)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "nosqlbench:", err)
+	os.Exit(1)
+}
+
+// result is a benchmark result.
+type result struct {
+	name    string
+	metrics map[string]float64
+}
+
+// procsSuffix matches the GOMAXPROCS suffix added to the benchmark names.
+var procsSuffix = regexp.MustCompile(`-\d+$`)
+
+// parse reads the benchmark results in the go test output. Drivers might
+// log while a benchmark runs, so the result of a benchmark can be on a line
+// after its name.
+func parse(r io.Reader) ([]result, error) {
+	var (
+		results []result
+		pending string
+	)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "Benchmark") {
+			pending = procsSuffix.ReplaceAllString(fields[0], "")
+			fields = fields[1:]
+		}
+		if pending == "" || len(fields) < 3 {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[0]); err != nil {
+			continue
+		}
+		res := result{name: pending, metrics: make(map[string]float64)}
+		for i := 1; i+1 < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			res.metrics[fields[i+1]] = v
+		}
+		results = append(results, res)
+		pending = ""
+	}
+	return results, sc.Err()
+}
+
+// table is the comparison table of a metric.
+type table struct {
+	metric  string
+	columns []string
+	rows    []string
+	cells   map[string]map[string][]float64
+}
+
+// newTable builds the table of the given metric. Rows and columns keep the
+// order of the results.
+func newTable(results []result, metric string) *table {
+	t := &table{
+		metric: metric,
+		cells:  make(map[string]map[string][]float64),
+	}
+	columns := make(map[string]bool)
+	for _, res := range results {
+		v, ok := res.metrics[metric]
+		if !ok {
+			continue
+		}
+		column, row := split(res.name)
+		if !columns[column] {
+			columns[column] = true
+			t.columns = append(t.columns, column)
+		}
+		if t.cells[row] == nil {
+			t.rows = append(t.rows, row)
+			t.cells[row] = make(map[string][]float64)
+		}
+		t.cells[row][column] = append(t.cells[row][column], v)
+	}
+	return t
+}
+
+// split returns the driver and the workload of a benchmark name.
+func split(name string) (driver, workload string) {
+	parts := strings.SplitN(name, "/", 3)
+	if len(parts) < 3 {
+		return "", strings.TrimPrefix(name, "Benchmark")
+	}
+	return parts[1], parts[2]
+}
+
+func (t *table) cell(row, column string) string {
+	values := t.cells[row][column]
+	if len(values) == 0 {
+		return "-"
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if t.metric == "ns/op" {
+		d := time.Duration(mean)
+		switch {
+		case d >= time.Second:
+			d = d.Round(time.Millisecond)
+		case d >= time.Millisecond:
+			d = d.Round(time.Microsecond)
+		}
+		return d.String()
+	}
+	return strconv.FormatFloat(mean, 'f', -1, 64)
+}
+
+func (t *table) writeText(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%s\t%s\t\n", t.metric, strings.Join(t.columns, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintf(tw, "%s\t", row)
+		for _, column := range t.columns {
+			fmt.Fprintf(tw, "%s\t", t.cell(row, column))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+func (t *table) writeMarkdown(w io.Writer) {
+	fmt.Fprintf(w, "| %s | %s |\n", t.metric, strings.Join(t.columns, " | "))
+	fmt.Fprintf(w, "|---%s|\n", strings.Repeat("|--:", len(t.columns)))
+	for _, row := range t.rows {
+		cells := make([]string, len(t.columns))
+		for i, column := range t.columns {
+			cells[i] = t.cell(row, column)
+		}
+		fmt.Fprintf(w, "| %s | %s |\n", row, strings.Join(cells, " | "))
+	}
+}
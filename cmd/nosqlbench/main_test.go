@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+const output = `goos: linux
+goarch: amd64
+pkg: github.com/smallstep/nosql
+BenchmarkDrivers/badgerv2/Get     	badger 2024/01/01 00:00:00 INFO: All 0 tables opened in 0s
+     200	      2000 ns/op	    1200 B/op	      18 allocs/op
+BenchmarkDrivers/badgerv2/Get-8   	     200	      4000 ns/op	    1200 B/op	      18 allocs/op
+BenchmarkDrivers/bbolt/Get-8      	     200	      1753 ns/op	     664 B/op	      12 allocs/op
+BenchmarkDrivers/bbolt/List/entries=10000-8	     200	   3486145 ns/op	 2563644 B/op	   31609 allocs/op
+--- SKIP: BenchmarkDrivers/mysql
+PASS
+`
+
+func TestParse(t *testing.T) {
+	results, err := parse(strings.NewReader(output))
+	assert.FatalError(t, err)
+	assert.Len(t, 4, results)
+	assert.Equals(t, "BenchmarkDrivers/badgerv2/Get", results[0].name)
+	assert.Equals(t, 2000.0, results[0].metrics["ns/op"])
+	assert.Equals(t, "BenchmarkDrivers/bbolt/List/entries=10000", results[3].name)
+	assert.Equals(t, 31609.0, results[3].metrics["allocs/op"])
+}
+
+func TestTable(t *testing.T) {
+	results, err := parse(strings.NewReader(output))
+	assert.FatalError(t, err)
+
+	var buf bytes.Buffer
+	newTable(results, "ns/op").writeMarkdown(&buf)
+	assert.Equals(t, `| ns/op | badgerv2 | bbolt |
+|---|--:|--:|
+| Get | 3µs | 1.753µs |
+| List/entries=10000 | - | 3.486ms |
+`, buf.String())
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// BucketSeparator is the separator of the names of nested buckets, "a/b" is
+// the bucket "b" nested in the bucket "a".
+//
+// All the drivers support nested buckets, bolt natively and the rest using
+// the full name as the name of the table. Creating a nested bucket creates its
+// parents, deleting a bucket deletes all the buckets nested in it, and
+// renaming a bucket moves all the buckets nested in it.
+const BucketSeparator = "/"
+
+var bucketSep = []byte(BucketSeparator)
+
+// SequencesTable is the name of the table used by the SQL drivers to store the
+// sequences of the other tables. It's reserved on all the drivers.
+const SequencesTable = "nosql_sequences"
+
+// ValidateBucket returns ErrInvalidKey if the name of the bucket, or the name
+// of any of its parents, is empty, or if the name is reserved.
+func ValidateBucket(bucket []byte) error {
+	if bytes.EqualFold(bucket, []byte(SequencesTable)) {
+		return fmt.Errorf("%w: bucket name %q is reserved", ErrInvalidKey, bucket)
+	}
+	for _, name := range bytes.Split(bucket, bucketSep) {
+		if len(name) == 0 {
+			return fmt.Errorf("%w: bucket name %q is not valid", ErrInvalidKey, bucket)
+		}
+	}
+	return nil
+}
+
+// ParentBuckets returns the names of the parents of a nested bucket, starting
+// with the root bucket. It returns nil if the bucket is a root bucket.
+func ParentBuckets(bucket []byte) [][]byte {
+	var parents [][]byte
+	for i, c := range bucket {
+		if c == bucketSep[0] {
+			parents = append(parents, bucket[:i:i])
+		}
+	}
+	return parents
+}
+
+// IsNestedBucket returns true if bucket is nested, at any depth, in parent.
+func IsNestedBucket(bucket, parent []byte) bool {
+	return len(bucket) > len(parent)+len(bucketSep) &&
+		bytes.HasPrefix(bucket, parent) &&
+		bytes.HasPrefix(bucket[len(parent):], bucketSep)
+}
+
+// NestedBuckets returns the names in tables nested, at any depth, in the
+// given bucket.
+func NestedBuckets(tables [][]byte, bucket []byte) [][]byte {
+	var nested [][]byte
+	for _, table := range tables {
+		if IsNestedBucket(table, bucket) {
+			nested = append(nested, table)
+		}
+	}
+	return nested
+}
+
+// TableLister is an interface implemented by those databases that can
+// enumerate their tables or buckets.
+type TableLister interface {
+	// ListTables returns the names of all the tables or buckets in the
+	// database, sorted in lexicographical order.
+	ListTables() ([][]byte, error)
+	// TableExists returns true if the given table or bucket exists.
+	TableExists(bucket []byte) (bool, error)
+}
+
+// ListTables returns the tables or buckets of the first TableLister in the
+// chain of wrapped databases starting at db. It returns ErrOpNotSupported if
+// none of them implements TableLister.
+func ListTables(db DB) ([][]byte, error) {
+	var l TableLister
+	if As(db, &l) {
+		return l.ListTables()
+	}
+	return nil, ErrOpNotSupported
+}
+
+// TableExists returns true if the given table or bucket exists, using the
+// first TableLister in the chain of wrapped databases starting at db. It
+// returns ErrOpNotSupported if none of them implements TableLister.
+func TableExists(db DB, bucket []byte) (bool, error) {
+	var l TableLister
+	if As(db, &l) {
+		return l.TableExists(bucket)
+	}
+	return false, ErrOpNotSupported
+}
+
+// TableRenamer is an interface implemented by those databases that can rename
+// a table or a bucket.
+type TableRenamer interface {
+	// RenameTable renames a table or a bucket, and all the buckets nested in
+	// it, creating the parents of the new bucket if necessary. It fails with
+	// ErrBucketExists if the new bucket exists.
+	RenameTable(oldBucket, newBucket []byte) error
+}
+
+// RenameTable renames a table or a bucket using the first TableRenamer in the
+// chain of wrapped databases starting at db. It returns ErrOpNotSupported if
+// none of them implements TableRenamer.
+func RenameTable(db DB, oldBucket, newBucket []byte) error {
+	var r TableRenamer
+	if As(db, &r) {
+		return r.RenameTable(oldBucket, newBucket)
+	}
+	return ErrOpNotSupported
+}
+
+// TableCopier is an interface implemented by those databases that can copy a
+// table or a bucket.
+type TableCopier interface {
+	// CopyTable copies a table or a bucket, and all the buckets nested in
+	// it, creating the parents of the new bucket if necessary. It fails with
+	// ErrBucketExists if the new bucket exists.
+	CopyTable(srcBucket, dstBucket []byte) error
+}
+
+// CopyTable copies a table or a bucket using the first TableCopier in the
+// chain of wrapped databases starting at db. It returns ErrOpNotSupported if
+// none of them implements TableCopier.
+func CopyTable(db DB, srcBucket, dstBucket []byte) error {
+	var c TableCopier
+	if As(db, &c) {
+		return c.CopyTable(srcBucket, dstBucket)
+	}
+	return ErrOpNotSupported
+}
+
+// ListChildren returns the names of the buckets nested directly in the given
+// bucket, or the root buckets if bucket is empty. It fails with
+// ErrBucketNotFound if the bucket does not exist, and with ErrOpNotSupported
+// if the database does not implement TableLister.
+func ListChildren(db DB, bucket []byte) ([][]byte, error) {
+	if len(bucket) > 0 {
+		exists, err := TableExists(db, bucket)
+		switch {
+		case err != nil:
+			return nil, err
+		case !exists:
+			return nil, fmt.Errorf("%w: %s", ErrBucketNotFound, bucket)
+		}
+	}
+
+	tables, err := ListTables(db)
+	if err != nil {
+		return nil, err
+	}
+	var children [][]byte
+	for _, table := range tables {
+		if len(bucket) > 0 {
+			if !IsNestedBucket(table, bucket) {
+				continue
+			}
+			if bytes.Contains(table[len(bucket)+len(bucketSep):], bucketSep) {
+				continue
+			}
+		} else if bytes.Contains(table, bucketSep) {
+			continue
+		}
+		children = append(children, table)
+	}
+	return children, nil
+}
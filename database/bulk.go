@@ -0,0 +1,162 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultBulkBatchSize is the number of entries written on each batch of
+// BulkLoad if WithBatchSize is not used.
+const DefaultBulkBatchSize = 1000
+
+// BulkLoader is an interface implemented by those databases that can write
+// large amounts of entries faster than using one Set per entry.
+type BulkLoader interface {
+	// BulkLoad writes the entries of the iterator in the given table/bucket,
+	// overwriting the existing keys, using batches that are much faster than
+	// one Set per entry. It is not atomic, the batches written before an
+	// error are kept.
+	BulkLoad(bucket []byte, iter Iterator, opts ...BulkOption) error
+}
+
+// BulkLoad writes the entries of the iterator in the given table/bucket using
+// the first BulkLoader in the chain of wrapped databases starting at db. It
+// returns ErrOpNotSupported if none of them implements BulkLoader.
+func BulkLoad(db DB, bucket []byte, iter Iterator, opts ...BulkOption) error {
+	var l BulkLoader
+	if As(db, &l) {
+		return l.BulkLoad(bucket, iter, opts...)
+	}
+	return ErrOpNotSupported
+}
+
+// Iterator is the source of the entries written by BulkLoad.
+type Iterator interface {
+	// Next returns the key and value of the next entry, or io.EOF if there
+	// are no more entries. The key and value are not retained after the next
+	// call, so implementations can reuse them.
+	Next() (key, value []byte, err error)
+}
+
+// IteratorFunc is an adapter that allows using a function as an Iterator.
+type IteratorFunc func() (key, value []byte, err error)
+
+// Next implements the Iterator interface.
+func (fn IteratorFunc) Next() (key, value []byte, err error) {
+	return fn()
+}
+
+// EntriesIterator returns an Iterator over the keys and values of the given
+// entries, e.g. the entries returned by List. The buckets are ignored.
+func EntriesIterator(entries []*Entry) Iterator {
+	var i int
+	return IteratorFunc(func() ([]byte, []byte, error) {
+		if i >= len(entries) {
+			return nil, nil, io.EOF
+		}
+		e := entries[i]
+		i++
+		return e.Key, e.Value, nil
+	})
+}
+
+// BulkOptions are the configuration options of BulkLoad.
+type BulkOptions struct {
+	// BatchSize is the maximum number of entries written on each batch.
+	BatchSize int
+	// Progress, if set, is called after each batch is written with the
+	// total number of entries written.
+	Progress func(loaded int)
+}
+
+// BulkOption is the modifier type over BulkOptions.
+type BulkOption func(o *BulkOptions)
+
+// NewBulkOptions returns the BulkOptions with the given modifiers applied
+// over the defaults.
+func NewBulkOptions(opts ...BulkOption) *BulkOptions {
+	o := &BulkOptions{
+		BatchSize: DefaultBulkBatchSize,
+	}
+	for _, fn := range opts {
+		fn(o)
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBulkBatchSize
+	}
+	return o
+}
+
+// WithBatchSize is a modifier that sets the maximum number of entries
+// written on each batch of BulkLoad. Drivers might use smaller batches to
+// stay within the limits of the database.
+func WithBatchSize(n int) BulkOption {
+	return func(o *BulkOptions) {
+		o.BatchSize = n
+	}
+}
+
+// WithProgress is a modifier that adds a callback called after each batch of
+// BulkLoad is written, with the total number of entries written. Multiple
+// callbacks are called in the order they were added.
+func WithProgress(fn func(loaded int)) BulkOption {
+	return func(o *BulkOptions) {
+		if prev := o.Progress; prev != nil {
+			o.Progress = func(loaded int) {
+				prev(loaded)
+				fn(loaded)
+			}
+			return
+		}
+		o.Progress = fn
+	}
+}
+
+// Run reads the entries of the iterator in batches of up to BatchSize
+// entries, or max entries if max is lower and greater than zero, and calls
+// write with each batch, reporting the progress after each one. The keys and
+// values of the entries are copies, so write can retain them.
+func (o *BulkOptions) Run(iter Iterator, max int, write func(batch []*Entry) error) error {
+	n := o.BatchSize
+	if max > 0 && max < n {
+		n = max
+	}
+
+	var loaded int
+	for {
+		batch, err := nextBatch(iter, n)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("error reading entries: %w", err)
+		}
+		if len(batch) > 0 {
+			if err := write(batch); err != nil {
+				return err
+			}
+			loaded += len(batch)
+			if o.Progress != nil {
+				o.Progress(loaded)
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// nextBatch reads up to n entries from the iterator. It returns io.EOF with
+// the last entries when the iterator is exhausted.
+func nextBatch(iter Iterator, n int) ([]*Entry, error) {
+	batch := make([]*Entry, 0, n)
+	for len(batch) < n {
+		key, value, err := iter.Next()
+		if err != nil {
+			return batch, err
+		}
+		batch = append(batch, &Entry{
+			Key:   append([]byte{}, key...),
+			Value: append([]byte{}, value...),
+		})
+	}
+	return batch, nil
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestBulkOptions_Run(t *testing.T) {
+	entries := make([]*Entry, 5)
+	for i := range entries {
+		entries[i] = &Entry{Key: []byte{byte(i)}, Value: []byte{byte(i)}}
+	}
+
+	var progress, other []int
+	o := NewBulkOptions(WithBatchSize(2), WithProgress(func(loaded int) {
+		progress = append(progress, loaded)
+	}), WithProgress(func(loaded int) {
+		other = append(other, loaded)
+	}))
+
+	var sizes []int
+	assert.FatalError(t, o.Run(EntriesIterator(entries), 0, func(batch []*Entry) error {
+		sizes = append(sizes, len(batch))
+		return nil
+	}))
+	assert.Equals(t, []int{2, 2, 1}, sizes)
+	assert.Equals(t, []int{2, 4, 5}, progress)
+	assert.Equals(t, []int{2, 4, 5}, other)
+
+	// The driver limit overrides larger batches.
+	sizes = nil
+	assert.FatalError(t, o.Run(EntriesIterator(entries), 1, func(batch []*Entry) error {
+		sizes = append(sizes, len(batch))
+		return nil
+	}))
+	assert.Equals(t, []int{1, 1, 1, 1, 1}, sizes)
+
+	assert.Equals(t, DefaultBulkBatchSize, NewBulkOptions().BatchSize)
+	assert.Equals(t, DefaultBulkBatchSize, NewBulkOptions(WithBatchSize(-1)).BatchSize)
+}
@@ -0,0 +1,35 @@
+package database
+
+import "context"
+
+// ContextBinder is implemented by the databases that can run the operations
+// of a DB under a caller provided context. The databases wrapping another one
+// should implement it binding the wrapped database, if it's a ContextBinder,
+// to the same context, so the context reaches the driver.
+type ContextBinder interface {
+	// WithContext returns a shallow copy of the database that uses the given
+	// context on all its operations.
+	WithContext(ctx context.Context) DB
+}
+
+// StatementHook is called by the SQL drivers before running a statement. It
+// returns the context used to run the statement and a function that is called
+// with the result of the statement.
+type StatementHook func(ctx context.Context, query string) (context.Context, func(err error))
+
+type statementHookKey struct{}
+
+// ContextWithStatementHook returns a copy of ctx that carries the given
+// StatementHook.
+func ContextWithStatementHook(ctx context.Context, hook StatementHook) context.Context {
+	return context.WithValue(ctx, statementHookKey{}, hook)
+}
+
+// StartStatement calls the StatementHook carried by ctx, if any. The returned
+// function must be called with the result of the statement.
+func StartStatement(ctx context.Context, query string) (context.Context, func(err error)) {
+	if hook, ok := ctx.Value(statementHookKey{}).(StatementHook); ok && hook != nil {
+		return hook(ctx, query)
+	}
+	return ctx, func(error) {}
+}
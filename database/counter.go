@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Counter is an interface implemented by those databases that support atomic
+// counters and sequences.
+type Counter interface {
+	// Incr atomically adds delta to the counter stored in the given
+	// table/bucket and key, and returns the new value. A missing key is a
+	// counter with value 0. Counters are stored as base 10 integers, see
+	// FormatCounter and ParseCounter. It fails if the value is not a counter
+	// or if the result overflows.
+	Incr(bucket, key []byte, delta int64) (int64, error)
+	// NextSequence returns the next number of the sequence of the given
+	// table/bucket. Sequences start at 1 and always increase, but they might
+	// have gaps. Truncate keeps the sequence of a bucket, and DeleteTable
+	// resets it.
+	NextSequence(bucket []byte) (uint64, error)
+}
+
+// IncrCounter atomically adds delta to the counter stored in the given
+// table/bucket and key using the first Counter in the chain of wrapped
+// databases starting at db. It returns ErrOpNotSupported if none of them
+// implements Counter.
+func IncrCounter(db DB, bucket, key []byte, delta int64) (int64, error) {
+	var c Counter
+	if As(db, &c) {
+		return c.Incr(bucket, key, delta)
+	}
+	return 0, ErrOpNotSupported
+}
+
+// NextTableSequence returns the next number of the sequence of the given
+// table/bucket using the first Counter in the chain of wrapped databases
+// starting at db. It returns ErrOpNotSupported if none of them implements
+// Counter.
+func NextTableSequence(db DB, bucket []byte) (uint64, error) {
+	var c Counter
+	if As(db, &c) {
+		return c.NextSequence(bucket)
+	}
+	return 0, ErrOpNotSupported
+}
+
+// Counters are stored as base 10 integers, so they can be read with Get and
+// incremented atomically by the SQL databases.
+
+// FormatCounter returns the value stored by Incr for the given counter.
+func FormatCounter(n int64) []byte {
+	return strconv.AppendInt(nil, n, 10)
+}
+
+// ParseCounter returns the counter stored in a value written by Incr.
+func ParseCounter(value []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value %q is not a counter", value)
+	}
+	return n, nil
+}
+
+// AddCounter returns the counter stored in the given value plus delta. A nil
+// value, a missing key, is a counter with value 0. It fails if the value is
+// not a counter or if the result overflows an int64.
+func AddCounter(value []byte, delta int64) (int64, error) {
+	var n int64
+	if value != nil {
+		var err error
+		if n, err = ParseCounter(value); err != nil {
+			return 0, err
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, errors.New("counter overflow")
+	}
+	return n + delta, nil
+}
+
+// IncrMaxAttempts is the maximum number of times the drivers run an Incr that
+// fails with ErrConflict, see RetryConflict.
+const IncrMaxAttempts = 10
+
+// RetryConflict runs fn until it does not fail with ErrConflict, up to
+// IncrMaxAttempts times, waiting an exponential backoff with jitter between
+// the attempts. It returns the last error, or the error of ctx if it's done
+// before the next attempt. It's used by the drivers to run Incr, that cannot
+// be retried by the callers, so fn must only fail with ErrConflict if the
+// transaction did not commit.
+func RetryConflict(ctx context.Context, fn func() error) (err error) {
+	delay := time.Millisecond
+	for attempt := 1; ; attempt++ {
+		if err = fn(); !IsErrConflict(err) || attempt >= IncrMaxAttempts {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		//nolint:gosec // jitter does not require a secure random source
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(delay))))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if delay < 100*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestAddCounter(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   []byte
+		delta   int64
+		want    int64
+		wantErr bool
+	}{
+		{"missing", nil, 5, 5, false},
+		{"positive", []byte("10"), 5, 15, false},
+		{"negative", []byte("10"), -15, -5, false},
+		{"zero", []byte("-3"), 0, -3, false},
+		{"max", FormatCounter(math.MaxInt64 - 1), 1, math.MaxInt64, false},
+		{"min", FormatCounter(math.MinInt64 + 1), -1, math.MinInt64, false},
+		{"overflow", FormatCounter(math.MaxInt64), 1, 0, true},
+		{"underflow", FormatCounter(math.MinInt64), -1, 0, true},
+		{"empty", []byte{}, 1, 0, true},
+		{"invalid", []byte("one"), 1, 0, true},
+		{"binary", []byte{0, 0, 0, 1}, 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AddCounter(tt.value, tt.delta)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.want, got)
+
+			n, err := ParseCounter(FormatCounter(got))
+			assert.FatalError(t, err)
+			assert.Equals(t, got, n)
+		})
+	}
+}
+
+func TestRetryConflict(t *testing.T) {
+	conflict := fmt.Errorf("%w: native conflict", ErrConflict)
+	failing := func(calls *int, failures int, err error) func() error {
+		return func() error {
+			if *calls++; *calls <= failures {
+				return err
+			}
+			return nil
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		var calls int
+		assert.NoError(t, RetryConflict(context.Background(), failing(&calls, 3, conflict)))
+		assert.Equals(t, 4, calls)
+	})
+
+	t.Run("fail/attempts", func(t *testing.T) {
+		var calls int
+		err := RetryConflict(context.Background(), failing(&calls, IncrMaxAttempts, conflict))
+		assert.True(t, IsErrConflict(err))
+		assert.Equals(t, IncrMaxAttempts, calls)
+	})
+
+	t.Run("fail/not-conflict", func(t *testing.T) {
+		var calls int
+		err := RetryConflict(context.Background(), failing(&calls, 3, errors.New("permanent")))
+		assert.Equals(t, "permanent", err.Error())
+		assert.Equals(t, 1, calls)
+	})
+
+	t.Run("fail/context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var calls int
+		err := RetryConflict(ctx, failing(&calls, 3, conflict))
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equals(t, 1, calls)
+	})
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"bytes"
 	"fmt"
 
 	"errors"
@@ -13,6 +14,32 @@ var (
 	// ErrOpNotSupported is the type returned on DB implementations if an operation
 	// is not supported.
 	ErrOpNotSupported = errors.New("operation not supported")
+	// ErrBucketNotFound is the type returned on DB implementations if a table
+	// or bucket does not exist. It wraps ErrNotFound, so IsErrNotFound also
+	// returns true, but IsErrBucketNotFound can tell it apart from a missing key.
+	ErrBucketNotFound = fmt.Errorf("bucket %w", ErrNotFound)
+	// ErrBucketExists is the type returned on DB implementations if a table or
+	// bucket cannot be created because it already exists.
+	ErrBucketExists = errors.New("bucket already exists")
+	// ErrConflict is the type returned on DB implementations if a transaction
+	// conflicts with a concurrent one, or if the database cannot be opened
+	// because another process holds its lock. The operation can be retried.
+	ErrConflict = errors.New("transaction conflict")
+	// ErrTxTooLarge is the type returned on DB implementations if a
+	// transaction exceeds the limits of the database.
+	ErrTxTooLarge = errors.New("transaction too large")
+	// ErrInvalidKey is the type returned on DB implementations if a key or a
+	// bucket name is empty, too long, or not allowed by the database.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrClosed is the type returned on DB implementations if the database is
+	// used after closing it.
+	ErrClosed = errors.New("database closed")
+	// ErrReadOnly is the type returned on DB implementations if a write is
+	// attempted on a read-only database or transaction.
+	ErrReadOnly = errors.New("database is read-only")
+	// ErrVersionMismatch is the type returned on DB implementations if
+	// SetIfVersion fails because the entry has a different version.
+	ErrVersionMismatch = errors.New("version mismatch")
 )
 
 // IsErrNotFound returns true if the cause of the given error is ErrNotFound.
@@ -25,11 +52,55 @@ func IsErrOpNotSupported(err error) bool {
 	return errors.Is(err, ErrOpNotSupported)
 }
 
+// IsErrBucketNotFound returns true if the cause of the given error is ErrBucketNotFound.
+func IsErrBucketNotFound(err error) bool {
+	return errors.Is(err, ErrBucketNotFound)
+}
+
+// IsErrBucketExists returns true if the cause of the given error is ErrBucketExists.
+func IsErrBucketExists(err error) bool {
+	return errors.Is(err, ErrBucketExists)
+}
+
+// IsErrConflict returns true if the cause of the given error is ErrConflict.
+func IsErrConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// IsErrTxTooLarge returns true if the cause of the given error is ErrTxTooLarge.
+func IsErrTxTooLarge(err error) bool {
+	return errors.Is(err, ErrTxTooLarge)
+}
+
+// IsErrInvalidKey returns true if the cause of the given error is ErrInvalidKey.
+func IsErrInvalidKey(err error) bool {
+	return errors.Is(err, ErrInvalidKey)
+}
+
+// IsErrClosed returns true if the cause of the given error is ErrClosed.
+func IsErrClosed(err error) bool {
+	return errors.Is(err, ErrClosed)
+}
+
+// IsErrReadOnly returns true if the cause of the given error is ErrReadOnly.
+func IsErrReadOnly(err error) bool {
+	return errors.Is(err, ErrReadOnly)
+}
+
+// IsErrVersionMismatch returns true if the cause of the given error is ErrVersionMismatch.
+func IsErrVersionMismatch(err error) bool {
+	return errors.Is(err, ErrVersionMismatch)
+}
+
 // Options are configuration options for the database.
 type Options struct {
 	Database              string
 	ValueDir              string
 	BadgerFileLoadingMode string
+	NoSync                bool
+	AutoCreateTables      bool
+	SchemaMigration       bool
+	Middleware            []Middleware
 }
 
 // Option is the modifier type over Options.
@@ -60,7 +131,63 @@ func WithBadgerFileLoadingMode(mode string) Option {
 	}
 }
 
-// DB is a interface to be implemented by the databases.
+// WithNoSync is a modifier that disables the synchronization of the writes
+// to disk on the drivers that support it. It makes the writes faster, but a
+// crash can lose data, so it should only be used on tests or data that can be
+// recreated.
+func WithNoSync() Option {
+	return func(o *Options) error {
+		o.NoSync = true
+		return nil
+	}
+}
+
+// WithAutoCreateTables is a modifier that makes the write operations create
+// the tables or buckets that do not exist. Set, Del, CmpAndSwap and the write
+// commands of Update create their buckets before running, so the buckets
+// exist even if the operation fails. By default, all the operations on a
+// bucket that does not exist fail with ErrBucketNotFound.
+func WithAutoCreateTables() Option {
+	return func(o *Options) error {
+		o.AutoCreateTables = true
+		return nil
+	}
+}
+
+// WithSchemaMigration is a modifier that makes the SQL drivers add, on Open,
+// the columns used by this version to the tables created by older versions,
+// like the nversion column used by GetVersioned and SetIfVersion. It runs an
+// ALTER TABLE on every table without them, so it requires DDL privileges and
+// it might lock large tables for a while. The tables created by an older
+// version can be read and written before they are migrated, but GetVersioned
+// and SetIfVersion fail on them.
+func WithSchemaMigration() Option {
+	return func(o *Options) error {
+		o.SchemaMigration = true
+		return nil
+	}
+}
+
+// WithMiddleware is a modifier that appends the given middleware to the
+// Middleware attribute of Options. The middleware is not used by the drivers,
+// but by the constructors that decorate the opened database.
+func WithMiddleware(mws ...Middleware) Option {
+	return func(o *Options) error {
+		o.Middleware = append(o.Middleware, mws...)
+		return nil
+	}
+}
+
+// DB is a interface to be implemented by the databases. The operations on
+// tables or buckets that do not exist fail with ErrBucketNotFound, unless the
+// database was opened using WithAutoCreateTables. Buckets can be nested using
+// BucketSeparator in their names.
+//
+// The operations that not all the databases support are defined by optional
+// interfaces, like Compactor, TableLister or Counter. The functions of this
+// package with the names of those operations, like ListTables or IncrCounter,
+// use the first database in the chain of wrapped databases that implements
+// them, and return ErrOpNotSupported if there is none.
 type DB interface {
 	// Open opens the database available with the given options.
 	Open(dataSourceName string, opt ...Option) error
@@ -80,9 +207,11 @@ type DB interface {
 	List(bucket []byte) ([]*Entry, error)
 	// Update performs a transaction with multiple read-write commands.
 	Update(tx *Tx) error
-	// CreateTable creates a table or a bucket in the database.
+	// CreateTable creates a table or a bucket in the database, and the
+	// parents of a nested bucket.
 	CreateTable(bucket []byte) error
-	// DeleteTable deletes a table or a bucket in the database.
+	// DeleteTable deletes a table or a bucket in the database, and all the
+	// buckets nested in it.
 	DeleteTable(bucket []byte) error
 }
 
@@ -118,6 +247,22 @@ const (
 	// compare the values will the ones passed, and if they don't match the
 	// transaction will fail
 	CmpOrRollback
+	// Truncate on a TxEntry will represent the deletion of all the entries
+	// of a table or bucket.
+	Truncate
+	// DeleteRange on a TxEntry will represent the deletion of the entries
+	// with a key in the range from Key, inclusive, to End, exclusive.
+	DeleteRange
+	// DeletePrefix on a TxEntry will represent the deletion of the entries
+	// with a key that starts with Key.
+	DeletePrefix
+	// Incr on a TxEntry will represent the increment of the counter in Key
+	// by Delta. The TxEntry will contain the new value in Counter.
+	Incr
+	// NextSequence on a TxEntry will represent the generation of the next
+	// number of the sequence of a table or bucket. The TxEntry will contain
+	// the number in Sequence.
+	NextSequence
 )
 
 // String implements the fmt.Stringer interface on TxCmd.
@@ -137,11 +282,33 @@ func (o TxCmd) String() string {
 		return "compare-and-swap"
 	case CmpOrRollback:
 		return "compare-and-rollback"
+	case Truncate:
+		return "truncate"
+	case DeleteRange:
+		return "delete-range"
+	case DeletePrefix:
+		return "delete-prefix"
+	case Incr:
+		return "increment"
+	case NextSequence:
+		return "next-sequence"
 	default:
 		return fmt.Sprintf("unknown(%d)", o)
 	}
 }
 
+// IsWrite returns true if the command writes data on a table or bucket, these
+// are the commands that create the bucket if the database was opened using
+// WithAutoCreateTables.
+func (o TxCmd) IsWrite() bool {
+	switch o {
+	case Set, Delete, CmpAndSwap, Truncate, DeleteRange, DeletePrefix, Incr, NextSequence:
+		return true
+	default:
+		return false
+	}
+}
+
 // Tx represents a transaction and it's list of multiple TxEntry. Each TxEntry
 // represents a read or write operation on the database.
 type Tx struct {
@@ -212,6 +379,83 @@ func (tx *Tx) Cmp(bucket, key, value []byte) {
 	})
 }
 
+// Truncate adds a new truncate query to the transaction.
+func (tx *Tx) Truncate(bucket []byte) {
+	tx.Operations = append(tx.Operations, &TxEntry{
+		Bucket: bucket,
+		Cmd:    Truncate,
+	})
+}
+
+// DeleteRange adds a new delete-range query to the transaction.
+func (tx *Tx) DeleteRange(bucket, start, end []byte) {
+	tx.Operations = append(tx.Operations, &TxEntry{
+		Bucket: bucket,
+		Key:    start,
+		End:    end,
+		Cmd:    DeleteRange,
+	})
+}
+
+// DeletePrefix adds a new delete-prefix query to the transaction.
+func (tx *Tx) DeletePrefix(bucket, prefix []byte) {
+	tx.Operations = append(tx.Operations, &TxEntry{
+		Bucket: bucket,
+		Key:    prefix,
+		Cmd:    DeletePrefix,
+	})
+}
+
+// Incr adds a new increment query to the transaction.
+func (tx *Tx) Incr(bucket, key []byte, delta int64) {
+	tx.Operations = append(tx.Operations, &TxEntry{
+		Bucket: bucket,
+		Key:    key,
+		Delta:  delta,
+		Cmd:    Incr,
+	})
+}
+
+// NextSequence adds a new next-sequence query to the transaction.
+func (tx *Tx) NextSequence(bucket []byte) {
+	tx.Operations = append(tx.Operations, &TxEntry{
+		Bucket: bucket,
+		Cmd:    NextSequence,
+	})
+}
+
+// CheckReadOnly returns ErrReadOnly if the transaction has commands that
+// write on the database, the table commands included.
+func (tx *Tx) CheckReadOnly() error {
+	for _, q := range tx.Operations {
+		switch {
+		case q.Cmd == CreateTable, q.Cmd == DeleteTable, q.Cmd.IsWrite():
+			return fmt.Errorf("%w: %s is not allowed on a read-only transaction", ErrReadOnly, q.Cmd)
+		}
+	}
+	return nil
+}
+
+// Viewer is an interface implemented by those databases that can run
+// read-only transactions.
+type Viewer interface {
+	// View performs a transaction with multiple read commands on a
+	// consistent snapshot of the database, without taking write locks. It
+	// fails with ErrReadOnly if the transaction has write commands.
+	View(tx *Tx) error
+}
+
+// View performs a read-only transaction using the first Viewer in the chain
+// of wrapped databases starting at db. It returns ErrOpNotSupported if none of
+// them implements Viewer.
+func View(db DB, tx *Tx) error {
+	var v Viewer
+	if As(db, &v) {
+		return v.View(tx)
+	}
+	return ErrOpNotSupported
+}
+
 // TxEntry is the base elements for the transactions, a TxEntry is a read or
 // write operation on the database.
 type TxEntry struct {
@@ -219,10 +463,18 @@ type TxEntry struct {
 	Key      []byte
 	Value    []byte
 	CmpValue []byte
+	// End is the end of the range of DeleteRange, the range of keys from
+	// Key to End.
+	End []byte
+	// Delta is the value added to the counter by Incr.
+	Delta int64
 	// Where the result of Get or CmpAndSwap txns is stored.
 	Result  []byte
 	Cmd     TxCmd
 	Swapped bool
+	// Where the results of Incr and NextSequence txns are stored.
+	Counter  int64
+	Sequence uint64
 }
 
 // Entry is the return value for list commands.
@@ -231,3 +483,194 @@ type Entry struct {
 	Key    []byte
 	Value  []byte
 }
+
+// Versioned is an interface implemented by those databases that keep a
+// version of the entries, used for optimistic concurrency control.
+type Versioned interface {
+	// GetVersioned returns the value stored in the given table/bucket and
+	// key, and its version. The version changes on every write of the entry,
+	// it is never 0, and it is only comparable to other versions of the same
+	// entry.
+	GetVersioned(bucket, key []byte) (ret []byte, version uint64, err error)
+	// SetIfVersion sets the given value in the given table/bucket and key
+	// only if the current version of the entry is the given one, or, if the
+	// version is 0, only if the entry does not exist. It fails with
+	// ErrVersionMismatch otherwise.
+	SetIfVersion(bucket, key, value []byte, version uint64) error
+}
+
+// GetVersioned returns the value stored in the given table/bucket and key, and
+// its version, using the first Versioned in the chain of wrapped databases
+// starting at db. It returns ErrOpNotSupported if none of them implements
+// Versioned.
+func GetVersioned(db DB, bucket, key []byte) ([]byte, uint64, error) {
+	var v Versioned
+	if As(db, &v) {
+		return v.GetVersioned(bucket, key)
+	}
+	return nil, 0, ErrOpNotSupported
+}
+
+// SetIfVersion sets the given value in the given table/bucket and key if the
+// entry has the given version, using the first Versioned in the chain of
+// wrapped databases starting at db. It returns ErrOpNotSupported if none of
+// them implements Versioned.
+func SetIfVersion(db DB, bucket, key, value []byte, version uint64) error {
+	var v Versioned
+	if As(db, &v) {
+		return v.SetIfVersion(bucket, key, value, version)
+	}
+	return ErrOpNotSupported
+}
+
+// KeyLister is an interface implemented by those databases that can list or
+// count the entries of a table or bucket without reading the values.
+type KeyLister interface {
+	// ListKeys returns the keys of all the entries in a given table/bucket,
+	// sorted in lexicographical order, without reading the values.
+	ListKeys(bucket []byte) ([][]byte, error)
+	// Count returns the number of entries in a given table/bucket, without
+	// reading the values. The buckets nested in it are not counted.
+	Count(bucket []byte) (int, error)
+}
+
+// ListKeys returns the keys of all the entries in a given table/bucket using
+// the first KeyLister in the chain of wrapped databases starting at db. It
+// returns ErrOpNotSupported if none of them implements KeyLister.
+func ListKeys(db DB, bucket []byte) ([][]byte, error) {
+	var l KeyLister
+	if As(db, &l) {
+		return l.ListKeys(bucket)
+	}
+	return nil, ErrOpNotSupported
+}
+
+// Count returns the number of entries in a given table/bucket using the first
+// KeyLister in the chain of wrapped databases starting at db. It returns
+// ErrOpNotSupported if none of them implements KeyLister.
+func Count(db DB, bucket []byte) (int, error) {
+	var l KeyLister
+	if As(db, &l) {
+		return l.Count(bucket)
+	}
+	return 0, ErrOpNotSupported
+}
+
+// RangeDeleter is an interface implemented by those databases that can delete
+// multiple entries of a table or bucket in one operation.
+type RangeDeleter interface {
+	// Truncate deletes all the entries in a table/bucket, but keeps the
+	// table/bucket and the buckets nested in it.
+	Truncate(bucket []byte) error
+	// DeleteRange deletes the entries in a table/bucket with a key greater
+	// than or equal to start and less than end. A nil start or end leaves
+	// the range unbounded on that side.
+	DeleteRange(bucket, start, end []byte) error
+	// DeletePrefix deletes the entries in a table/bucket with a key that
+	// starts with the given prefix.
+	DeletePrefix(bucket, prefix []byte) error
+}
+
+// TruncateTable deletes all the entries in a table/bucket using the first
+// RangeDeleter in the chain of wrapped databases starting at db. It returns
+// ErrOpNotSupported if none of them implements RangeDeleter.
+func TruncateTable(db DB, bucket []byte) error {
+	var d RangeDeleter
+	if As(db, &d) {
+		return d.Truncate(bucket)
+	}
+	return ErrOpNotSupported
+}
+
+// DeleteKeyRange deletes the entries in a table/bucket with a key in the range
+// from start, inclusive, to end, exclusive, using the first RangeDeleter in the
+// chain of wrapped databases starting at db. It returns ErrOpNotSupported if
+// none of them implements RangeDeleter.
+func DeleteKeyRange(db DB, bucket, start, end []byte) error {
+	var d RangeDeleter
+	if As(db, &d) {
+		return d.DeleteRange(bucket, start, end)
+	}
+	return ErrOpNotSupported
+}
+
+// DeleteKeyPrefix deletes the entries in a table/bucket with a key that starts
+// with the given prefix using the first RangeDeleter in the chain of wrapped
+// databases starting at db. It returns ErrOpNotSupported if none of them
+// implements RangeDeleter.
+func DeleteKeyPrefix(db DB, bucket, prefix []byte) error {
+	var d RangeDeleter
+	if As(db, &d) {
+		return d.DeletePrefix(bucket, prefix)
+	}
+	return ErrOpNotSupported
+}
+
+// PrefixEnd returns the smallest key greater than all the keys that start with
+// the given prefix, or nil if there is none, the prefix is empty or only has
+// 0xff bytes. It can be used as the end of the range of the keys with a prefix.
+func PrefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// InRange returns true if key is greater than or equal to start and less than
+// end. A nil start or end leaves the range unbounded on that side.
+func InRange(key, start, end []byte) bool {
+	return (start == nil || bytes.Compare(key, start) >= 0) &&
+		(end == nil || bytes.Compare(key, end) < 0)
+}
+
+// MultiGetter is an interface implemented by those databases that can read
+// multiple keys in a single read.
+type MultiGetter interface {
+	// GetMulti returns the values stored in the given table/bucket and keys,
+	// indexed by key, using a single read. The keys that do not exist are
+	// not in the result, MissingKeys returns them.
+	GetMulti(bucket []byte, keys [][]byte) (map[string][]byte, error)
+	// GetMultiBuckets is like GetMulti, but it reads keys from multiple
+	// tables/buckets. Both the argument and the result are indexed by bucket,
+	// and the result has an entry, maybe empty, for every bucket requested.
+	GetMultiBuckets(keys map[string][][]byte) (map[string]map[string][]byte, error)
+}
+
+// GetMulti returns the values stored in the given table/bucket and keys using
+// the first MultiGetter in the chain of wrapped databases starting at db. It
+// returns ErrOpNotSupported if none of them implements MultiGetter.
+func GetMulti(db DB, bucket []byte, keys [][]byte) (map[string][]byte, error) {
+	var g MultiGetter
+	if As(db, &g) {
+		return g.GetMulti(bucket, keys)
+	}
+	return nil, ErrOpNotSupported
+}
+
+// GetMultiBuckets returns the values stored in the given tables/buckets and
+// keys using the first MultiGetter in the chain of wrapped databases starting
+// at db. It returns ErrOpNotSupported if none of them implements MultiGetter.
+func GetMultiBuckets(db DB, keys map[string][][]byte) (map[string]map[string][]byte, error) {
+	var g MultiGetter
+	if As(db, &g) {
+		return g.GetMultiBuckets(keys)
+	}
+	return nil, ErrOpNotSupported
+}
+
+// MissingKeys returns the keys that are not in the values returned by
+// GetMulti, in the same order as they were requested.
+func MissingKeys(keys [][]byte, values map[string][]byte) [][]byte {
+	var missing [][]byte
+	for _, key := range keys {
+		if _, ok := values[string(key)]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
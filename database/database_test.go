@@ -0,0 +1,117 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix []byte
+		want   []byte
+	}{
+		{"ok", []byte("hello"), []byte("hellp")},
+		{"ok/carry", []byte{3, 0, 97, 255, 255}, []byte{3, 0, 98}},
+		{"ok/none", []byte{255, 255}, nil},
+		{"ok/empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := append([]byte(nil), tt.prefix...)
+			assert.Equals(t, tt.want, PrefixEnd(prefix))
+			assert.Equals(t, tt.prefix, prefix)
+		})
+	}
+}
+
+func TestInRange(t *testing.T) {
+	tests := []struct {
+		name            string
+		key, start, end []byte
+		want            bool
+	}{
+		{"ok/unbounded", []byte("b"), nil, nil, true},
+		{"ok/start", []byte("b"), []byte("b"), nil, true},
+		{"ok/end", []byte("b"), nil, []byte("c"), true},
+		{"ok/empty-key", []byte{}, nil, []byte("a"), true},
+		{"fail/start", []byte("a"), []byte("b"), nil, false},
+		{"fail/end", []byte("c"), nil, []byte("c"), false},
+		{"fail/empty-range", []byte("b"), []byte("c"), []byte("a"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equals(t, tt.want, InRange(tt.key, tt.start, tt.end))
+		})
+	}
+}
+
+func TestMissingKeys(t *testing.T) {
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("b")}
+	assert.Equals(t, [][]byte{[]byte("b"), []byte("b")}, MissingKeys(keys, map[string][]byte{
+		"a": []byte("1"), "c": {},
+	}))
+	assert.Len(t, 0, MissingKeys(keys, map[string][]byte{"a": nil, "b": nil, "c": nil}))
+	assert.Len(t, 0, MissingKeys(nil, nil))
+}
+
+func TestTx_CheckReadOnly(t *testing.T) {
+	bucket, key := []byte("bucket"), []byte("key")
+	tests := []struct {
+		name    string
+		tx      func(tx *Tx)
+		wantErr bool
+	}{
+		{"ok/empty", func(tx *Tx) {}, false},
+		{"ok/get", func(tx *Tx) { tx.Get(bucket, key) }, false},
+		{"ok/cmp", func(tx *Tx) { tx.Cmp(bucket, key, key) }, false},
+		{"fail/set", func(tx *Tx) { tx.Get(bucket, key); tx.Set(bucket, key, key) }, true},
+		{"fail/del", func(tx *Tx) { tx.Del(bucket, key) }, true},
+		{"fail/cas", func(tx *Tx) { tx.Cas(bucket, key, key) }, true},
+		{"fail/truncate", func(tx *Tx) { tx.Truncate(bucket) }, true},
+		{"fail/create-table", func(tx *Tx) { tx.CreateTable(bucket) }, true},
+		{"fail/delete-table", func(tx *Tx) { tx.DeleteTable(bucket) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := new(Tx)
+			tt.tx(tx)
+			err := tx.CheckReadOnly()
+			assert.Equals(t, tt.wantErr, IsErrReadOnly(err))
+			assert.Equals(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestOptionalInterfaces_notSupported(t *testing.T) {
+	db := &NotSupportedDB{}
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"ListTables", func() error { _, err := ListTables(db); return err }},
+		{"TableExists", func() error { _, err := TableExists(db, []byte("bucket")); return err }},
+		{"RenameTable", func() error { return RenameTable(db, []byte("a"), []byte("b")) }},
+		{"CopyTable", func() error { return CopyTable(db, []byte("a"), []byte("b")) }},
+		{"TruncateTable", func() error { return TruncateTable(db, []byte("bucket")) }},
+		{"DeleteKeyRange", func() error { return DeleteKeyRange(db, []byte("bucket"), nil, nil) }},
+		{"DeleteKeyPrefix", func() error { return DeleteKeyPrefix(db, []byte("bucket"), []byte("a")) }},
+		{"GetMulti", func() error { _, err := GetMulti(db, []byte("bucket"), nil); return err }},
+		{"GetMultiBuckets", func() error { _, err := GetMultiBuckets(db, nil); return err }},
+		{"View", func() error { return View(db, new(Tx)) }},
+		{"BulkLoad", func() error { return BulkLoad(db, []byte("bucket"), EntriesIterator(nil)) }},
+		{"ListKeys", func() error { _, err := ListKeys(db, []byte("bucket")); return err }},
+		{"Count", func() error { _, err := Count(db, []byte("bucket")); return err }},
+		{"GetVersioned", func() error { _, _, err := GetVersioned(db, []byte("bucket"), []byte("key")); return err }},
+		{"SetIfVersion", func() error { return SetIfVersion(db, []byte("bucket"), []byte("key"), nil, 0) }},
+		{"IncrCounter", func() error { _, err := IncrCounter(db, []byte("bucket"), []byte("key"), 1); return err }},
+		{"NextTableSequence", func() error { _, err := NextTableSequence(db, []byte("bucket")); return err }},
+		{"ListChildren", func() error { _, err := ListChildren(db, nil); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, IsErrOpNotSupported(tt.fn()))
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package database
+
+import "reflect"
+
+// Middleware is a function that decorates a DB, returning a DB that usually
+// wraps the given one.
+type Middleware func(DB) DB
+
+// Wrapper is the interface implemented by the databases that decorate another
+// database.
+type Wrapper interface {
+	// Unwrap returns the wrapped database.
+	Unwrap() DB
+}
+
+// Compactor in an interface implemented by those databases that can run a
+// value log garbage collector like badger.
+type Compactor interface {
+	Compact(discardRatio float64) error
+}
+
+// Unwrap returns the database wrapped by db, or nil if db does not implement
+// Wrapper.
+func Unwrap(db DB) DB {
+	if w, ok := db.(Wrapper); ok {
+		return w.Unwrap()
+	}
+	return nil
+}
+
+// As finds the first database in the chain of wrapped databases starting at
+// db that is assignable to the value pointed by target, and if one is found,
+// sets target to that database and returns true. The chain is followed using
+// Unwrap.
+//
+// As panics if target is not a non-nil pointer to a type that implements DB
+// or to an interface type.
+func As(db DB, target interface{}) bool {
+	val := reflect.ValueOf(target)
+	if target == nil || val.Kind() != reflect.Ptr || val.IsNil() {
+		panic("database: target must be a non-nil pointer")
+	}
+	typ := val.Type().Elem()
+	if typ.Kind() != reflect.Interface && !typ.Implements(reflect.TypeOf((*DB)(nil)).Elem()) {
+		panic("database: *target must be interface or implement DB")
+	}
+	for db != nil {
+		if reflect.TypeOf(db).AssignableTo(typ) {
+			val.Elem().Set(reflect.ValueOf(db))
+			return true
+		}
+		db = Unwrap(db)
+	}
+	return false
+}
+
+// Compact runs the value log garbage collector of the first Compactor in the
+// chain of wrapped databases starting at db. It returns ErrOpNotSupported if
+// none of them implements Compactor.
+func Compact(db DB, discardRatio float64) error {
+	var c Compactor
+	if As(db, &c) {
+		return c.Compact(discardRatio)
+	}
+	return ErrOpNotSupported
+}
@@ -0,0 +1,564 @@
+// Package faulty implements a database.DB decorator that injects faults on
+// the operations run on the wrapped database. It is meant to be used on tests
+// that check how an application behaves when the datastore misbehaves.
+//
+// Faults are described using rules, that match the operations by type, bucket
+// and key, and can add latency, return errors, hide existing keys, make
+// compare-and-swap operations fail, or partially apply transactions. Rules
+// with a probability use a random source initialized with a fixed seed, so a
+// test run is deterministic as long as the operations are run in the same
+// order.
+package faulty
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/smallstep/nosql/database"
+)
+
+// ErrInjected is the error returned by the operations that fail because of a
+// rule without an explicit error.
+var ErrInjected = errors.New("injected fault")
+
+// DefaultSeed is the seed used by default on the random source.
+const DefaultSeed = 1
+
+// Op identifies an operation of a database.DB.
+type Op string
+
+// Operations that can be matched by a rule.
+const (
+	OpGet             Op = "Get"
+	OpGetVersioned    Op = "GetVersioned"
+	OpGetMulti        Op = "GetMulti"
+	OpGetMultiBuckets Op = "GetMultiBuckets"
+	OpSet             Op = "Set"
+	OpCmpAndSwap      Op = "CmpAndSwap"
+	OpSetIfVersion    Op = "SetIfVersion"
+	OpIncr            Op = "Incr"
+	OpNextSequence    Op = "NextSequence"
+	OpDel             Op = "Del"
+	OpBulkLoad        Op = "BulkLoad"
+	OpTruncate        Op = "Truncate"
+	OpDeleteRange     Op = "DeleteRange"
+	OpDeletePrefix    Op = "DeletePrefix"
+	OpList            Op = "List"
+	OpListKeys        Op = "ListKeys"
+	OpCount           Op = "Count"
+	OpUpdate          Op = "Update"
+	OpView            Op = "View"
+	OpCreateTable     Op = "CreateTable"
+	OpDeleteTable     Op = "DeleteTable"
+	OpRenameTable     Op = "RenameTable"
+	OpCopyTable       Op = "CopyTable"
+	OpListTables      Op = "ListTables"
+	OpTableExists     Op = "TableExists"
+)
+
+// Rule describes a fault injected on the operations it matches.
+type Rule struct {
+	// Ops is the list of operations the rule applies to, an empty list
+	// matches all the operations.
+	Ops []Op
+	// Bucket, if set, restricts the rule to the operations on this bucket.
+	// Update, View, GetMulti and GetMultiBuckets operations match if any of
+	// their entries or keys matches.
+	Bucket []byte
+	// Key, if set, restricts the rule to the operations on this key.
+	Key []byte
+	// Probability is the probability of applying the rule on a matching
+	// operation. A zero value applies the rule always.
+	Probability float64
+	// Count, if greater than zero, is the maximum number of times the rule
+	// is applied.
+	Count int
+
+	// Latency is added before running the operation.
+	Latency time.Duration
+	// Err is returned instead of running the operation, on Update
+	// operations with UpdateFailAt it's returned after the partial commit.
+	Err error
+	// NotFound makes Get and GetVersioned operations return
+	// database.ErrNotFound.
+	NotFound bool
+	// Conflict makes CmpAndSwap operations fail to swap the value, and
+	// SetIfVersion operations fail with database.ErrVersionMismatch, as if
+	// another writer changed it first.
+	Conflict bool
+	// UpdateFailAt, if greater than zero, makes Update operations commit the
+	// operations before the UpdateFailAt-th one and then fail with Err, or
+	// ErrInjected if Err is not set. It simulates a backend that does not
+	// honor the atomicity of the transactions.
+	UpdateFailAt int
+}
+
+type options struct {
+	seed int64
+}
+
+// Option is the modifier type used to configure the faulty decorator.
+type Option func(o *options)
+
+// WithSeed sets the seed of the random source used on rules with a
+// probability. It defaults to DefaultSeed.
+func WithSeed(seed int64) Option {
+	return func(o *options) {
+		o.seed = seed
+	}
+}
+
+// fault is the result of applying the matching rules on an operation.
+type fault struct {
+	latency      time.Duration
+	err          error
+	notFound     bool
+	conflict     bool
+	updateFailAt int
+}
+
+// DB is a database.DB that injects faults on the operations of the wrapped
+// database.
+type DB struct {
+	db    database.DB
+	rules []*Rule
+	state *state
+}
+
+// state is the state shared by a DB and its copies.
+type state struct {
+	mu      sync.Mutex
+	rand    *rand.Rand
+	applied []int
+}
+
+// Wrap returns a DB that injects the faults described by rules on the
+// operations run on db.
+func Wrap(db database.DB, rules []*Rule, opts ...Option) *DB {
+	o := &options{
+		seed: DefaultSeed,
+	}
+	for _, fn := range opts {
+		fn(o)
+	}
+	return &DB{
+		db:    db,
+		rules: rules,
+		state: &state{
+			//nolint:gosec // faults do not require a secure random source
+			rand:    rand.New(rand.NewSource(o.seed)),
+			applied: make([]int, len(rules)),
+		},
+	}
+}
+
+// Middleware returns a database.Middleware that wraps a database with Wrap.
+func Middleware(rules []*Rule, opts ...Option) database.Middleware {
+	return func(db database.DB) database.DB {
+		return Wrap(db, rules, opts...)
+	}
+}
+
+// Unwrap returns the wrapped database.
+func (w *DB) Unwrap() database.DB {
+	return w.db
+}
+
+// WithContext returns a shallow copy of the database with the wrapped
+// database bound to ctx, if it's a database.ContextBinder. The copy shares
+// the rules state with the original database.
+func (w *DB) WithContext(ctx context.Context) database.DB {
+	c := *w
+	if b, ok := w.db.(database.ContextBinder); ok {
+		c.db = b.WithContext(ctx)
+	}
+	return &c
+}
+
+// Open opens the wrapped database.
+func (w *DB) Open(dataSourceName string, opt ...database.Option) error {
+	return w.db.Open(dataSourceName, opt...)
+}
+
+// Close closes the wrapped database.
+func (w *DB) Close() error {
+	return w.db.Close()
+}
+
+// Get returns the value stored in the given bucket and key.
+func (w *DB) Get(bucket, key []byte) ([]byte, error) {
+	f := w.inject(OpGet, bucket, key)
+	switch {
+	case f.err != nil:
+		return nil, f.err
+	case f.notFound:
+		return nil, fmt.Errorf("%w: %w", database.ErrNotFound, ErrInjected)
+	default:
+		return w.db.Get(bucket, key)
+	}
+}
+
+// GetVersioned returns the value and the version stored in the given bucket
+// and key, if the wrapped database implements database.Versioned.
+func (w *DB) GetVersioned(bucket, key []byte) ([]byte, uint64, error) {
+	f := w.inject(OpGetVersioned, bucket, key)
+	switch {
+	case f.err != nil:
+		return nil, 0, f.err
+	case f.notFound:
+		return nil, 0, fmt.Errorf("%w: %w", database.ErrNotFound, ErrInjected)
+	default:
+		return database.GetVersioned(w.db, bucket, key)
+	}
+}
+
+// GetMulti returns the values stored in the given bucket and keys, if the
+// wrapped database implements database.MultiGetter.
+func (w *DB) GetMulti(bucket []byte, keys [][]byte) (map[string][]byte, error) {
+	if f := w.apply(OpGetMulti, matchKeys(bucket, keys)); f.err != nil {
+		return nil, f.err
+	}
+	return database.GetMulti(w.db, bucket, keys)
+}
+
+// GetMultiBuckets returns the values stored in the given buckets and keys, if
+// the wrapped database implements database.MultiGetter.
+func (w *DB) GetMultiBuckets(keys map[string][][]byte) (map[string]map[string][]byte, error) {
+	f := w.apply(OpGetMultiBuckets, func(r *Rule) bool {
+		if len(keys) == 0 {
+			return r.matches(nil, nil)
+		}
+		for bucket, bucketKeys := range keys {
+			if matchKeys([]byte(bucket), bucketKeys)(r) {
+				return true
+			}
+		}
+		return false
+	})
+	if f.err != nil {
+		return nil, f.err
+	}
+	return database.GetMultiBuckets(w.db, keys)
+}
+
+// Set stores the given value on bucket and key.
+func (w *DB) Set(bucket, key, value []byte) error {
+	if f := w.inject(OpSet, bucket, key); f.err != nil {
+		return f.err
+	}
+	return w.db.Set(bucket, key, value)
+}
+
+// CmpAndSwap modifies the value at the given bucket and key (to newValue)
+// only if the existing (current) value matches oldValue.
+func (w *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	f := w.inject(OpCmpAndSwap, bucket, key)
+	switch {
+	case f.err != nil:
+		return nil, false, f.err
+	case f.conflict:
+		current, err := w.db.Get(bucket, key)
+		if err != nil && !database.IsErrNotFound(err) {
+			return nil, false, err
+		}
+		return current, false, nil
+	default:
+		return w.db.CmpAndSwap(bucket, key, oldValue, newValue)
+	}
+}
+
+// SetIfVersion stores the given value on bucket and key only if the stored
+// entry has the given version, if the wrapped database implements
+// database.Versioned.
+func (w *DB) SetIfVersion(bucket, key, value []byte, version uint64) error {
+	f := w.inject(OpSetIfVersion, bucket, key)
+	switch {
+	case f.err != nil:
+		return f.err
+	case f.conflict:
+		return fmt.Errorf("%w: %w", database.ErrVersionMismatch, ErrInjected)
+	default:
+		return database.SetIfVersion(w.db, bucket, key, value, version)
+	}
+}
+
+// Incr adds delta to the counter stored in the given bucket and key, if the
+// wrapped database implements database.Counter.
+func (w *DB) Incr(bucket, key []byte, delta int64) (int64, error) {
+	if f := w.inject(OpIncr, bucket, key); f.err != nil {
+		return 0, f.err
+	}
+	return database.IncrCounter(w.db, bucket, key, delta)
+}
+
+// NextSequence returns the next number of the sequence of a bucket, if the
+// wrapped database implements database.Counter.
+func (w *DB) NextSequence(bucket []byte) (uint64, error) {
+	if f := w.inject(OpNextSequence, bucket, nil); f.err != nil {
+		return 0, f.err
+	}
+	return database.NextTableSequence(w.db, bucket)
+}
+
+// Del deletes the value stored in the given bucket and key.
+func (w *DB) Del(bucket, key []byte) error {
+	if f := w.inject(OpDel, bucket, key); f.err != nil {
+		return f.err
+	}
+	return w.db.Del(bucket, key)
+}
+
+// BulkLoad writes the entries of the iterator in a bucket, if the wrapped
+// database implements database.BulkLoader.
+func (w *DB) BulkLoad(bucket []byte, iter database.Iterator, opts ...database.BulkOption) error {
+	if f := w.inject(OpBulkLoad, bucket, nil); f.err != nil {
+		return f.err
+	}
+	return database.BulkLoad(w.db, bucket, iter, opts...)
+}
+
+// Truncate deletes all the entries in a bucket of the wrapped database if it
+// implements database.RangeDeleter.
+func (w *DB) Truncate(bucket []byte) error {
+	if f := w.inject(OpTruncate, bucket, nil); f.err != nil {
+		return f.err
+	}
+	return database.TruncateTable(w.db, bucket)
+}
+
+// DeleteRange deletes the entries in a bucket with a key in the range from
+// start, inclusive, to end, exclusive, if the wrapped database implements
+// database.RangeDeleter.
+func (w *DB) DeleteRange(bucket, start, end []byte) error {
+	if f := w.inject(OpDeleteRange, bucket, nil); f.err != nil {
+		return f.err
+	}
+	return database.DeleteKeyRange(w.db, bucket, start, end)
+}
+
+// DeletePrefix deletes the entries in a bucket with a key that starts with
+// the given prefix, if the wrapped database implements database.RangeDeleter.
+func (w *DB) DeletePrefix(bucket, prefix []byte) error {
+	if f := w.inject(OpDeletePrefix, bucket, nil); f.err != nil {
+		return f.err
+	}
+	return database.DeleteKeyPrefix(w.db, bucket, prefix)
+}
+
+// List returns the full list of entries in a bucket.
+func (w *DB) List(bucket []byte) ([]*database.Entry, error) {
+	if f := w.inject(OpList, bucket, nil); f.err != nil {
+		return nil, f.err
+	}
+	return w.db.List(bucket)
+}
+
+// ListKeys returns the keys of all the entries in a bucket, if the wrapped
+// database implements database.KeyLister.
+func (w *DB) ListKeys(bucket []byte) ([][]byte, error) {
+	if f := w.inject(OpListKeys, bucket, nil); f.err != nil {
+		return nil, f.err
+	}
+	return database.ListKeys(w.db, bucket)
+}
+
+// Count returns the number of entries in a bucket, if the wrapped database
+// implements database.KeyLister.
+func (w *DB) Count(bucket []byte) (int, error) {
+	if f := w.inject(OpCount, bucket, nil); f.err != nil {
+		return 0, f.err
+	}
+	return database.Count(w.db, bucket)
+}
+
+// Update performs multiple commands on one read-write transaction.
+func (w *DB) Update(tx *database.Tx) error {
+	f := w.apply(OpUpdate, matchTx(tx))
+
+	switch {
+	case f.updateFailAt > 0:
+		if n := f.updateFailAt - 1; n > 0 && n <= len(tx.Operations) {
+			if err := w.db.Update(&database.Tx{Operations: tx.Operations[:n]}); err != nil {
+				return err
+			}
+		}
+		if f.err != nil {
+			return f.err
+		}
+		return ErrInjected
+	case f.err != nil:
+		return f.err
+	default:
+		return w.db.Update(tx)
+	}
+}
+
+// View performs multiple read commands on one read-only transaction, if the
+// wrapped database implements database.Viewer.
+func (w *DB) View(tx *database.Tx) error {
+	if f := w.apply(OpView, matchTx(tx)); f.err != nil {
+		return f.err
+	}
+	return database.View(w.db, tx)
+}
+
+// CreateTable creates a table or a bucket in the wrapped database.
+func (w *DB) CreateTable(bucket []byte) error {
+	if f := w.inject(OpCreateTable, bucket, nil); f.err != nil {
+		return f.err
+	}
+	return w.db.CreateTable(bucket)
+}
+
+// DeleteTable deletes a table or a bucket in the wrapped database.
+func (w *DB) DeleteTable(bucket []byte) error {
+	if f := w.inject(OpDeleteTable, bucket, nil); f.err != nil {
+		return f.err
+	}
+	return w.db.DeleteTable(bucket)
+}
+
+// RenameTable renames a table or a bucket in the wrapped database if it
+// implements database.TableRenamer.
+func (w *DB) RenameTable(oldBucket, newBucket []byte) error {
+	if f := w.inject(OpRenameTable, oldBucket, nil); f.err != nil {
+		return f.err
+	}
+	return database.RenameTable(w.db, oldBucket, newBucket)
+}
+
+// CopyTable copies a table or a bucket in the wrapped database if it
+// implements database.TableCopier.
+func (w *DB) CopyTable(srcBucket, dstBucket []byte) error {
+	if f := w.inject(OpCopyTable, srcBucket, nil); f.err != nil {
+		return f.err
+	}
+	return database.CopyTable(w.db, srcBucket, dstBucket)
+}
+
+// ListTables returns the tables or buckets in the wrapped database if it
+// implements database.TableLister.
+func (w *DB) ListTables() ([][]byte, error) {
+	if f := w.inject(OpListTables, nil, nil); f.err != nil {
+		return nil, f.err
+	}
+	return database.ListTables(w.db)
+}
+
+// TableExists returns true if the table or bucket exists in the wrapped
+// database if it implements database.TableLister.
+func (w *DB) TableExists(bucket []byte) (bool, error) {
+	if f := w.inject(OpTableExists, bucket, nil); f.err != nil {
+		return false, f.err
+	}
+	return database.TableExists(w.db, bucket)
+}
+
+// Compact triggers a value log garbage collection on the wrapped database if
+// it implements database.Compactor.
+func (w *DB) Compact(discardRatio float64) error {
+	return database.Compact(w.db, discardRatio)
+}
+
+// inject applies the rules matching the given operation, bucket and key.
+func (w *DB) inject(op Op, bucket, key []byte) fault {
+	return w.apply(op, func(r *Rule) bool {
+		return r.matches(bucket, key)
+	})
+}
+
+// apply applies the rules for the given operation accepted by match, sleeps
+// the accumulated latency, and returns the resulting fault.
+func (w *DB) apply(op Op, match func(r *Rule) bool) fault {
+	var f fault
+	w.state.mu.Lock()
+	for i, r := range w.rules {
+		if !r.hasOp(op) || !match(r) {
+			continue
+		}
+		if r.Count > 0 && w.state.applied[i] >= r.Count {
+			continue
+		}
+		if r.Probability > 0 && w.state.rand.Float64() >= r.Probability {
+			continue
+		}
+		w.state.applied[i]++
+
+		f.latency += r.Latency
+		if f.err == nil {
+			f.err = r.Err
+		}
+		if f.updateFailAt == 0 && op == OpUpdate {
+			f.updateFailAt = r.UpdateFailAt
+		}
+		f.notFound = f.notFound || r.NotFound
+		f.conflict = f.conflict || r.Conflict
+	}
+	w.state.mu.Unlock()
+
+	if f.latency > 0 {
+		time.Sleep(f.latency)
+	}
+	return f
+}
+
+// hasOp returns true if the rule applies to the given operation.
+func (r *Rule) hasOp(op Op) bool {
+	if len(r.Ops) == 0 {
+		return true
+	}
+	for _, o := range r.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTx returns a function that accepts the rules that match any of the
+// entries of the transaction.
+func matchTx(tx *database.Tx) func(r *Rule) bool {
+	return func(r *Rule) bool {
+		if len(tx.Operations) == 0 {
+			return r.matches(nil, nil)
+		}
+		for _, q := range tx.Operations {
+			if r.matches(q.Bucket, q.Key) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// matchKeys returns a function that accepts the rules that match any of the
+// given keys in the bucket.
+func matchKeys(bucket []byte, keys [][]byte) func(r *Rule) bool {
+	return func(r *Rule) bool {
+		if len(keys) == 0 {
+			return r.matches(bucket, nil)
+		}
+		for _, key := range keys {
+			if r.matches(bucket, key) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// matches returns true if the rule applies to the given bucket and key.
+func (r *Rule) matches(bucket, key []byte) bool {
+	if r.Bucket != nil && !bytes.Equal(r.Bucket, bucket) {
+		return false
+	}
+	if r.Key != nil && !bytes.Equal(r.Key, key) {
+		return false
+	}
+	return true
+}
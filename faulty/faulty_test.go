@@ -0,0 +1,121 @@
+package faulty
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/bolt"
+	"github.com/smallstep/nosql/database"
+)
+
+var (
+	bucket = []byte("bucket")
+	other  = []byte("other")
+)
+
+func newBolt(t *testing.T) database.DB {
+	db := &bolt.DB{}
+	assert.FatalError(t, db.Open(filepath.Join(t.TempDir(), "bolt.db")))
+	t.Cleanup(func() { db.Close() })
+	assert.FatalError(t, db.CreateTable(bucket))
+	assert.FatalError(t, db.CreateTable(other))
+	assert.FatalError(t, db.Set(bucket, []byte("key"), []byte("value")))
+	return db
+}
+
+func TestDB_errors(t *testing.T) {
+	errTest := errors.New("test error")
+	db := Wrap(newBolt(t), []*Rule{
+		{Ops: []Op{OpSet}, Bucket: bucket, Key: []byte("key"), Err: errTest},
+		{Ops: []Op{OpGet}, Bucket: bucket, NotFound: true, Count: 1},
+		{Ops: []Op{OpCmpAndSwap}, Conflict: true},
+	})
+
+	// Only the matching operations fail.
+	assert.True(t, errors.Is(db.Set(bucket, []byte("key"), []byte("new")), errTest))
+	assert.FatalError(t, db.Set(bucket, []byte("other"), []byte("new")))
+	assert.FatalError(t, db.Set(other, []byte("key"), []byte("new")))
+
+	// The first Get is reported as not found.
+	_, err := db.Get(bucket, []byte("key"))
+	assert.True(t, database.IsErrNotFound(err))
+	assert.True(t, errors.Is(err, ErrInjected))
+	v, err := db.Get(bucket, []byte("key"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("value"), v)
+
+	// CmpAndSwap always conflicts.
+	v, swapped, err := db.CmpAndSwap(bucket, []byte("key"), []byte("value"), []byte("swapped"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.Equals(t, []byte("value"), v)
+	v, swapped, err = db.CmpAndSwap(bucket, []byte("missing"), nil, []byte("swapped"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.Nil(t, v)
+}
+
+func TestDB_update(t *testing.T) {
+	inner := newBolt(t)
+	db := Wrap(inner, []*Rule{
+		{Ops: []Op{OpUpdate}, Key: []byte("c"), UpdateFailAt: 3},
+	})
+
+	tx := new(database.Tx)
+	tx.Set(bucket, []byte("a"), []byte("1"))
+	tx.Set(bucket, []byte("b"), []byte("2"))
+	tx.Set(bucket, []byte("c"), []byte("3"))
+	assert.True(t, errors.Is(db.Update(tx), ErrInjected))
+
+	// The first two operations are committed.
+	for _, k := range []string{"a", "b"} {
+		_, err := inner.Get(bucket, []byte(k))
+		assert.FatalError(t, err)
+	}
+	_, err := inner.Get(bucket, []byte("c"))
+	assert.True(t, database.IsErrNotFound(err))
+
+	// Transactions without matching entries are not affected.
+	tx = new(database.Tx)
+	tx.Set(bucket, []byte("d"), []byte("4"))
+	assert.FatalError(t, db.Update(tx))
+}
+
+func TestDB_latency(t *testing.T) {
+	db := Wrap(newBolt(t), []*Rule{
+		{Ops: []Op{OpList}, Latency: 20 * time.Millisecond},
+	})
+	start := time.Now()
+	_, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+}
+
+func TestDB_seed(t *testing.T) {
+	run := func(seed int64) []bool {
+		db := Wrap(newBolt(t), []*Rule{
+			{Ops: []Op{OpGet}, Probability: 0.5, Err: ErrInjected},
+		}, WithSeed(seed))
+		var results []bool
+		for i := 0; i < 64; i++ {
+			_, err := db.Get(bucket, []byte("key"))
+			results = append(results, err == nil)
+		}
+		return results
+	}
+
+	first := run(42)
+	assert.Equals(t, first, run(42))
+	assert.NotEquals(t, first, run(7))
+
+	var failures int
+	for _, ok := range first {
+		if !ok {
+			failures++
+		}
+	}
+	assert.True(t, failures > 0 && failures < len(first))
+}
@@ -0,0 +1,398 @@
+// Package logging implements a database.DB decorator that logs the
+// operations run on the wrapped database using a *slog.Logger.
+//
+// Every operation is logged at debug level, operations slower than the
+// configured threshold are also logged at warn level. Values are redacted
+// unless WithValues is used.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/smallstep/nosql/database"
+)
+
+// DefaultSlowThreshold is the default duration above which an operation is
+// reported as slow.
+const DefaultSlowThreshold = 200 * time.Millisecond
+
+type options struct {
+	slowThreshold time.Duration
+	logValues     bool
+}
+
+// Option is the modifier type used to configure the logging decorator.
+type Option func(o *options)
+
+// WithSlowThreshold sets the duration above which an operation is logged at
+// warn level. A zero or negative duration disables the slow operations
+// report.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(o *options) {
+		o.slowThreshold = d
+	}
+}
+
+// WithValues enables the logging of the values read or written, by default
+// only their size is logged.
+func WithValues() Option {
+	return func(o *options) {
+		o.logValues = true
+	}
+}
+
+// DB is a database.DB that logs the operations run on the wrapped database.
+type DB struct {
+	db            database.DB
+	logger        *slog.Logger
+	slowThreshold time.Duration
+	logValues     bool
+	ctx           context.Context
+}
+
+// Wrap returns a DB that logs all the operations run on db using the given
+// logger. If logger is nil slog.Default() is used.
+func Wrap(db database.DB, logger *slog.Logger, opts ...Option) *DB {
+	o := &options{
+		slowThreshold: DefaultSlowThreshold,
+	}
+	for _, fn := range opts {
+		fn(o)
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &DB{
+		db:            db,
+		logger:        logger,
+		slowThreshold: o.slowThreshold,
+		logValues:     o.logValues,
+	}
+}
+
+// Middleware returns a database.Middleware that wraps a database with Wrap.
+func Middleware(logger *slog.Logger, opts ...Option) database.Middleware {
+	return func(db database.DB) database.DB {
+		return Wrap(db, logger, opts...)
+	}
+}
+
+// Unwrap returns the wrapped database.
+func (w *DB) Unwrap() database.DB {
+	return w.db
+}
+
+// WithContext returns a shallow copy of the database that passes ctx to the
+// logger. If the wrapped database is a database.ContextBinder it will be
+// bound to ctx too.
+func (w *DB) WithContext(ctx context.Context) database.DB {
+	c := *w
+	c.ctx = ctx
+	if b, ok := w.db.(database.ContextBinder); ok {
+		c.db = b.WithContext(ctx)
+	}
+	return &c
+}
+
+// Open opens the wrapped database.
+func (w *DB) Open(dataSourceName string, opt ...database.Option) error {
+	start := time.Now()
+	err := w.db.Open(dataSourceName, opt...)
+	w.log(start, "Open", nil, err)
+	return err
+}
+
+// Close closes the wrapped database.
+func (w *DB) Close() error {
+	start := time.Now()
+	err := w.db.Close()
+	w.log(start, "Close", nil, err)
+	return err
+}
+
+// Get returns the value stored in the given bucket and key.
+func (w *DB) Get(bucket, key []byte) ([]byte, error) {
+	start := time.Now()
+	ret, err := w.db.Get(bucket, key)
+	w.log(start, "Get", bucket, err, keyAttr(key), w.valueAttr(ret))
+	return ret, err
+}
+
+// GetMulti returns the values stored in the given bucket and keys, if the
+// wrapped database implements database.MultiGetter.
+func (w *DB) GetMulti(bucket []byte, keys [][]byte) (map[string][]byte, error) {
+	start := time.Now()
+	ret, err := database.GetMulti(w.db, bucket, keys)
+	w.log(start, "GetMulti", bucket, err, slog.Int("keys", len(keys)), slog.Int("entries", len(ret)))
+	return ret, err
+}
+
+// GetMultiBuckets returns the values stored in the given buckets and keys, if
+// the wrapped database implements database.MultiGetter.
+func (w *DB) GetMultiBuckets(keys map[string][][]byte) (map[string]map[string][]byte, error) {
+	start := time.Now()
+	ret, err := database.GetMultiBuckets(w.db, keys)
+	var nkeys, entries int
+	for bucket, k := range keys {
+		nkeys += len(k)
+		entries += len(ret[bucket])
+	}
+	w.log(start, "GetMultiBuckets", nil, err, slog.Int("buckets", len(keys)), slog.Int("keys", nkeys), slog.Int("entries", entries))
+	return ret, err
+}
+
+// GetVersioned returns the value and the version stored in the given bucket
+// and key, if the wrapped database implements database.Versioned.
+func (w *DB) GetVersioned(bucket, key []byte) ([]byte, uint64, error) {
+	start := time.Now()
+	ret, version, err := database.GetVersioned(w.db, bucket, key)
+	w.log(start, "GetVersioned", bucket, err, keyAttr(key), w.valueAttr(ret), slog.Uint64("version", version))
+	return ret, version, err
+}
+
+// Set stores the given value on bucket and key.
+func (w *DB) Set(bucket, key, value []byte) error {
+	start := time.Now()
+	err := w.db.Set(bucket, key, value)
+	w.log(start, "Set", bucket, err, keyAttr(key), w.valueAttr(value))
+	return err
+}
+
+// CmpAndSwap modifies the value at the given bucket and key (to newValue)
+// only if the existing (current) value matches oldValue.
+func (w *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	start := time.Now()
+	ret, swapped, err := w.db.CmpAndSwap(bucket, key, oldValue, newValue)
+	w.log(start, "CmpAndSwap", bucket, err, keyAttr(key), w.valueAttr(newValue), slog.Bool("swapped", swapped))
+	return ret, swapped, err
+}
+
+// SetIfVersion stores the given value on bucket and key only if the stored
+// entry has the given version, if the wrapped database implements
+// database.Versioned.
+func (w *DB) SetIfVersion(bucket, key, value []byte, version uint64) error {
+	start := time.Now()
+	err := database.SetIfVersion(w.db, bucket, key, value, version)
+	w.log(start, "SetIfVersion", bucket, err, keyAttr(key), w.valueAttr(value), slog.Uint64("version", version))
+	return err
+}
+
+// Incr adds delta to the counter stored in the given bucket and key, if the
+// wrapped database implements database.Counter.
+func (w *DB) Incr(bucket, key []byte, delta int64) (int64, error) {
+	start := time.Now()
+	n, err := database.IncrCounter(w.db, bucket, key, delta)
+	w.log(start, "Incr", bucket, err, keyAttr(key), slog.Int64("delta", delta), slog.Int64("counter", n))
+	return n, err
+}
+
+// NextSequence returns the next number of the sequence of a bucket, if the
+// wrapped database implements database.Counter.
+func (w *DB) NextSequence(bucket []byte) (uint64, error) {
+	start := time.Now()
+	seq, err := database.NextTableSequence(w.db, bucket)
+	w.log(start, "NextSequence", bucket, err, slog.Uint64("sequence", seq))
+	return seq, err
+}
+
+// Del deletes the value stored in the given bucket and key.
+func (w *DB) Del(bucket, key []byte) error {
+	start := time.Now()
+	err := w.db.Del(bucket, key)
+	w.log(start, "Del", bucket, err, keyAttr(key))
+	return err
+}
+
+// BulkLoad writes the entries of the iterator in a bucket, if the wrapped
+// database implements database.BulkLoader.
+func (w *DB) BulkLoad(bucket []byte, iter database.Iterator, opts ...database.BulkOption) error {
+	var loaded int
+	start := time.Now()
+	opts = append(opts, database.WithProgress(func(n int) { loaded = n }))
+	err := database.BulkLoad(w.db, bucket, iter, opts...)
+	w.log(start, "BulkLoad", bucket, err, slog.Int("entries", loaded))
+	return err
+}
+
+// Truncate deletes all the entries in a bucket of the wrapped database if it
+// implements database.RangeDeleter.
+func (w *DB) Truncate(bucket []byte) error {
+	start := time.Now()
+	err := database.TruncateTable(w.db, bucket)
+	w.log(start, "Truncate", bucket, err)
+	return err
+}
+
+// DeleteRange deletes the entries in a bucket with a key in the range from
+// start, inclusive, to end, exclusive, if the wrapped database implements
+// database.RangeDeleter.
+func (w *DB) DeleteRange(bucket, startKey, endKey []byte) error {
+	start := time.Now()
+	err := database.DeleteKeyRange(w.db, bucket, startKey, endKey)
+	w.log(start, "DeleteRange", bucket, err)
+	return err
+}
+
+// DeletePrefix deletes the entries in a bucket with a key that starts with
+// the given prefix, if the wrapped database implements database.RangeDeleter.
+func (w *DB) DeletePrefix(bucket, prefix []byte) error {
+	start := time.Now()
+	err := database.DeleteKeyPrefix(w.db, bucket, prefix)
+	w.log(start, "DeletePrefix", bucket, err, keyAttr(prefix))
+	return err
+}
+
+// List returns the full list of entries in a bucket.
+func (w *DB) List(bucket []byte) ([]*database.Entry, error) {
+	start := time.Now()
+	entries, err := w.db.List(bucket)
+	w.log(start, "List", bucket, err, slog.Int("entries", len(entries)))
+	return entries, err
+}
+
+// ListKeys returns the keys of all the entries in a bucket, if the wrapped
+// database implements database.KeyLister.
+func (w *DB) ListKeys(bucket []byte) ([][]byte, error) {
+	start := time.Now()
+	keys, err := database.ListKeys(w.db, bucket)
+	w.log(start, "ListKeys", bucket, err, slog.Int("entries", len(keys)))
+	return keys, err
+}
+
+// Count returns the number of entries in a bucket, if the wrapped database
+// implements database.KeyLister.
+func (w *DB) Count(bucket []byte) (int, error) {
+	start := time.Now()
+	n, err := database.Count(w.db, bucket)
+	w.log(start, "Count", bucket, err, slog.Int("entries", n))
+	return n, err
+}
+
+// Update performs multiple commands on one read-write transaction.
+func (w *DB) Update(tx *database.Tx) error {
+	start := time.Now()
+	err := w.db.Update(tx)
+	w.log(start, "Update", nil, err, slog.Int("ops", len(tx.Operations)))
+	return err
+}
+
+// View performs multiple read commands on one read-only transaction, if the
+// wrapped database implements database.Viewer.
+func (w *DB) View(tx *database.Tx) error {
+	start := time.Now()
+	err := database.View(w.db, tx)
+	w.log(start, "View", nil, err, slog.Int("ops", len(tx.Operations)))
+	return err
+}
+
+// CreateTable creates a table or a bucket in the wrapped database.
+func (w *DB) CreateTable(bucket []byte) error {
+	start := time.Now()
+	err := w.db.CreateTable(bucket)
+	w.log(start, "CreateTable", bucket, err)
+	return err
+}
+
+// DeleteTable deletes a table or a bucket in the wrapped database.
+func (w *DB) DeleteTable(bucket []byte) error {
+	start := time.Now()
+	err := w.db.DeleteTable(bucket)
+	w.log(start, "DeleteTable", bucket, err)
+	return err
+}
+
+// RenameTable renames a table or a bucket in the wrapped database if it
+// implements database.TableRenamer.
+func (w *DB) RenameTable(oldBucket, newBucket []byte) error {
+	start := time.Now()
+	err := database.RenameTable(w.db, oldBucket, newBucket)
+	w.log(start, "RenameTable", oldBucket, err, slog.String("new_bucket", string(newBucket)))
+	return err
+}
+
+// CopyTable copies a table or a bucket in the wrapped database if it
+// implements database.TableCopier.
+func (w *DB) CopyTable(srcBucket, dstBucket []byte) error {
+	start := time.Now()
+	err := database.CopyTable(w.db, srcBucket, dstBucket)
+	w.log(start, "CopyTable", srcBucket, err, slog.String("new_bucket", string(dstBucket)))
+	return err
+}
+
+// ListTables returns the tables or buckets in the wrapped database if it
+// implements database.TableLister.
+func (w *DB) ListTables() ([][]byte, error) {
+	start := time.Now()
+	tables, err := database.ListTables(w.db)
+	w.log(start, "ListTables", nil, err, slog.Int("tables", len(tables)))
+	return tables, err
+}
+
+// TableExists returns true if the table or bucket exists in the wrapped
+// database if it implements database.TableLister.
+func (w *DB) TableExists(bucket []byte) (bool, error) {
+	start := time.Now()
+	exists, err := database.TableExists(w.db, bucket)
+	w.log(start, "TableExists", bucket, err, slog.Bool("exists", exists))
+	return exists, err
+}
+
+// Compact triggers a value log garbage collection on the wrapped database if
+// it implements database.Compactor.
+func (w *DB) Compact(discardRatio float64) error {
+	start := time.Now()
+	err := database.Compact(w.db, discardRatio)
+	w.log(start, "Compact", nil, err)
+	return err
+}
+
+// log logs an operation at debug level, and at warn level if it took longer
+// than the slow threshold.
+func (w *DB) log(start time.Time, op string, bucket []byte, err error, attrs ...slog.Attr) {
+	duration := time.Since(start)
+	slow := w.slowThreshold > 0 && duration > w.slowThreshold
+
+	ctx := w.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !slow && !w.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	attrs = append([]slog.Attr{
+		slog.String("op", op),
+		slog.String("bucket", string(bucket)),
+		slog.Duration("duration", duration),
+	}, attrs...)
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	w.logger.LogAttrs(ctx, slog.LevelDebug, "nosql operation", attrs...)
+	if slow {
+		w.logger.LogAttrs(ctx, slog.LevelWarn, "slow nosql operation", attrs...)
+	}
+}
+
+func keyAttr(key []byte) slog.Attr {
+	return slog.String("key", string(key))
+}
+
+func (w *DB) valueAttr(value []byte) slog.Attr {
+	if w.logValues {
+		return slog.String("value", string(value))
+	}
+	return slog.Any("value", redacted(value))
+}
+
+// redacted is a slog.LogValuer that hides a value but its size.
+type redacted []byte
+
+// LogValue implements slog.LogValuer.
+func (r redacted) LogValue() slog.Value {
+	return slog.StringValue(fmt.Sprintf("[REDACTED %d bytes]", len(r)))
+}
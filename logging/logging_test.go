@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/bolt"
+	"github.com/smallstep/nosql/database"
+)
+
+func newBolt(t *testing.T) database.DB {
+	db := &bolt.DB{}
+	assert.FatalError(t, db.Open(filepath.Join(t.TempDir(), "bolt.db")))
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newLogger(level slog.Level) (*bytes.Buffer, *slog.Logger) {
+	buf := new(bytes.Buffer)
+	return buf, slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: level}))
+}
+
+func records(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var recs []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var m map[string]interface{}
+		assert.FatalError(t, json.Unmarshal(line, &m))
+		recs = append(recs, m)
+	}
+	buf.Reset()
+	return recs
+}
+
+func TestDB(t *testing.T) {
+	bucket, key, value := []byte("bucket"), []byte("key"), []byte("secret")
+
+	t.Run("debug", func(t *testing.T) {
+		buf, logger := newLogger(slog.LevelDebug)
+		db := Wrap(newBolt(t), logger, WithSlowThreshold(time.Hour))
+		assert.FatalError(t, db.CreateTable(bucket))
+		assert.FatalError(t, db.Set(bucket, key, value))
+		_, swapped, err := db.CmpAndSwap(bucket, key, value, []byte("new"))
+		assert.FatalError(t, err)
+		assert.True(t, swapped)
+
+		recs := records(t, buf)
+		assert.Len(t, 3, recs)
+		for _, r := range recs {
+			assert.Equals(t, "DEBUG", r["level"])
+			assert.Equals(t, "nosql operation", r["msg"])
+			assert.Equals(t, "bucket", r["bucket"])
+		}
+		assert.Equals(t, "Set", recs[1]["op"])
+		assert.Equals(t, "key", recs[1]["key"])
+		assert.Equals(t, "[REDACTED 6 bytes]", recs[1]["value"])
+		assert.Equals(t, true, recs[2]["swapped"])
+
+		_, err = db.Get(bucket, []byte("missing"))
+		assert.True(t, database.IsErrNotFound(err))
+		recs = records(t, buf)
+		assert.Len(t, 1, recs)
+		assert.NotNil(t, recs[0]["error"])
+	})
+
+	t.Run("values", func(t *testing.T) {
+		buf, logger := newLogger(slog.LevelDebug)
+		db := Wrap(newBolt(t), logger, WithValues())
+		assert.FatalError(t, db.CreateTable(bucket))
+		assert.FatalError(t, db.Set(bucket, key, value))
+
+		recs := records(t, buf)
+		assert.Len(t, 2, recs)
+		assert.Equals(t, "secret", recs[1]["value"])
+	})
+
+	t.Run("slow", func(t *testing.T) {
+		buf, logger := newLogger(slog.LevelInfo)
+		db := Wrap(newBolt(t), logger, WithSlowThreshold(time.Nanosecond))
+		assert.FatalError(t, db.CreateTable(bucket))
+		assert.FatalError(t, db.Update(&database.Tx{Operations: []*database.TxEntry{
+			{Bucket: bucket, Key: key, Value: value, Cmd: database.Set},
+		}}))
+
+		recs := records(t, buf)
+		assert.Len(t, 2, recs)
+		assert.Equals(t, "WARN", recs[1]["level"])
+		assert.Equals(t, "slow nosql operation", recs[1]["msg"])
+		assert.Equals(t, "Update", recs[1]["op"])
+		assert.Equals(t, float64(1), recs[1]["ops"])
+		assert.NotNil(t, recs[1]["duration"])
+	})
+
+	t.Run("quiet", func(t *testing.T) {
+		buf, logger := newLogger(slog.LevelInfo)
+		db := Wrap(newBolt(t), logger, WithSlowThreshold(0))
+		assert.FatalError(t, db.CreateTable(bucket))
+		assert.Len(t, 0, records(t, buf))
+	})
+}
@@ -5,9 +5,15 @@ package mysql
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
@@ -16,7 +22,20 @@ import (
 
 // DB is a wrapper over *sql.DB,
 type DB struct {
-	db *sql.DB
+	db         *sql.DB
+	ctx        context.Context
+	autoCreate bool
+	// sequences is set once the sequences table is known to exist.
+	sequences *atomic.Bool
+	// versioned caches whether the tables have the nversion column.
+	versioned *sync.Map
+}
+
+// sqlConn is the interface implemented by *sql.DB and *sql.Tx.
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 // Open creates a Driver and connects to the database with the given address
@@ -28,6 +47,7 @@ func (db *DB) Open(dataSourceName string, opt ...database.Option) error {
 			return err
 		}
 	}
+	db.autoCreate = opts.AutoCreateTables
 
 	parsedDSN, err := mysql.ParseDSN(dataSourceName)
 	if err != nil {
@@ -44,6 +64,7 @@ func (db *DB) Open(dataSourceName string, opt ...database.Option) error {
 	if err != nil {
 		return errors.Wrap(err, "error connecting to mysql")
 	}
+	defer _db.Close()
 	_, err = _db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", opts.Database))
 	if err != nil {
 		return errors.Wrapf(err, "error creating database %s (if not exists)", opts.Database)
@@ -54,24 +75,332 @@ func (db *DB) Open(dataSourceName string, opt ...database.Option) error {
 		return errors.Wrapf(err, "error connecting to mysql database")
 	}
 
+	db.sequences = new(atomic.Bool)
+	db.versioned = new(sync.Map)
+	if opts.SchemaMigration {
+		return db.addVersionColumns()
+	}
 	return nil
 }
 
+// versionColumnsQry returns the columns used to find the tables without the
+// nversion column.
+const versionColumnsQry = "SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND column_name IN ('nkey', 'nversion')"
+
+// addVersionColumns adds the nversion column to the tables created before
+// versions, it runs only if the database is opened using
+// database.WithSchemaMigration.
+func (db *DB) addVersionColumns() error {
+	rows, err := db.query(db.db, versionColumnsQry)
+	if err != nil {
+		return errors.Wrap(err, "error listing columns")
+	}
+	defer rows.Close()
+	var (
+		tables    []string
+		versioned = make(map[string]bool)
+	)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return errors.Wrap(err, "error getting column from row")
+		}
+		if column == "nkey" {
+			tables = append(tables, table)
+		} else {
+			versioned[table] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(mapError(err), "error accessing row")
+	}
+	rows.Close()
+
+	for _, table := range tables {
+		if versioned[table] {
+			continue
+		}
+		// Other clients might be adding the column at the same time.
+		_, err := db.exec(db.db, addVersionColumnQry([]byte(table)))
+		var mysqlErr *mysql.MySQLError
+		if err != nil && !(errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDupFieldName) {
+			return errors.Wrapf(err, "error adding version column to table %s", table)
+		}
+	}
+	return nil
+}
+
+// tableColumnsQry returns the nkey and nversion columns of a table.
+const tableColumnsQry = "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name IN ('nkey', 'nversion')"
+
+// isVersioned returns whether the given table has the nversion column. The
+// tables created before versions can be written without migrating them, but
+// their entries keep version 1 until they are migrated. The result is cached
+// if the table exists.
+func (db *DB) isVersioned(conn sqlConn, bucket []byte) (bool, error) {
+	if ok, found := db.versioned.Load(string(bucket)); found {
+		return ok.(bool), nil
+	}
+	rows, err := db.query(conn, tableColumnsQry, bucket)
+	if err != nil {
+		return false, errors.Wrapf(err, "error listing columns of table %s", bucket)
+	}
+	defer rows.Close()
+	var exists, versioned bool
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return false, errors.Wrap(err, "error getting column from row")
+		}
+		if strings.EqualFold(column, "nkey") {
+			exists = true
+		} else {
+			versioned = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, errors.Wrap(mapError(err), "error accessing row")
+	}
+	// A missing table is reported by the statement using it.
+	if exists {
+		db.versioned.Store(string(bucket), versioned)
+	}
+	return versioned, nil
+}
+
+// checkVersioned returns an error if the given table exists but it does not
+// have the nversion column.
+func (db *DB) checkVersioned(bucket []byte) error {
+	versioned, err := db.isVersioned(db.db, bucket)
+	if err != nil || versioned {
+		return err
+	}
+	if _, exists := db.versioned.Load(string(bucket)); exists {
+		return errors.Errorf("%s: %s", bucket, errMigrationRequired)
+	}
+	return nil
+}
+
+// forgetVersioned removes the given tables from the cache of isVersioned.
+func (db *DB) forgetVersioned(buckets ...[]byte) {
+	for _, bucket := range buckets {
+		db.versioned.Delete(string(bucket))
+	}
+}
+
 // Close shutsdown the database driver.
 func (db *DB) Close() error {
 	return errors.WithStack(db.db.Close())
 }
 
+// WithContext returns a shallow copy of the database that runs all its
+// statements with the given context.
+func (db *DB) WithContext(ctx context.Context) database.DB {
+	c := *db
+	c.ctx = ctx
+	return &c
+}
+
+func (db *DB) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+func (db *DB) begin() (*sql.Tx, error) {
+	tx, err := db.db.BeginTx(db.context(), nil)
+	return tx, mapError(err)
+}
+
+// beginReadOnly starts a read-only transaction that reads a consistent
+// snapshot of the database.
+func (db *DB) beginReadOnly() (*sql.Tx, error) {
+	tx, err := db.db.BeginTx(db.context(), &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+	return tx, mapError(err)
+}
+
+func (db *DB) exec(conn sqlConn, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := database.StartStatement(db.context(), query)
+	res, err := conn.ExecContext(ctx, query, args...)
+	done(err)
+	return res, mapError(err)
+}
+
+func (db *DB) query(conn sqlConn, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := database.StartStatement(db.context(), query)
+	rows, err := conn.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, mapError(err)
+}
+
+// queryRow runs a query that is expected to return at most one row and
+// scans it into dest.
+func (db *DB) queryRow(conn sqlConn, dest interface{}, query string, args ...interface{}) error {
+	ctx, done := database.StartStatement(db.context(), query)
+	err := conn.QueryRowContext(ctx, query, args...).Scan(dest)
+	if errors.Is(err, sql.ErrNoRows) {
+		done(nil)
+	} else {
+		done(err)
+	}
+	return mapError(err)
+}
+
+// MySQL error numbers mapped to database errors.
+const (
+	mysqlTableExists             = 1050 // ER_TABLE_EXISTS_ERROR
+	mysqlDupFieldName            = 1060 // ER_DUP_FIELDNAME
+	mysqlDupEntry                = 1062 // ER_DUP_ENTRY
+	mysqlBadTable                = 1051 // ER_BAD_TABLE_ERROR
+	mysqlBadFieldError           = 1054 // ER_BAD_FIELD_ERROR
+	mysqlNoSuchTable             = 1146 // ER_NO_SUCH_TABLE
+	mysqlNetPacketTooLarge       = 1153 // ER_NET_PACKET_TOO_LARGE
+	mysqlTransCacheFull          = 1197 // ER_TRANS_CACHE_FULL
+	mysqlLockWaitTimeout         = 1205 // ER_LOCK_WAIT_TIMEOUT
+	mysqlDeadlock                = 1213 // ER_LOCK_DEADLOCK
+	mysqlOptionPreventsStatement = 1290 // ER_OPTION_PREVENTS_STATEMENT
+	mysqlDataTooLong             = 1406 // ER_DATA_TOO_LONG
+	mysqlReadOnlyTransaction     = 1792 // ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION
+)
+
+// errDatabaseClosed is the message of the error returned by database/sql
+// after closing the database, it does not export a sentinel error for it.
+const errDatabaseClosed = "sql: database is closed"
+
+// errMigrationRequired is the message added to the errors caused by reading or
+// writing the version of a table created before versions.
+const errMigrationRequired = "table created by an older version, open the database using database.WithSchemaMigration to migrate it"
+
+// mapError returns an error that matches the database error equivalent to
+// the given MySQL error, and keeps the MySQL error as its cause.
+//
+// MySQL uses ER_BAD_TABLE_ERROR on DROP TABLE and ER_NO_SUCH_TABLE on other
+// statements, but some compatible servers use the latter for both.
+// ER_OPTION_PREVENTS_STATEMENT is returned on writes when the server runs
+// with --read-only. Broken connections are mapped to driver.ErrBadConn. A
+// missing nversion column means the table needs WithSchemaMigration.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var target error
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case errors.As(err, &mysqlErr):
+		switch mysqlErr.Number {
+		case mysqlBadTable, mysqlNoSuchTable:
+			target = database.ErrBucketNotFound
+		case mysqlTableExists:
+			target = database.ErrBucketExists
+		case mysqlLockWaitTimeout, mysqlDeadlock:
+			target = database.ErrConflict
+		case mysqlNetPacketTooLarge, mysqlTransCacheFull:
+			target = database.ErrTxTooLarge
+		case mysqlOptionPreventsStatement, mysqlReadOnlyTransaction:
+			target = database.ErrReadOnly
+		case mysqlBadFieldError:
+			if strings.Contains(mysqlErr.Message, "'nversion'") {
+				return errors.Wrap(err, errMigrationRequired)
+			}
+			return err
+		case mysqlDataTooLong:
+			// Only keys have a limit lower than the maximum packet size.
+			if !strings.Contains(mysqlErr.Message, "'nkey'") {
+				return err
+			}
+			target = database.ErrInvalidKey
+		default:
+			return err
+		}
+	case errors.Is(err, mysql.ErrInvalidConn):
+		target = driver.ErrBadConn
+	case err.Error() == errDatabaseClosed:
+		target = database.ErrClosed
+	default:
+		return err
+	}
+	if errors.Is(err, target) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", target, err)
+}
+
 func getQry(bucket []byte) string {
 	return fmt.Sprintf("SELECT nvalue FROM `%s` WHERE nkey = ?", bucket)
 }
 
+// getMultiQry returns the statement, and its arguments, that selects the
+// given keys of every bucket. The rows include the index of their bucket.
+func getMultiQry(buckets [][]byte, keys [][][]byte) (string, []interface{}) {
+	var (
+		selects []string
+		args    []interface{}
+	)
+	for i, bucket := range buckets {
+		// IN (NULL) matches no rows, but it still fails if the table does
+		// not exist.
+		in := "NULL"
+		if n := len(keys[i]); n > 0 {
+			in = strings.Repeat("?, ", n-1) + "?"
+			for _, key := range keys[i] {
+				args = append(args, key)
+			}
+		}
+		selects = append(selects, fmt.Sprintf("SELECT %d, nkey, nvalue FROM `%s` WHERE nkey IN (%s)", i, bucket, in))
+	}
+	return strings.Join(selects, " UNION ALL "), args
+}
+
 func getQryForUpdate(bucket []byte) string {
 	return fmt.Sprintf("SELECT nvalue FROM `%s` WHERE nkey = ? FOR UPDATE", bucket)
 }
 
+func getVersionedQry(bucket []byte) string {
+	return fmt.Sprintf("SELECT nvalue, nversion FROM `%s` WHERE nkey = ?", bucket)
+}
+
+// The version of an updated entry is the new version, or the next one if the
+// clock went backwards.
 func insertUpdateQry(bucket []byte) string {
-	return fmt.Sprintf("INSERT INTO `%s`(nkey, nvalue) VALUES(?,?) ON DUPLICATE KEY UPDATE nvalue = ?", bucket)
+	return fmt.Sprintf("INSERT INTO `%s`(nkey, nvalue, nversion) VALUES(?,?,?) ON DUPLICATE KEY UPDATE nvalue = VALUES(nvalue), nversion = GREATEST(nversion + 1, VALUES(nversion))", bucket)
+}
+
+// insertUpdateUnversionedQry is insertUpdateQry for the tables created before
+// versions.
+func insertUpdateUnversionedQry(bucket []byte) string {
+	return fmt.Sprintf("INSERT INTO `%s`(nkey, nvalue) VALUES(?,?) ON DUPLICATE KEY UPDATE nvalue = VALUES(nvalue)", bucket)
+}
+
+func insertQry(bucket []byte) string {
+	return fmt.Sprintf("INSERT INTO `%s`(nkey, nvalue, nversion) VALUES(?,?,?)", bucket)
+}
+
+func updateIfVersionQry(bucket []byte) string {
+	return fmt.Sprintf("UPDATE `%s` SET nvalue = ?, nversion = GREATEST(nversion + 1, ?) WHERE nkey = ? AND nversion = ?", bucket)
+}
+
+// bulkInsertQry returns the statement that inserts or updates n entries, and
+// their versions if the table is versioned.
+func bulkInsertQry(bucket []byte, n int, versioned bool) string {
+	if !versioned {
+		return fmt.Sprintf("INSERT INTO `%s`(nkey, nvalue) VALUES %s ON DUPLICATE KEY UPDATE nvalue = VALUES(nvalue)",
+			bucket, strings.Repeat("(?,?),", n-1)+"(?,?)")
+	}
+	return fmt.Sprintf("INSERT INTO `%s`(nkey, nvalue, nversion) VALUES %s ON DUPLICATE KEY UPDATE nvalue = VALUES(nvalue), nversion = GREATEST(nversion + 1, VALUES(nversion))",
+		bucket, strings.Repeat("(?,?,?),", n-1)+"(?,?,?)")
+}
+
+func listKeysQry(bucket []byte) string {
+	return fmt.Sprintf("SELECT nkey FROM `%s` ORDER BY nkey", bucket)
+}
+
+func countQry(bucket []byte) string {
+	return fmt.Sprintf("SELECT COUNT(*) FROM `%s`", bucket)
 }
 
 func delQry(bucket []byte) string {
@@ -79,17 +408,91 @@ func delQry(bucket []byte) string {
 }
 
 func createTableQry(bucket []byte) string {
-	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s`(nkey VARBINARY(255), nvalue BLOB, PRIMARY KEY (nkey));", bucket)
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s`(nkey VARBINARY(255), nvalue BLOB, nversion BIGINT NOT NULL DEFAULT 1, PRIMARY KEY (nkey));", bucket)
 }
 
-func deleteTableQry(bucket []byte) string {
-	return fmt.Sprintf("DROP TABLE `%s`", bucket)
+// The entries of the tables created before versions have version 1.
+func addVersionColumnQry(bucket []byte) string {
+	return fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN nversion BIGINT NOT NULL DEFAULT 1", bucket)
+}
+
+func deleteTableQry(buckets ...[]byte) string {
+	names := make([]string, len(buckets))
+	for i, bucket := range buckets {
+		names[i] = fmt.Sprintf("`%s`", bucket)
+	}
+	return "DROP TABLE " + strings.Join(names, ", ")
+}
+
+// TRUNCATE TABLE commits the current transaction, Update uses DELETE instead.
+func truncateQry(bucket []byte) string {
+	return fmt.Sprintf("TRUNCATE TABLE `%s`", bucket)
+}
+
+// deleteRangeQry returns the statement, and its arguments, that deletes the
+// keys in the range from start, inclusive, to end, exclusive.
+func deleteRangeQry(bucket, start, end []byte) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if start != nil {
+		conds = append(conds, "nkey >= ?")
+		args = append(args, start)
+	}
+	if end != nil {
+		conds = append(conds, "nkey < ?")
+		args = append(args, end)
+	}
+	qry := fmt.Sprintf("DELETE FROM `%s`", bucket)
+	if len(conds) > 0 {
+		qry += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return qry, args
+}
+
+// copyTableQry returns the statement that copies the rows of a table, and
+// their versions if both tables are versioned.
+func copyTableQry(srcBucket, dstBucket []byte, versioned bool) string {
+	if !versioned {
+		return fmt.Sprintf("INSERT INTO `%s`(nkey, nvalue) SELECT nkey, nvalue FROM `%s`", dstBucket, srcBucket)
+	}
+	return fmt.Sprintf("INSERT INTO `%s`(nkey, nvalue, nversion) SELECT nkey, nvalue, nversion FROM `%s`", dstBucket, srcBucket)
+}
+
+func renameTableQry(oldBuckets, newBuckets [][]byte) string {
+	renames := make([]string, len(oldBuckets))
+	for i := range oldBuckets {
+		renames[i] = fmt.Sprintf("`%s` TO `%s`", oldBuckets[i], newBuckets[i])
+	}
+	return "RENAME TABLE " + strings.Join(renames, ", ")
+}
+
+const listTablesQry = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name <> '" + sequencesTable + "'"
+
+const tableExistsQry = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ? AND table_name <> '" + sequencesTable + "'"
+
+// sequencesTable is the table with the sequences of the other tables, it's
+// created by the first NextSequence, and it's not listed by ListTables.
+const sequencesTable = database.SequencesTable
+
+const createSequencesTableQry = "CREATE TABLE IF NOT EXISTS `" + sequencesTable + "`(nbucket VARBINARY(255), nvalue BIGINT UNSIGNED NOT NULL, PRIMARY KEY (nbucket))"
+
+const sequencesTableExistsQry = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = '" + sequencesTable + "'"
+
+const nextSequenceQry = "INSERT INTO `" + sequencesTable + "`(nbucket, nvalue) VALUES(?, 1) ON DUPLICATE KEY UPDATE nvalue = LAST_INSERT_ID(nvalue + 1)"
+
+// REPLACE overwrites the sequence that a failed rename might leave behind.
+const copySequenceQry = "REPLACE INTO `" + sequencesTable + "`(nbucket, nvalue) SELECT ?, nvalue FROM `" + sequencesTable + "` WHERE nbucket = ?"
+
+func deleteSequencesQry(n int) string {
+	return "DELETE FROM `" + sequencesTable + "` WHERE nbucket IN (?" + strings.Repeat(",?", n-1) + ")"
 }
 
 // Get retrieves the column/row with given key.
 func (db *DB) Get(bucket, key []byte) ([]byte, error) {
 	var val string
-	err := db.db.QueryRow(getQry(bucket), key).Scan(&val)
+	err := db.queryRow(db.db, &val, getQry(bucket), key)
 	switch {
 	case err == sql.ErrNoRows:
 		return nil, errors.Wrapf(database.ErrNotFound, "%s/%s not found", bucket, key)
@@ -100,29 +503,184 @@ func (db *DB) Get(bucket, key []byte) ([]byte, error) {
 	}
 }
 
+// GetMulti returns the values stored in the given bucket and keys using a
+// single query. The keys that do not exist are not in the result.
+func (db *DB) GetMulti(bucket []byte, keys [][]byte) (map[string][]byte, error) {
+	values, err := db.getMulti([][]byte{bucket}, [][][]byte{keys})
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// GetMultiBuckets returns the values stored in the given buckets and keys
+// using a single query.
+func (db *DB) GetMultiBuckets(keys map[string][][]byte) (map[string]map[string][]byte, error) {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buckets := make([][]byte, len(names))
+	bucketKeys := make([][][]byte, len(names))
+	for i, name := range names {
+		buckets[i], bucketKeys[i] = []byte(name), keys[name]
+	}
+	values, err := db.getMulti(buckets, bucketKeys)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]map[string][]byte, len(names))
+	for i, name := range names {
+		ret[name] = values[i]
+	}
+	return ret, nil
+}
+
+// getMulti returns the values stored in the given keys of every bucket.
+func (db *DB) getMulti(buckets [][]byte, keys [][][]byte) ([]map[string][]byte, error) {
+	values := make([]map[string][]byte, len(buckets))
+	for i := range buckets {
+		values[i] = make(map[string][]byte, len(keys[i]))
+	}
+	if len(buckets) == 0 {
+		return values, nil
+	}
+
+	qry, args := getMultiQry(buckets, keys)
+	rows, err := db.query(db.db, qry, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get keys of %s", bytes.Join(buckets, []byte(", ")))
+	}
+	defer rows.Close()
+	var (
+		i          int
+		key, value []byte
+	)
+	for rows.Next() {
+		if err := rows.Scan(&i, &key, &value); err != nil {
+			return nil, errors.Wrap(err, "error getting key and value from row")
+		}
+		values[i][string(key)] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(mapError(err), "error accessing row")
+	}
+	return values, nil
+}
+
 // Set inserts the key and value into the given bucket(column).
 func (db *DB) Set(bucket, key, value []byte) error {
-	_, err := db.db.Exec(insertUpdateQry(bucket), key, value, value)
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	if err := db.set(db.db, bucket, key, value); err != nil {
+		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	}
+	return nil
+}
+
+// set inserts or updates the given entry using conn, and its version if the
+// table is versioned.
+func (db *DB) set(conn sqlConn, bucket, key, value []byte) error {
+	versioned, err := db.isVersioned(conn, bucket)
+	switch {
+	case err != nil:
+		return err
+	case versioned:
+		_, err = db.exec(conn, insertUpdateQry(bucket), key, value, newVersion())
+	default:
+		_, err = db.exec(conn, insertUpdateUnversionedQry(bucket), key, value)
+	}
+	return err
+}
+
+// GetVersioned retrieves the value and the version of the given bucket and
+// key.
+func (db *DB) GetVersioned(bucket, key []byte) ([]byte, uint64, error) {
+	if err := db.checkVersioned(bucket); err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	}
+	rows, err := db.query(db.db, getVersionedQry(bucket), key)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, 0, errors.Wrapf(mapError(err), "failed to get %s/%s", bucket, key)
+		}
+		return nil, 0, errors.Wrapf(database.ErrNotFound, "%s/%s not found", bucket, key)
+	}
+	var (
+		value   []byte
+		version uint64
+	)
+	if err := rows.Scan(&value, &version); err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, version, nil
+}
+
+// SetIfVersion inserts the key and value into the given bucket only if the
+// row has the given version, or if the version is 0 and the row does not
+// exist.
+func (db *DB) SetIfVersion(bucket, key, value []byte, version uint64) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	if err := db.checkVersioned(bucket); err != nil {
+		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	}
+
+	if version == 0 {
+		_, err := db.exec(db.db, insertQry(bucket), key, value, newVersion())
+		var mysqlErr *mysql.MySQLError
+		switch {
+		case errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDupEntry:
+			return errors.Wrapf(database.ErrVersionMismatch, "%s/%s already exists", bucket, key)
+		case err != nil:
+			return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+		}
+		return nil
+	}
+
+	res, err := db.exec(db.db, updateIfVersionQry(bucket), value, newVersion(), key, version)
 	if err != nil {
 		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
 	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	} else if n == 0 {
+		return errors.Wrapf(database.ErrVersionMismatch, "%s/%s does not have version %d", bucket, key, version)
+	}
 	return nil
 }
 
+// newVersion returns the version of a new entry, the current time in
+// microseconds, so an entry that is deleted and created again does not reuse
+// a version.
+func newVersion() int64 {
+	return time.Now().UnixMicro()
+}
+
 // Del deletes a row from the database.
 func (db *DB) Del(bucket, key []byte) error {
-	_, err := db.db.Exec(delQry(bucket), key)
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	_, err := db.exec(db.db, delQry(bucket), key)
 	return errors.Wrapf(err, "failed to delete %s/%s", bucket, key)
 }
 
 // List returns the full list of entries in a column.
 func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
-	rows, err := db.db.Query(fmt.Sprintf("SELECT * FROM `%s`", bucket))
+	rows, err := db.query(db.db, fmt.Sprintf("SELECT nkey, nvalue FROM `%s`", bucket))
 	if err != nil {
-		estr := err.Error()
-		if strings.HasPrefix(estr, "Error 1146") {
-			return nil, errors.Wrapf(database.ErrNotFound, estr)
-		}
 		return nil, errors.Wrapf(err, "error querying table %s", bucket)
 	}
 	defer rows.Close()
@@ -143,20 +701,125 @@ func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
 	}
 	err = rows.Err()
 	if err != nil {
-		return nil, errors.Wrap(err, "error accessing row")
+		return nil, errors.Wrap(mapError(err), "error accessing row")
 	}
 	return entries, nil
 }
 
+// maxBulkEntries is the maximum number of entries of a multi-row insert, a
+// prepared statement can have at most 65535 placeholders.
+const maxBulkEntries = 65535 / 3
+
+// ListKeys returns the keys of all the entries in a table, in order.
+func (db *DB) ListKeys(bucket []byte) ([][]byte, error) {
+	rows, err := db.query(db.db, listKeysQry(bucket))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error querying table %s", bucket)
+	}
+	defer rows.Close()
+	var keys [][]byte
+	for rows.Next() {
+		var key []byte
+		if err := rows.Scan(&key); err != nil {
+			return nil, errors.Wrap(err, "error getting key from row")
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(mapError(err), "error accessing row")
+	}
+	return keys, nil
+}
+
+// Count returns the number of entries in a table.
+func (db *DB) Count(bucket []byte) (int, error) {
+	var n int
+	if err := db.queryRow(db.db, &n, countQry(bucket)); err != nil {
+		return 0, errors.Wrapf(err, "error counting entries of table %s", bucket)
+	}
+	return n, nil
+}
+
+// BulkLoad writes the entries of the iterator in the given table, using a
+// multi-row insert per batch.
+func (db *DB) BulkLoad(bucket []byte, iter database.Iterator, opts ...database.BulkOption) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	exists, err := db.TableExists(bucket)
+	switch {
+	case err != nil:
+		return err
+	case !exists:
+		return errors.Wrapf(database.ErrBucketNotFound, "table %s does not exist", bucket)
+	}
+
+	versioned, err := db.isVersioned(db.db, bucket)
+	if err != nil {
+		return err
+	}
+
+	o := database.NewBulkOptions(opts...)
+	return o.Run(iter, maxBulkEntries, func(batch []*database.Entry) error {
+		version := newVersion()
+		args := make([]interface{}, 0, 3*len(batch))
+		for _, e := range batch {
+			args = append(args, e.Key, e.Value)
+			if versioned {
+				args = append(args, version)
+			}
+		}
+		if _, err := db.exec(db.db, bulkInsertQry(bucket, len(batch), versioned), args...); err != nil {
+			return errors.Wrapf(err, "failed to load entries on table %s", bucket)
+		}
+		return nil
+	})
+}
+
+// Truncate deletes all the entries in a table, but keeps the table and the
+// tables nested in it.
+func (db *DB) Truncate(bucket []byte) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	if _, err := db.exec(db.db, truncateQry(bucket)); err != nil {
+		return errors.Wrapf(err, "failed to truncate table %s", bucket)
+	}
+	return nil
+}
+
+// DeleteRange deletes the entries in a table with a key in the range from
+// start, inclusive, to end, exclusive.
+func (db *DB) DeleteRange(bucket, start, end []byte) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	qry, args := deleteRangeQry(bucket, start, end)
+	if _, err := db.exec(db.db, qry, args...); err != nil {
+		return errors.Wrapf(err, "failed to delete range of table %s", bucket)
+	}
+	return nil
+}
+
+// DeletePrefix deletes the entries in a table with a key that starts with the
+// given prefix.
+func (db *DB) DeletePrefix(bucket, prefix []byte) error {
+	return db.DeleteRange(bucket, prefix, database.PrefixEnd(prefix))
+}
+
 // CmpAndSwap modifies the value at the given bucket and key (to newValue)
 // only if the existing (current) value matches oldValue.
 func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
-	sqlTx, err := db.db.Begin()
+	if err := db.autoCreateTables(bucket); err != nil {
+		return nil, false, err
+	}
+
+	sqlTx, err := db.begin()
 	if err != nil {
 		return nil, false, errors.WithStack(err)
 	}
 
-	val, swapped, err := cmpAndSwap(sqlTx, bucket, key, oldValue, newValue)
+	val, swapped, err := db.cmpAndSwap(sqlTx, bucket, key, oldValue, newValue)
 	switch {
 	case err != nil:
 		if err := sqlTx.Rollback(); err != nil {
@@ -165,7 +828,7 @@ func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool,
 		return nil, false, err
 	case swapped:
 		if err := sqlTx.Commit(); err != nil {
-			return nil, false, errors.Wrapf(err, "failed to commit MySQL transaction")
+			return nil, false, errors.Wrapf(mapError(err), "failed to commit MySQL transaction")
 		}
 		return val, swapped, nil
 	default:
@@ -176,9 +839,9 @@ func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool,
 	}
 }
 
-func cmpAndSwap(sqlTx *sql.Tx, bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+func (db *DB) cmpAndSwap(sqlTx *sql.Tx, bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
 	var current []byte
-	err := sqlTx.QueryRow(getQryForUpdate(bucket), key).Scan(&current)
+	err := db.queryRow(sqlTx, &current, getQryForUpdate(bucket), key)
 
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return nil, false, err
@@ -187,15 +850,159 @@ func cmpAndSwap(sqlTx *sql.Tx, bucket, key, oldValue, newValue []byte) ([]byte,
 		return current, false, nil
 	}
 
-	if _, err = sqlTx.Exec(insertUpdateQry(bucket), key, newValue, newValue); err != nil {
+	if err = db.set(sqlTx, bucket, key, newValue); err != nil {
 		return nil, false, errors.Wrapf(err, "failed to set %s/%s", bucket, key)
 	}
 	return newValue, true, nil
 }
 
+// Incr adds delta to the counter stored in the given bucket and key, and
+// returns the new value. The transaction is retried if it fails with
+// database.ErrConflict, a deadlock with a concurrent increment that inserts
+// the same key.
+func (db *DB) Incr(bucket, key []byte, delta int64) (n int64, err error) {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return 0, err
+	}
+	// A conflicting transaction is rolled back, it's safe to run it again.
+	err = database.RetryConflict(db.context(), func() (err error) {
+		n, err = db.incrTx(bucket, key, delta)
+		return err
+	})
+	return n, errors.Wrapf(err, "failed to increment %s/%s", bucket, key)
+}
+
+// incrTx runs incr on its own transaction.
+func (db *DB) incrTx(bucket, key []byte, delta int64) (int64, error) {
+	sqlTx, err := db.begin()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	n, err := db.incr(sqlTx, bucket, key, delta)
+	if err != nil {
+		if err := sqlTx.Rollback(); err != nil {
+			return 0, errors.Wrap(err, "failed to rollback transaction")
+		}
+		return 0, err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return 0, errors.Wrap(mapError(err), "failed to commit MySQL transaction")
+	}
+	return n, nil
+}
+
+// incr locks the stored value and checks that it's a counter before
+// overwriting it, some servers would cast any value to a number.
+func (db *DB) incr(conn sqlConn, bucket, key []byte, delta int64) (int64, error) {
+	var current []byte
+	if err := db.queryRow(conn, &current, getQryForUpdate(bucket), key); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	n, err := database.AddCounter(current, delta)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.set(conn, bucket, key, database.FormatCounter(n)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// NextSequence returns the next number of the sequence of the given table,
+// using a single statement on the sequences table.
+func (db *DB) NextSequence(bucket []byte) (uint64, error) {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return 0, err
+	}
+	if err := db.createSequencesTable(); err != nil {
+		return 0, err
+	}
+	return db.nextSequence(db.db, bucket)
+}
+
+// createSequencesTable creates the sequences table if it's not known to
+// exist. As MySQL commits the current transaction on DDL statements, it must
+// run before starting the transactions that use the table.
+func (db *DB) createSequencesTable() error {
+	if db.sequences.Load() {
+		return nil
+	}
+	if _, err := db.exec(db.db, createSequencesTableQry); err != nil {
+		return errors.Wrap(err, "failed to create sequences table")
+	}
+	db.sequences.Store(true)
+	return nil
+}
+
+// hasSequencesTable returns true if the sequences table exists. The
+// operations that update the sequences of other tables do nothing if it does
+// not exist.
+func (db *DB) hasSequencesTable(conn sqlConn) (bool, error) {
+	if db.sequences.Load() {
+		return true, nil
+	}
+	var n int
+	if err := db.queryRow(conn, &n, sequencesTableExistsQry); err != nil {
+		return false, errors.Wrap(err, "failed to check sequences table")
+	}
+	if n > 0 {
+		db.sequences.Store(true)
+	}
+	return n > 0, nil
+}
+
+func (db *DB) nextSequence(conn sqlConn, bucket []byte) (uint64, error) {
+	var n int
+	if err := db.queryRow(conn, &n, tableExistsQry, string(bucket)); err != nil {
+		return 0, errors.Wrapf(err, "failed to check table %s", bucket)
+	}
+	if n == 0 {
+		return 0, errors.Wrapf(database.ErrBucketNotFound, "table %s does not exist", bucket)
+	}
+	res, err := db.exec(conn, nextSequenceQry, bucket)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get next sequence of table %s", bucket)
+	}
+	seq, err := insertedOrLastID(res, 1)
+	return uint64(seq), errors.Wrapf(err, "failed to get next sequence of table %s", bucket)
+}
+
+// insertedOrLastID returns the given inserted value if the result of an
+// INSERT ... ON DUPLICATE KEY UPDATE statement reports an insert, and the
+// value set with LAST_INSERT_ID otherwise. The updates always change the row,
+// so they report 2 rows affected.
+func insertedOrLastID(res sql.Result, inserted int64) (int64, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		return inserted, nil
+	}
+	return res.LastInsertId()
+}
+
 // Update performs multiple commands on one read-write transaction.
 func (db *DB) Update(tx *database.Tx) error {
-	sqlTx, err := db.db.Begin()
+	var buckets [][]byte
+	for _, q := range tx.Operations {
+		if q.Cmd.IsWrite() {
+			buckets = append(buckets, q.Bucket)
+		}
+	}
+	if err := db.autoCreateTables(buckets...); err != nil {
+		return err
+	}
+	for _, q := range tx.Operations {
+		if q.Cmd == database.NextSequence {
+			if err := db.createSequencesTable(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	sqlTx, err := db.begin()
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -209,22 +1016,16 @@ func (db *DB) Update(tx *database.Tx) error {
 		// create or delete buckets
 		switch q.Cmd {
 		case database.CreateTable:
-			_, err := sqlTx.Exec(createTableQry(q.Bucket))
-			if err != nil {
-				return rollback(errors.Wrapf(err, "failed to create table %s", q.Bucket))
+			if err := db.createTable(sqlTx, q.Bucket); err != nil {
+				return rollback(err)
 			}
 		case database.DeleteTable:
-			_, err := sqlTx.Exec(deleteTableQry(q.Bucket))
-			if err != nil {
-				estr := err.Error()
-				if strings.HasPrefix(err.Error(), "Error 1051") {
-					return errors.Wrapf(database.ErrNotFound, estr)
-				}
-				return errors.Wrapf(err, "failed to delete table %s", q.Bucket)
+			if err := db.deleteTable(sqlTx, q.Bucket); err != nil {
+				return rollback(err)
 			}
 		case database.Get:
 			var val string
-			err := sqlTx.QueryRow(getQry(q.Bucket), q.Key).Scan(&val)
+			err := db.queryRow(sqlTx, &val, getQry(q.Bucket), q.Key)
 			switch {
 			case err == sql.ErrNoRows:
 				return rollback(errors.Wrapf(database.ErrNotFound, "%s/%s not found", q.Bucket, q.Key))
@@ -234,49 +1035,360 @@ func (db *DB) Update(tx *database.Tx) error {
 				q.Result = []byte(val)
 			}
 		case database.Set:
-			if _, err = sqlTx.Exec(insertUpdateQry(q.Bucket), q.Key, q.Value, q.Value); err != nil {
+			if err = db.set(sqlTx, q.Bucket, q.Key, q.Value); err != nil {
 				return rollback(errors.Wrapf(err, "failed to set %s/%s", q.Bucket, q.Key))
 			}
 		case database.Delete:
-			if _, err = sqlTx.Exec(delQry(q.Bucket), q.Key); err != nil {
+			if _, err = db.exec(sqlTx, delQry(q.Bucket), q.Key); err != nil {
 				return rollback(errors.Wrapf(err, "failed to delete %s/%s", q.Bucket, q.Key))
 			}
 		case database.CmpAndSwap:
-			q.Result, q.Swapped, err = cmpAndSwap(sqlTx, q.Bucket, q.Key, q.CmpValue, q.Value)
+			q.Result, q.Swapped, err = db.cmpAndSwap(sqlTx, q.Bucket, q.Key, q.CmpValue, q.Value)
 			if err != nil {
 				return rollback(errors.Wrapf(err, "failed to load-or-store %s/%s", q.Bucket, q.Key))
 			}
+		case database.Truncate, database.DeleteRange, database.DeletePrefix:
+			start, end := deleteRange(q)
+			qry, args := deleteRangeQry(q.Bucket, start, end)
+			if _, err = db.exec(sqlTx, qry, args...); err != nil {
+				return rollback(errors.Wrapf(err, "failed to delete entries of table %s", q.Bucket))
+			}
+		case database.Incr:
+			if q.Counter, err = db.incr(sqlTx, q.Bucket, q.Key, q.Delta); err != nil {
+				return rollback(errors.Wrapf(err, "failed to increment %s/%s", q.Bucket, q.Key))
+			}
+		case database.NextSequence:
+			if q.Sequence, err = db.nextSequence(sqlTx, q.Bucket); err != nil {
+				return rollback(err)
+			}
 		case database.CmpOrRollback:
-			return database.ErrOpNotSupported
+			return rollback(errors.WithStack(database.ErrOpNotSupported))
 		default:
-			return database.ErrOpNotSupported
+			return rollback(errors.WithStack(database.ErrOpNotSupported))
 		}
 	}
 
-	if err = errors.WithStack(sqlTx.Commit()); err != nil {
+	if err = errors.WithStack(mapError(sqlTx.Commit())); err != nil {
 		return rollback(err)
 	}
 	return nil
 }
 
-// CreateTable creates a table in the database.
-func (db *DB) CreateTable(bucket []byte) error {
-	_, err := db.db.Exec(createTableQry(bucket))
+// View performs multiple read commands on one read-only transaction, all of
+// them reading the same snapshot of the database.
+func (db *DB) View(tx *database.Tx) error {
+	if err := tx.CheckReadOnly(); err != nil {
+		return err
+	}
+
+	sqlTx, err := db.beginReadOnly()
 	if err != nil {
-		return errors.Wrapf(err, "failed to create table %s", bucket)
+		return errors.WithStack(err)
+	}
+	rollback := func(err error) error {
+		if rollbackErr := sqlTx.Rollback(); rollbackErr != nil {
+			return errors.Wrap(err, "VIEW failed, unable to rollback transaction")
+		}
+		return errors.Wrap(err, "VIEW failed")
+	}
+	for _, q := range tx.Operations {
+		switch q.Cmd {
+		case database.Get:
+			var val string
+			err := db.queryRow(sqlTx, &val, getQry(q.Bucket), q.Key)
+			switch {
+			case err == sql.ErrNoRows:
+				return rollback(errors.Wrapf(database.ErrNotFound, "%s/%s not found", q.Bucket, q.Key))
+			case err != nil:
+				return rollback(errors.Wrapf(err, "failed to get %s/%s", q.Bucket, q.Key))
+			default:
+				q.Result = []byte(val)
+			}
+		default:
+			return rollback(errors.WithStack(database.ErrOpNotSupported))
+		}
+	}
+
+	if err = errors.WithStack(mapError(sqlTx.Commit())); err != nil {
+		return rollback(err)
 	}
 	return nil
 }
 
-// DeleteTable deletes a table in the database.
+// autoCreateTables creates the given tables if the database was opened with
+// database.WithAutoCreateTables. The tables are created before starting any
+// transaction, as some servers commit the current transaction on DDL
+// statements.
+func (db *DB) autoCreateTables(buckets ...[]byte) error {
+	if !db.autoCreate {
+		return nil
+	}
+	created := make(map[string]bool, len(buckets))
+	for _, bucket := range buckets {
+		if created[string(bucket)] {
+			continue
+		}
+		if err := db.createTable(db.db, bucket); err != nil {
+			return err
+		}
+		created[string(bucket)] = true
+	}
+	return nil
+}
+
+// CreateTable creates a table in the database, and the tables of its parents
+// if it's a nested bucket.
+func (db *DB) CreateTable(bucket []byte) error {
+	return db.createTable(db.db, bucket)
+}
+
+// DeleteTable deletes a table, and the tables nested in it, in the database.
 func (db *DB) DeleteTable(bucket []byte) error {
-	_, err := db.db.Exec(deleteTableQry(bucket))
+	return db.deleteTable(db.db, bucket)
+}
+
+// RenameTable renames a table, and the tables nested in it, using a single
+// RENAME TABLE statement, so all the tables are renamed atomically. The
+// parents of the new table are created first if necessary.
+func (db *DB) RenameTable(oldBucket, newBucket []byte) error {
+	if bytes.Equal(oldBucket, newBucket) || database.IsNestedBucket(newBucket, oldBucket) {
+		return errors.Wrapf(database.ErrInvalidKey, "cannot rename table %s to %s", oldBucket, newBucket)
+	}
+	if err := database.ValidateBucket(newBucket); err != nil {
+		return err
+	}
+
+	tables, err := db.listTables(db.db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !containsTable(tables, oldBucket):
+		return errors.Wrapf(database.ErrBucketNotFound, "table %s does not exist", oldBucket)
+	case containsTable(tables, newBucket):
+		return errors.Wrapf(database.ErrBucketExists, "table %s already exists", newBucket)
+	}
+
+	if parents := database.ParentBuckets(newBucket); len(parents) > 0 {
+		if err := db.createTable(db.db, parents[len(parents)-1]); err != nil {
+			return err
+		}
+	}
+	oldBuckets := append([][]byte{oldBucket}, database.NestedBuckets(tables, oldBucket)...)
+	newBuckets := make([][]byte, len(oldBuckets))
+	for i, table := range oldBuckets {
+		newBuckets[i] = append(append([]byte{}, newBucket...), table[len(oldBucket):]...)
+	}
+	defer db.forgetVersioned(append(oldBuckets, newBuckets...)...)
+	hasSequences, err := db.hasSequencesTable(db.db)
+	if err != nil {
+		return err
+	}
+	if !hasSequences {
+		_, err := db.exec(db.db, renameTableQry(oldBuckets, newBuckets))
+		return errors.Wrapf(err, "failed to rename table %s to %s", oldBucket, newBucket)
+	}
+
+	// The sequences are copied first, a failed rename leaves the sequences of
+	// tables that do not exist, but the sequences of the renamed tables are
+	// never reset.
+	for i := range oldBuckets {
+		if _, err := db.exec(db.db, copySequenceQry, newBuckets[i], oldBuckets[i]); err != nil {
+			return errors.Wrapf(err, "failed to copy sequence of table %s", oldBuckets[i])
+		}
+	}
+	if _, err := db.exec(db.db, renameTableQry(oldBuckets, newBuckets)); err != nil {
+		return errors.Wrapf(err, "failed to rename table %s to %s", oldBucket, newBucket)
+	}
+	return db.deleteSequences(db.db, oldBuckets)
+}
+
+// CopyTable copies a table, and the tables nested in it, to a new table. As
+// MySQL commits the current transaction on DDL statements, the new tables are
+// created first, and then the rows of all the tables are copied in one
+// transaction. The new tables are deleted if the copy fails.
+func (db *DB) CopyTable(srcBucket, dstBucket []byte) error {
+	if bytes.Equal(srcBucket, dstBucket) || database.IsNestedBucket(dstBucket, srcBucket) {
+		return errors.Wrapf(database.ErrInvalidKey, "cannot copy table %s to %s", srcBucket, dstBucket)
+	}
+	if err := database.ValidateBucket(dstBucket); err != nil {
+		return err
+	}
+
+	tables, err := db.listTables(db.db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !containsTable(tables, srcBucket):
+		return errors.Wrapf(database.ErrBucketNotFound, "table %s does not exist", srcBucket)
+	case containsTable(tables, dstBucket):
+		return errors.Wrapf(database.ErrBucketExists, "table %s already exists", dstBucket)
+	}
+
+	srcBuckets := append([][]byte{srcBucket}, database.NestedBuckets(tables, srcBucket)...)
+	dstBuckets := make([][]byte, len(srcBuckets))
+	for i, table := range srcBuckets {
+		dstBuckets[i] = append(append([]byte{}, dstBucket...), table[len(srcBucket):]...)
+	}
+	if err := db.copyTables(srcBuckets, dstBuckets); err != nil {
+		_ = db.deleteTable(db.db, dstBucket)
+		return err
+	}
+	return nil
+}
+
+// copyTables creates the tables dstBuckets and copies to them the rows of the
+// tables srcBuckets.
+func (db *DB) copyTables(srcBuckets, dstBuckets [][]byte) error {
+	for _, table := range dstBuckets {
+		if err := db.createTable(db.db, table); err != nil {
+			return err
+		}
+	}
+	hasSequences, err := db.hasSequencesTable(db.db)
 	if err != nil {
-		estr := err.Error()
-		if strings.HasPrefix(estr, "Error 1051") {
-			return errors.Wrapf(database.ErrNotFound, estr)
+		return err
+	}
+
+	sqlTx, err := db.begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for i := range srcBuckets {
+		err := db.copyTable(sqlTx, srcBuckets[i], dstBuckets[i])
+		if err == nil && hasSequences {
+			_, err = db.exec(sqlTx, copySequenceQry, dstBuckets[i], srcBuckets[i])
+		}
+		if err != nil {
+			if rollbackErr := sqlTx.Rollback(); rollbackErr != nil {
+				return errors.Wrapf(err, "failed to copy table %s, unable to rollback transaction", srcBuckets[i])
+			}
+			return errors.Wrapf(err, "failed to copy table %s", srcBuckets[i])
+		}
+	}
+	return errors.Wrap(mapError(sqlTx.Commit()), "failed to commit MySQL transaction")
+}
+
+// copyTable copies the rows of srcBucket to dstBucket using conn, with their
+// versions if both tables are versioned.
+func (db *DB) copyTable(conn sqlConn, srcBucket, dstBucket []byte) error {
+	versioned, err := db.isVersioned(conn, srcBucket)
+	if err == nil && versioned {
+		versioned, err = db.isVersioned(conn, dstBucket)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = db.exec(conn, copyTableQry(srcBucket, dstBucket, versioned))
+	return err
+}
+
+// createTable creates a table, and the tables of its parents, using conn.
+func (db *DB) createTable(conn sqlConn, bucket []byte) error {
+	if err := database.ValidateBucket(bucket); err != nil {
+		return err
+	}
+	for _, table := range append(database.ParentBuckets(bucket), bucket) {
+		if _, err := db.exec(conn, createTableQry(table)); err != nil {
+			return errors.Wrapf(err, "failed to create table %s", table)
 		}
+	}
+	return nil
+}
+
+// deleteTable deletes a table, and the tables nested in it, using a single
+// DROP TABLE statement on conn.
+func (db *DB) deleteTable(conn sqlConn, bucket []byte) error {
+	tables, err := db.listTables(conn)
+	if err != nil {
+		return err
+	}
+	// A missing table is reported by the server.
+	buckets := [][]byte{bucket}
+	if containsTable(tables, bucket) {
+		buckets = append(buckets, database.NestedBuckets(tables, bucket)...)
+	}
+	defer db.forgetVersioned(buckets...)
+	if _, err := db.exec(conn, deleteTableQry(buckets...)); err != nil {
 		return errors.Wrapf(err, "failed to delete table %s", bucket)
 	}
+	return db.deleteSequences(conn, buckets)
+}
+
+// deleteSequences resets the sequences of the given tables using conn.
+func (db *DB) deleteSequences(conn sqlConn, buckets [][]byte) error {
+	if ok, err := db.hasSequencesTable(conn); err != nil || !ok {
+		return err
+	}
+	args := make([]interface{}, len(buckets))
+	for i, bucket := range buckets {
+		args[i] = bucket
+	}
+	if _, err := db.exec(conn, deleteSequencesQry(len(buckets)), args...); err != nil {
+		return errors.Wrap(err, "failed to delete sequences")
+	}
 	return nil
 }
+
+// ListTables returns the names of the tables in the database.
+func (db *DB) ListTables() ([][]byte, error) {
+	return db.listTables(db.db)
+}
+
+// listTables returns the names of the tables in the database using conn.
+func (db *DB) listTables(conn sqlConn) ([][]byte, error) {
+	rows, err := db.query(conn, listTablesQry)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing tables")
+	}
+	defer rows.Close()
+	var tables [][]byte
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "error getting table name from row")
+		}
+		tables = append(tables, []byte(name))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(mapError(err), "error accessing row")
+	}
+	// ORDER BY would depend on the collation of information_schema.
+	sort.Slice(tables, func(i, j int) bool {
+		return bytes.Compare(tables[i], tables[j]) < 0
+	})
+	return tables, nil
+}
+
+// TableExists returns true if the table exists in the database.
+func (db *DB) TableExists(bucket []byte) (bool, error) {
+	var n int
+	if err := db.queryRow(db.db, &n, tableExistsQry, string(bucket)); err != nil {
+		return false, errors.Wrapf(err, "failed to check table %s", bucket)
+	}
+	return n > 0, nil
+}
+
+// containsTable returns true if tables contains bucket.
+func containsTable(tables [][]byte, bucket []byte) bool {
+	for _, table := range tables {
+		if bytes.Equal(table, bucket) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteRange returns the range of keys deleted by the Truncate, DeleteRange
+// or DeletePrefix commands.
+func deleteRange(q *database.TxEntry) (start, end []byte) {
+	switch q.Cmd {
+	case database.DeleteRange:
+		return q.Key, q.End
+	case database.DeletePrefix:
+		return q.Key, database.PrefixEnd(q.Key)
+	default:
+		return nil, nil
+	}
+}
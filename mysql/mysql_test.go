@@ -0,0 +1,260 @@
+//go:build !nomysql
+// +build !nomysql
+
+package mysql_test
+
+import (
+	"context"
+	gosql "database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	"github.com/dolthub/go-mysql-server/sql"
+	vitess "github.com/dolthub/vitess/go/mysql"
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+	"github.com/smallstep/nosql/mysql"
+	"github.com/smallstep/nosql/nosqltest"
+)
+
+func TestMain(m *testing.M) {
+	// Silence the connection and query logs of the server.
+	logrus.SetLevel(logrus.ErrorLevel)
+	os.Exit(m.Run())
+}
+
+// newServer starts an in-process MySQL compatible server, backed by an
+// in-memory database, and returns the DSN to connect to it.
+func newServer(t *testing.T) string {
+	t.Helper()
+
+	pro := memory.NewDBProvider()
+	engine := sqle.NewDefault(pro)
+	sessionBuilder := func(ctx context.Context, conn *vitess.Conn, addr string) (sql.Session, error) {
+		session := sql.NewBaseSessionWithClientServer(addr, sql.Client{Capabilities: conn.Capabilities}, conn.ConnectionID)
+		return memory.NewSession(session, pro), nil
+	}
+
+	s, err := server.NewServer(server.Config{
+		Protocol: "tcp",
+		Address:  "127.0.0.1:0",
+	}, engine, sessionBuilder, nil)
+	assert.FatalError(t, err)
+	go func() {
+		if err := s.Start(); err != nil && !errors.Is(err, net.ErrClosed) {
+			t.Errorf("error running MySQL server: %v", err)
+		}
+	}()
+	t.Cleanup(func() { s.Close() })
+
+	return fmt.Sprintf("root@tcp(%s)/", s.Listener.Addr())
+}
+
+// newDB is a nosqltest.Factory that opens a database on a new in-process
+// server.
+func newDB(t *testing.T) database.DB {
+	return openDB(t)
+}
+
+// openDB opens a database with the given options on a new in-process server.
+func openDB(t *testing.T, opts ...database.Option) database.DB {
+	db := &mysql.DB{}
+	opts = append([]database.Option{database.WithDatabase("test")}, opts...)
+	assert.FatalError(t, db.Open(newServer(t), opts...))
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// skipRowLocking skips the conformance tests that require row locking. The
+// in-memory engine does not lock rows, so concurrent transactions can lose
+// updates. It's a gap of the engine, not of the driver, tracked by
+// TestServer_rowLocking, and the skipped tests run against a MySQL server on
+// CI, see TestMySQL.
+var skipRowLocking = nosqltest.WithSkip(
+	"go-mysql-server does not implement row locking, see TestServer_rowLocking", "Concurrency",
+)
+
+func TestDB_conformance(t *testing.T) {
+	nosqltest.RunConformance(t, newDB, skipRowLocking)
+}
+
+// TestServer_rowLocking checks that the in-process server still does not lock
+// the rows selected with FOR UPDATE. It fails once the server implements it,
+// so skipRowLocking can be removed.
+func TestServer_rowLocking(t *testing.T) {
+	dataSourceName := newServer(t)
+	db := &mysql.DB{}
+	assert.FatalError(t, db.Open(dataSourceName, database.WithDatabase("test")))
+	defer db.Close()
+	bucket, key := []byte("bucket"), []byte("key")
+	assert.FatalError(t, db.CreateTable(bucket))
+	assert.FatalError(t, db.Set(bucket, key, []byte("value")))
+
+	sqlDB, err := gosql.Open("mysql", dataSourceName+"test")
+	assert.FatalError(t, err)
+	defer sqlDB.Close()
+
+	// The second lock waits for the first one until the context expires if
+	// the server locks rows.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lock := func() error {
+		tx, err := sqlDB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		t.Cleanup(func() { tx.Rollback() })
+		var value []byte
+		return tx.QueryRowContext(ctx, "SELECT nvalue FROM `bucket` WHERE nkey = ? FOR UPDATE", key).Scan(&value)
+	}
+	assert.FatalError(t, lock())
+	if err := lock(); err != nil {
+		t.Fatalf("go-mysql-server locks rows, remove skipRowLocking: %v", err)
+	}
+}
+
+func TestDB_differential(t *testing.T) {
+	nosqltest.RunDifferential(t, newDB, nosqltest.DifferentialConfig{
+		MissingBuckets: true,
+	})
+}
+
+func TestDB_autoCreateTables(t *testing.T) {
+	newDB := func(t *testing.T) database.DB {
+		return openDB(t, database.WithAutoCreateTables())
+	}
+	nosqltest.RunConformance(t, newDB, nosqltest.ExpectAutoCreateTables(), skipRowLocking)
+	nosqltest.RunDifferential(t, newDB, nosqltest.DifferentialConfig{
+		MissingBuckets:   true,
+		AutoCreateTables: true,
+	})
+}
+
+// TestNewDB checks that nosqltest.NewDB drops the databases it creates.
+func TestNewDB(t *testing.T) {
+	dataSourceName := newServer(t)
+
+	var name string
+	t.Run("create", func(t *testing.T) {
+		db := nosqltest.NewDB(t, "mysql", nosqltest.WithDataSourceName(dataSourceName),
+			nosqltest.WithFixtures(nosqltest.Fixtures{"bucket": {"key": []byte("value")}}))
+		v, err := db.Get([]byte("bucket"), []byte("key"))
+		assert.FatalError(t, err)
+		assert.Equals(t, []byte("value"), v)
+		name = databases(t, dataSourceName)[0]
+	})
+
+	assert.Equals(t, "nosqltest_", name[:len("nosqltest_")])
+	assert.Len(t, 0, databases(t, dataSourceName))
+}
+
+// databases returns the user databases on the server.
+func databases(t *testing.T, dataSourceName string) []string {
+	db, err := gosql.Open("mysql", dataSourceName)
+	assert.FatalError(t, err)
+	defer db.Close()
+
+	rows, err := db.Query("SHOW DATABASES")
+	assert.FatalError(t, err)
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		assert.FatalError(t, rows.Scan(&name))
+		switch name {
+		case "information_schema", "mysql":
+		default:
+			names = append(names, name)
+		}
+	}
+	assert.FatalError(t, rows.Err())
+	return names
+}
+
+func TestDB_errors(t *testing.T) {
+	db := newDB(t)
+
+	err := db.DeleteTable([]byte("missing"))
+	assert.True(t, database.IsErrBucketNotFound(err))
+	var mysqlErr *gomysql.MySQLError
+	assert.True(t, errors.As(err, &mysqlErr))
+
+	assert.FatalError(t, db.Close())
+	_, err = db.Get([]byte("bucket"), []byte("key"))
+	assert.True(t, database.IsErrClosed(err))
+	assert.True(t, database.IsErrClosed(db.Update(new(database.Tx))))
+}
+
+// TestDB_versionMigration checks that tables created before versions can be
+// written without the nversion column, and that they get it only if the
+// database is opened using WithSchemaMigration.
+func TestDB_versionMigration(t *testing.T) {
+	dataSourceName := newServer(t)
+
+	sqlDB, err := gosql.Open("mysql", dataSourceName)
+	assert.FatalError(t, err)
+	defer sqlDB.Close()
+	for _, qry := range []string{
+		"CREATE DATABASE test",
+		"CREATE TABLE test.`bucket`(nkey VARBINARY(255), nvalue BLOB, PRIMARY KEY (nkey))",
+		"INSERT INTO test.`bucket`(nkey, nvalue) VALUES('key', 'value')",
+	} {
+		_, err := sqlDB.Exec(qry)
+		assert.FatalError(t, err)
+	}
+	bucket, key := []byte("bucket"), []byte("key")
+
+	// Without the migration the table is not altered, it can be read and
+	// written, but the versions are not available.
+	db := &mysql.DB{}
+	assert.FatalError(t, db.Open(dataSourceName, database.WithDatabase("test")))
+	v, err := db.Get(bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("value"), v)
+	assert.FatalError(t, db.Set(bucket, key, []byte("set")))
+	_, swapped, err := db.CmpAndSwap(bucket, key, []byte("set"), []byte("swapped"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	n, err := db.Incr(bucket, []byte("counter"), 2)
+	assert.FatalError(t, err)
+	assert.Equals(t, int64(2), n)
+	tx := new(database.Tx)
+	tx.Set(bucket, key, []byte("other"))
+	assert.FatalError(t, db.Update(tx))
+	assert.FatalError(t, db.BulkLoad(bucket, database.EntriesIterator([]*database.Entry{
+		{Key: []byte("loaded"), Value: []byte("value")},
+	})))
+	_, _, err = db.GetVersioned(bucket, key)
+	assert.True(t, strings.Contains(fmt.Sprint(err), "WithSchemaMigration"))
+	err = db.SetIfVersion(bucket, key, []byte("value"), 1)
+	assert.True(t, strings.Contains(fmt.Sprint(err), "WithSchemaMigration"))
+	assert.FatalError(t, db.Close())
+
+	// The existing entries have version 1, and opening the database again
+	// keeps the new versions.
+	for _, value := range []string{"other", "new"} {
+		db := &mysql.DB{}
+		assert.FatalError(t, db.Open(dataSourceName, database.WithDatabase("test"), database.WithSchemaMigration()))
+		v, version, err := db.GetVersioned(bucket, key)
+		assert.FatalError(t, err)
+		assert.Equals(t, []byte(value), v)
+		if value == "other" {
+			assert.Equals(t, uint64(1), version)
+			assert.FatalError(t, db.SetIfVersion(bucket, key, []byte("new"), version))
+		} else {
+			assert.NotEquals(t, uint64(1), version)
+		}
+		assert.FatalError(t, db.Close())
+	}
+}
@@ -18,11 +18,41 @@ type Option = database.Option
 // DB is just a wrapper over database.DB.
 type DB = database.DB
 
-// Compactor in an interface implemented by those databases that can run a value
-// log garbage collector like badger.
-type Compactor interface {
-	Compact(discardRatio float64) error
-}
+// Compactor is just a wrapper over database.Compactor.
+type Compactor = database.Compactor
+
+// TableLister is just a wrapper over database.TableLister.
+type TableLister = database.TableLister
+
+// TableRenamer is just a wrapper over database.TableRenamer.
+type TableRenamer = database.TableRenamer
+
+// TableCopier is just a wrapper over database.TableCopier.
+type TableCopier = database.TableCopier
+
+// RangeDeleter is just a wrapper over database.RangeDeleter.
+type RangeDeleter = database.RangeDeleter
+
+// MultiGetter is just a wrapper over database.MultiGetter.
+type MultiGetter = database.MultiGetter
+
+// Viewer is just a wrapper over database.Viewer.
+type Viewer = database.Viewer
+
+// BulkLoader is just a wrapper over database.BulkLoader.
+type BulkLoader = database.BulkLoader
+
+// KeyLister is just a wrapper over database.KeyLister.
+type KeyLister = database.KeyLister
+
+// Versioned is just a wrapper over database.Versioned.
+type Versioned = database.Versioned
+
+// Counter is just a wrapper over database.Counter.
+type Counter = database.Counter
+
+// Middleware is just a wrapper over database.Middleware.
+type Middleware = database.Middleware
 
 var (
 	// WithValueDir is a wrapper over database.WithValueDir.
@@ -31,10 +61,77 @@ var (
 	WithDatabase = database.WithDatabase
 	// WithBadgerFileLoadingMode is a wrapper over database.WithBadgerFileLoadingMode.
 	WithBadgerFileLoadingMode = database.WithBadgerFileLoadingMode
+	// WithNoSync is a wrapper over database.WithNoSync.
+	WithNoSync = database.WithNoSync
+	// WithAutoCreateTables is a wrapper over database.WithAutoCreateTables.
+	WithAutoCreateTables = database.WithAutoCreateTables
+	// WithSchemaMigration is a wrapper over database.WithSchemaMigration.
+	WithSchemaMigration = database.WithSchemaMigration
+	// WithMiddleware is a wrapper over database.WithMiddleware.
+	WithMiddleware = database.WithMiddleware
+	// Unwrap is a wrapper over database.Unwrap.
+	Unwrap = database.Unwrap
+	// As is a wrapper over database.As.
+	As = database.As
 	// IsErrNotFound is a wrapper over database.IsErrNotFound.
 	IsErrNotFound = database.IsErrNotFound
 	// IsErrOpNotSupported is a wrapper over database.IsErrOpNotSupported.
 	IsErrOpNotSupported = database.IsErrOpNotSupported
+	// IsErrBucketNotFound is a wrapper over database.IsErrBucketNotFound.
+	IsErrBucketNotFound = database.IsErrBucketNotFound
+	// IsErrBucketExists is a wrapper over database.IsErrBucketExists.
+	IsErrBucketExists = database.IsErrBucketExists
+	// IsErrConflict is a wrapper over database.IsErrConflict.
+	IsErrConflict = database.IsErrConflict
+	// IsErrTxTooLarge is a wrapper over database.IsErrTxTooLarge.
+	IsErrTxTooLarge = database.IsErrTxTooLarge
+	// IsErrInvalidKey is a wrapper over database.IsErrInvalidKey.
+	IsErrInvalidKey = database.IsErrInvalidKey
+	// IsErrClosed is a wrapper over database.IsErrClosed.
+	IsErrClosed = database.IsErrClosed
+	// IsErrReadOnly is a wrapper over database.IsErrReadOnly.
+	IsErrReadOnly = database.IsErrReadOnly
+	// IsErrVersionMismatch is a wrapper over database.IsErrVersionMismatch.
+	IsErrVersionMismatch = database.IsErrVersionMismatch
+	// ListTables is a wrapper over database.ListTables.
+	ListTables = database.ListTables
+	// TableExists is a wrapper over database.TableExists.
+	TableExists = database.TableExists
+	// RenameTable is a wrapper over database.RenameTable.
+	RenameTable = database.RenameTable
+	// CopyTable is a wrapper over database.CopyTable.
+	CopyTable = database.CopyTable
+	// TruncateTable is a wrapper over database.TruncateTable.
+	TruncateTable = database.TruncateTable
+	// DeleteKeyRange is a wrapper over database.DeleteKeyRange.
+	DeleteKeyRange = database.DeleteKeyRange
+	// DeleteKeyPrefix is a wrapper over database.DeleteKeyPrefix.
+	DeleteKeyPrefix = database.DeleteKeyPrefix
+	// GetMulti is a wrapper over database.GetMulti.
+	GetMulti = database.GetMulti
+	// GetMultiBuckets is a wrapper over database.GetMultiBuckets.
+	GetMultiBuckets = database.GetMultiBuckets
+	// View is a wrapper over database.View.
+	View = database.View
+	// BulkLoad is a wrapper over database.BulkLoad.
+	BulkLoad = database.BulkLoad
+	// ListKeys is a wrapper over database.ListKeys.
+	ListKeys = database.ListKeys
+	// Count is a wrapper over database.Count.
+	Count = database.Count
+	// GetVersioned is a wrapper over database.GetVersioned.
+	GetVersioned = database.GetVersioned
+	// SetIfVersion is a wrapper over database.SetIfVersion.
+	SetIfVersion = database.SetIfVersion
+	// IncrCounter is a wrapper over database.IncrCounter.
+	IncrCounter = database.IncrCounter
+	// NextTableSequence is a wrapper over database.NextTableSequence.
+	NextTableSequence = database.NextTableSequence
+	// ListChildren is a wrapper over database.ListChildren.
+	ListChildren = database.ListChildren
+
+	// BucketSeparator is the separator of the names of nested buckets.
+	BucketSeparator = database.BucketSeparator
 
 	// Available db driver types. //
 
@@ -59,7 +156,10 @@ var (
 	BadgerFileIO = database.BadgerFileIO
 )
 
-// New returns a database with the given driver.
+// New returns a database with the given driver. The database is decorated
+// with the middleware set using WithMiddleware. If the options are not valid
+// or the database cannot be opened, it returns the error and the database
+// without middleware, not opened.
 func New(driver, dataSourceName string, opt ...Option) (db database.DB, err error) {
 	switch strings.ToLower(driver) {
 	case BadgerDriver, BadgerV1Driver:
@@ -75,6 +175,24 @@ func New(driver, dataSourceName string, opt ...Option) (db database.DB, err erro
 	default:
 		return nil, errors.Errorf("%s database not supported", driver)
 	}
-	err = db.Open(dataSourceName, opt...)
-	return
+
+	opts := &database.Options{}
+	for _, o := range opt {
+		if err = o(opts); err != nil {
+			return db, err
+		}
+	}
+	if err = db.Open(dataSourceName, opt...); err != nil {
+		return db, err
+	}
+	return Chain(db, opts.Middleware...), nil
+}
+
+// Chain decorates db with the given middleware. The first middleware is the
+// outermost one, so Chain(db, a, b) returns a(b(db)).
+func Chain(db DB, mws ...Middleware) DB {
+	for i := len(mws) - 1; i >= 0; i-- {
+		db = mws[i](db)
+	}
+	return db
 }
@@ -0,0 +1,154 @@
+package nosqltest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+)
+
+// BenchmarkFactory returns an open database.DB used on the benchmark b. Like
+// Factory, it might return a new database or the same one each time, and it
+// is responsible for closing it.
+type BenchmarkFactory func(b *testing.B) database.DB
+
+// Sizes used by the benchmarks.
+const (
+	benchValueSize = 128
+	benchKeys      = 1000
+	benchBatchSize = 1000
+)
+
+// benchUpdateOps is the number of operations per transaction on the Update
+// benchmarks.
+var benchUpdateOps = []int{1, 10, 100}
+
+// benchListEntries is the number of entries on the List benchmarks, the
+// largest size is skipped in short mode.
+var benchListEntries = []int{10_000, 100_000, 1_000_000}
+
+// RunBenchmarks runs the same workloads on the databases returned by newDB,
+// so the results of different drivers can be compared:
+//
+//   - Get: point reads of existing keys.
+//   - Set: writes of new keys.
+//   - CmpAndSwap: concurrent increments of the same key.
+//   - Update/ops=N: transactions with N writes.
+//   - List/entries=N: listing a bucket with N entries.
+//
+// Values are 128 bytes long.
+func RunBenchmarks(b *testing.B, newDB BenchmarkFactory) {
+	b.Run("Get", func(b *testing.B) {
+		db := newDB(b)
+		bucket := newTable(b, db, "nosqltest-bench-get")
+		load(b, db, bucket, benchKeys)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := db.Get(bucket, benchKey(i%benchKeys)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Set", func(b *testing.B) {
+		db := newDB(b)
+		bucket := newTable(b, db, "nosqltest-bench-set")
+		value := benchValue()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := db.Set(bucket, benchKey(i), value); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("CmpAndSwap", func(b *testing.B) {
+		db := newDB(b)
+		bucket := newTable(b, db, "nosqltest-bench-cas")
+		key := []byte("counter")
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := increment(db, bucket, key); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	b.Run("Update", func(b *testing.B) {
+		for _, n := range benchUpdateOps {
+			b.Run(fmt.Sprintf("ops=%d", n), func(b *testing.B) {
+				db := newDB(b)
+				bucket := newTable(b, db, "nosqltest-bench-update")
+				value := benchValue()
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					tx := new(database.Tx)
+					for j := 0; j < n; j++ {
+						tx.Set(bucket, benchKey(i*n+j), value)
+					}
+					if err := db.Update(tx); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	})
+
+	b.Run("List", func(b *testing.B) {
+		for _, n := range benchListEntries {
+			if n > 100_000 && testing.Short() {
+				continue
+			}
+			// The bucket is loaded once, outside the benchmark function,
+			// that runs multiple times.
+			db := newDB(b)
+			bucket := newTable(b, db, fmt.Sprintf("nosqltest-bench-list-%d", n))
+			load(b, db, bucket, n)
+			b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					entries, err := db.List(bucket)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(entries) != n {
+						b.Fatalf("List returned %d entries, want %d", len(entries), n)
+					}
+				}
+			})
+		}
+	})
+}
+
+// load stores n keys in bucket using transactions of benchBatchSize writes.
+func load(b *testing.B, db database.DB, bucket []byte, n int) {
+	b.Helper()
+	value := benchValue()
+	for i := 0; i < n; i += benchBatchSize {
+		tx := new(database.Tx)
+		for j := i; j < n && j < i+benchBatchSize; j++ {
+			tx.Set(bucket, benchKey(j), value)
+		}
+		assert.FatalError(b, db.Update(tx))
+	}
+}
+
+func benchKey(i int) []byte {
+	return []byte(fmt.Sprintf("key-%08d", i))
+}
+
+func benchValue() []byte {
+	v := make([]byte, benchValueSize)
+	for i := range v {
+		v[i] = byte('a' + i%26)
+	}
+	return v
+}
@@ -0,0 +1,1655 @@
+// Package nosqltest provides utilities to test database.DB implementations
+// and the applications using them.
+//
+// RunConformance runs the suite of tests that defines the behavior shared by
+// all the drivers in this module. Third-party drivers can use it to prove that
+// they are interchangeable with the built-in ones:
+//
+//	func TestConformance(t *testing.T) {
+//		nosqltest.RunConformance(t, func(t *testing.T) database.DB {
+//			db := &mydriver.DB{}
+//			if err := db.Open(filepath.Join(t.TempDir(), "db")); err != nil {
+//				t.Fatal(err)
+//			}
+//			t.Cleanup(func() { db.Close() })
+//			return db
+//		})
+//	}
+//
+// Besides database.DB, the suite uses the optional interfaces of the database
+// package, like database.TableLister, that the drivers in this module
+// implement. The tests of the ones a driver does not implement can be skipped
+// using WithSkip.
+//
+// RunDifferential and RunLinearizability complement the conformance suite
+// with randomized tests: the former compares sequences of operations against
+// a reference model, and the latter checks that concurrent operations are
+// linearizable.
+package nosqltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+	"github.com/smallstep/nosql/retry"
+)
+
+// Factory returns an open database.DB used on the test t. It's called once
+// per subtest, it might return a new database or the same one each time, but
+// the factory is responsible for closing it, usually using t.Cleanup.
+type Factory func(t *testing.T) database.DB
+
+type conformanceOptions struct {
+	skip       map[string]string
+	autoCreate bool
+}
+
+// ConformanceOption is the modifier type used to configure RunConformance.
+type ConformanceOption func(o *conformanceOptions)
+
+// WithSkip skips the conformance tests with the given names, reporting the
+// given reason. It is meant for backends used as test doubles that do not
+// implement some guarantees, e.g. row locking, the names are the ones of the
+// subtests run by RunConformance, like "Concurrency".
+func WithSkip(reason string, names ...string) ConformanceOption {
+	return func(o *conformanceOptions) {
+		for _, name := range names {
+			o.skip[name] = reason
+		}
+	}
+}
+
+// ExpectAutoCreateTables declares that the databases returned by the factory
+// were opened using database.WithAutoCreateTables, so the write operations
+// on buckets that do not exist are expected to create them instead of failing
+// with database.ErrBucketNotFound.
+func ExpectAutoCreateTables() ConformanceOption {
+	return func(o *conformanceOptions) {
+		o.autoCreate = true
+	}
+}
+
+// RunConformance runs the conformance test suite against the databases
+// returned by newDB.
+func RunConformance(t *testing.T, newDB Factory, opts ...ConformanceOption) {
+	t.Helper()
+	o := &conformanceOptions{
+		skip: make(map[string]string),
+	}
+	for _, fn := range opts {
+		fn(o)
+	}
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, db database.DB)
+	}{
+		{"Scenario", testScenario},
+		{"TableLifecycle", testTableLifecycle},
+		{"ListTables", testListTables},
+		{"NestedBuckets", testNestedBuckets},
+		{"CopyTable", testCopyTable},
+		{"GetSetDel", testGetSetDel},
+		{"DeleteRange", testDeleteRange},
+		{"GetMulti", testGetMulti},
+		{"BulkLoad", testBulkLoad},
+		{"CmpAndSwap", testCmpAndSwap},
+		{"Versions", testVersions},
+		{"Incr", testIncr},
+		{"NextSequence", testNextSequence},
+		{"Update", testUpdate},
+		{"UpdateRollback", testUpdateRollback},
+		{"View", testView},
+		{"List", testList},
+		{"ListKeys", testListKeys},
+		{"BinaryData", testBinaryData},
+		{"LargeData", testLargeData},
+		{"Concurrency", testConcurrency},
+		{"ErrNotFound", testErrNotFound},
+		{"Buckets", func(t *testing.T, db database.DB) {
+			testBuckets(t, db, o.autoCreate)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reason, ok := o.skip[tt.name]; ok {
+				t.Skip(reason)
+			}
+			tt.fn(t, newDB(t))
+		})
+	}
+}
+
+// newTable creates a table for the test t, deleting it on cleanup. The table
+// is deleted first in case a previous run left it behind.
+func newTable(t testing.TB, db database.DB, name string) []byte {
+	t.Helper()
+	bucket := []byte(name)
+	_ = db.DeleteTable(bucket)
+	assert.FatalError(t, db.CreateTable(bucket))
+	t.Cleanup(func() {
+		_ = db.DeleteTable(bucket)
+	})
+	return bucket
+}
+
+// sortEntries sorts the entries by key, drivers do not guarantee the order of
+// the entries returned by List.
+func sortEntries(entries []*database.Entry) []*database.Entry {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+	return entries
+}
+
+type testUser struct {
+	Fname, lname string
+	numPets      int
+}
+
+// testScenario runs a sequence of operations that mimics the usage of a
+// database by an application.
+func testScenario(t *testing.T, db database.DB) {
+	var boogers = []byte("boogers")
+
+	ub := []byte("testNoSQLUsers")
+	_ = db.DeleteTable(ub)
+	assert.True(t, database.IsErrNotFound(db.DeleteTable(ub)))
+	assert.Nil(t, db.CreateTable(ub))
+	// Verify that re-creating the table does not cause a "table already exists" error
+	assert.Nil(t, db.CreateTable(ub))
+
+	// Test that we can create tables with illegal/special characters (e.g. `-`)
+	illName := []byte("test-special-char")
+	assert.Nil(t, db.CreateTable(illName))
+	assert.Nil(t, db.DeleteTable(illName))
+	_, err := db.List(illName)
+	assert.True(t, database.IsErrNotFound(err))
+
+	// List should be empty
+	entries, err := db.List(ub)
+	assert.Nil(t, err)
+	assert.Equals(t, len(entries), 0)
+
+	// check for mike - should not exist
+	_, err = db.Get(ub, []byte("mike"))
+	assert.True(t, database.IsErrNotFound(err))
+
+	// add mike
+	assert.Nil(t, db.Set(ub, []byte("mike"), boogers))
+
+	// verify that mike is in db
+	res, err := db.Get(ub, []byte("mike"))
+	assert.FatalError(t, err)
+	assert.Equals(t, boogers, res)
+
+	// overwrite mike
+	mike := testUser{"mike", "malone", 1}
+	mikeb, err := json.Marshal(mike)
+	assert.FatalError(t, err)
+
+	assert.Nil(t, db.Set(ub, []byte("mike"), mikeb))
+	// verify overwrite
+	res, err = db.Get(ub, []byte("mike"))
+	assert.FatalError(t, err)
+	assert.Equals(t, mikeb, res)
+
+	var swapped bool
+	// CmpAndSwap should load since mike is not nil
+	res, swapped, err = db.CmpAndSwap(ub, []byte("mike"), nil, boogers)
+	assert.FatalError(t, err)
+	assert.Equals(t, mikeb, res)
+	assert.False(t, swapped)
+	assert.Nil(t, err)
+
+	// delete mike
+	assert.FatalError(t, db.Del(ub, []byte("mike")))
+
+	// CmpAndSwap should overwrite mike since mike is nil
+	res, swapped, err = db.CmpAndSwap(ub, []byte("mike"), nil, boogers)
+	assert.FatalError(t, err)
+	assert.Equals(t, boogers, res)
+	assert.True(t, swapped)
+	assert.Nil(t, err)
+
+	// delete mike
+	assert.FatalError(t, db.Del(ub, []byte("mike")))
+
+	// check for mike - should not exist
+	_, err = db.Get(ub, []byte("mike"))
+	assert.True(t, database.IsErrNotFound(err))
+
+	// CmpAndSwap should store since mike does not exist
+	res, swapped, err = db.CmpAndSwap(ub, []byte("mike"), nil, mikeb)
+	assert.FatalError(t, err)
+	assert.Equals(t, res, mikeb)
+	assert.True(t, swapped)
+	assert.Nil(t, err)
+
+	// delete mike
+	assert.FatalError(t, db.Del(ub, []byte("mike")))
+
+	// Update //
+
+	// create txns for update test
+	mariano := testUser{"mariano", "Cano", 2}
+	marianob, err := json.Marshal(mariano)
+	assert.FatalError(t, err)
+	seb := testUser{"sebastian", "tiedtke", 0}
+	sebb, err := json.Marshal(seb)
+	assert.FatalError(t, err)
+	gates := testUser{"bill", "gates", 2}
+	gatesb, err := json.Marshal(gates)
+	assert.FatalError(t, err)
+
+	casGates := &database.TxEntry{
+		Bucket:   ub,
+		Key:      []byte("bill"),
+		Value:    gatesb,
+		CmpValue: nil,
+		Cmd:      database.CmpAndSwap,
+	}
+	setMike := &database.TxEntry{
+		Bucket: ub,
+		Key:    []byte("mike"),
+		Value:  mikeb,
+		Cmd:    database.Set,
+	}
+	readMike := &database.TxEntry{
+		Bucket: ub,
+		Key:    []byte("mike"),
+		Cmd:    database.Get,
+	}
+	setMariano := &database.TxEntry{
+		Bucket: ub,
+		Key:    []byte("mariano"),
+		Value:  marianob,
+		Cmd:    database.Set,
+	}
+	setSeb := &database.TxEntry{
+		Bucket: ub,
+		Key:    []byte("sebastian"),
+		Value:  sebb,
+		Cmd:    database.Set,
+	}
+	readSeb := &database.TxEntry{
+		Bucket: ub,
+		Key:    []byte("sebastian"),
+		Cmd:    database.Get,
+	}
+	casGates2 := &database.TxEntry{
+		Bucket:   ub,
+		Key:      []byte("bill"),
+		Value:    boogers,
+		CmpValue: gatesb,
+		Cmd:      database.CmpAndSwap,
+	}
+	casGates3 := &database.TxEntry{
+		Bucket:   ub,
+		Key:      []byte("bill"),
+		Value:    []byte("belly-button-lint"),
+		CmpValue: gatesb,
+		Cmd:      database.CmpAndSwap,
+	}
+
+	// update: read write multiple entries.
+	tx := &database.Tx{Operations: []*database.TxEntry{setMike, setMariano, readMike, setSeb, readSeb, casGates, casGates2, casGates3}}
+	assert.Nil(t, db.Update(tx))
+
+	// verify that mike is in db
+	res, err = db.Get(ub, []byte("mike"))
+	assert.FatalError(t, err)
+	assert.Equals(t, mikeb, res)
+
+	// verify that mariano is in db
+	res, err = db.Get(ub, []byte("mariano"))
+	assert.FatalError(t, err)
+	assert.Equals(t, marianob, res)
+
+	// verify that bill gates is in db
+	res, err = db.Get(ub, []byte("bill"))
+	assert.FatalError(t, err)
+	assert.Equals(t, boogers, res)
+
+	// verify that seb is in db
+	res, err = db.Get(ub, []byte("sebastian"))
+	assert.FatalError(t, err)
+	assert.Equals(t, sebb, res)
+
+	// check that the readMike update txn was successful
+	assert.Equals(t, readMike.Result, mikeb)
+
+	// check that the readSeb update txn was successful
+	assert.Equals(t, readSeb.Result, sebb)
+
+	// check that the casGates update txn was a successful write
+	assert.True(t, casGates.Swapped)
+	assert.Equals(t, casGates.Result, gatesb)
+
+	// check that the casGates2 update txn was successful
+	assert.True(t, casGates2.Swapped)
+	assert.Equals(t, casGates2.Result, boogers)
+
+	// check that the casGates3 update txn was did not update.
+	assert.False(t, casGates3.Swapped)
+	assert.Equals(t, casGates3.Result, boogers)
+
+	// List //
+
+	_, err = db.List([]byte("clever"))
+	assert.True(t, database.IsErrNotFound(err))
+
+	entries, err = db.List(ub)
+	assert.FatalError(t, err)
+	assert.Equals(t, len(entries), 4)
+
+	// Update Again //
+
+	// create txns for update test
+	max := testUser{"max", "furman", 6}
+	maxb, err := json.Marshal(max)
+	assert.FatalError(t, err)
+	maxey := testUser{"mike", "maxey", 3}
+	maxeyb, err := json.Marshal(maxey)
+	assert.FatalError(t, err)
+	delMike := &database.TxEntry{
+		Bucket: ub,
+		Key:    []byte("mike"),
+		Cmd:    database.Delete,
+	}
+	setMax := &database.TxEntry{
+		Bucket: ub,
+		Key:    []byte("max"),
+		Value:  maxb,
+		Cmd:    database.Set,
+	}
+	setMaxey := &database.TxEntry{
+		Bucket: ub,
+		Key:    []byte("maxey"),
+		Value:  maxeyb,
+		Cmd:    database.Set,
+	}
+	delMaxey := &database.TxEntry{
+		Bucket: ub,
+		Key:    []byte("maxey"),
+		Cmd:    database.Delete,
+	}
+	delSeb := &database.TxEntry{
+		Bucket: ub,
+		Key:    []byte("sebastian"),
+		Cmd:    database.Delete,
+	}
+
+	// update: read write multiple entries.
+	tx = &database.Tx{Operations: []*database.TxEntry{
+		delMike, setMax, setMaxey, delMaxey, delSeb,
+	}}
+	assert.Nil(t, db.Update(tx))
+
+	entries, err = db.List(ub)
+	assert.FatalError(t, err)
+	assert.Equals(t, len(entries), 3)
+
+	// verify that max and mariano are in the db
+	res, err = db.Get(ub, []byte("max"))
+	assert.FatalError(t, err)
+	assert.Equals(t, maxb, res)
+	res, err = db.Get(ub, []byte("mariano"))
+	assert.FatalError(t, err)
+	assert.Equals(t, marianob, res)
+
+	assert.Nil(t, db.DeleteTable(ub))
+	_, err = db.List(ub)
+	assert.True(t, database.IsErrNotFound(err))
+}
+
+func testTableLifecycle(t *testing.T, db database.DB) {
+	bucket := []byte("nosqltest-lifecycle")
+	_ = db.DeleteTable(bucket)
+
+	// Missing tables.
+	assert.True(t, database.IsErrNotFound(db.DeleteTable(bucket)))
+	_, err := db.List(bucket)
+	assert.True(t, database.IsErrNotFound(err))
+
+	// Create is idempotent and does not remove the data.
+	assert.FatalError(t, db.CreateTable(bucket))
+	assert.FatalError(t, db.Set(bucket, []byte("key"), []byte("value")))
+	assert.FatalError(t, db.CreateTable(bucket))
+	v, err := db.Get(bucket, []byte("key"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("value"), v)
+
+	// Delete removes the data.
+	assert.FatalError(t, db.DeleteTable(bucket))
+	_, err = db.List(bucket)
+	assert.True(t, database.IsErrNotFound(err))
+	assert.True(t, database.IsErrNotFound(db.DeleteTable(bucket)))
+
+	// A re-created table is empty.
+	assert.FatalError(t, db.CreateTable(bucket))
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, entries)
+	_, err = db.Get(bucket, []byte("key"))
+	assert.True(t, database.IsErrNotFound(err))
+	assert.FatalError(t, db.DeleteTable(bucket))
+
+	// Tables in a transaction.
+	tx := new(database.Tx)
+	tx.CreateTable(bucket)
+	tx.Set(bucket, []byte("key"), []byte("value"))
+	assert.FatalError(t, db.Update(tx))
+	v, err = db.Get(bucket, []byte("key"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("value"), v)
+
+	tx = new(database.Tx)
+	tx.DeleteTable(bucket)
+	assert.FatalError(t, db.Update(tx))
+	_, err = db.List(bucket)
+	assert.True(t, database.IsErrNotFound(err))
+}
+
+func testListTables(t *testing.T, db database.DB) {
+	a := newTable(t, db, "nosqltest-tables-a")
+	b := newTable(t, db, "nosqltest-tables-b")
+	missing := []byte("nosqltest-tables-missing")
+	_ = db.DeleteTable(missing)
+	assert.FatalError(t, db.Set(b, []byte("key"), []byte("value")))
+
+	// The database might have other tables.
+	contains := func(tables [][]byte, bucket []byte) bool {
+		for _, table := range tables {
+			if bytes.Equal(table, bucket) {
+				return true
+			}
+		}
+		return false
+	}
+
+	tables, err := database.ListTables(db)
+	assert.FatalError(t, err)
+	assert.True(t, contains(tables, a), "ListTables does not contain %s", a)
+	assert.True(t, contains(tables, b), "ListTables does not contain %s", b)
+	assert.False(t, contains(tables, missing), "ListTables contains %s", missing)
+	assert.True(t, sort.SliceIsSorted(tables, func(i, j int) bool {
+		return bytes.Compare(tables[i], tables[j]) < 0
+	}), "ListTables is not sorted")
+
+	for _, bucket := range [][]byte{a, b} {
+		exists, err := database.TableExists(db, bucket)
+		assert.FatalError(t, err)
+		assert.True(t, exists, "table %s does not exist", bucket)
+	}
+	exists, err := database.TableExists(db, missing)
+	assert.FatalError(t, err)
+	assert.False(t, exists, "table %s exists", missing)
+
+	assert.FatalError(t, db.DeleteTable(b))
+	exists, err = database.TableExists(db, b)
+	assert.FatalError(t, err)
+	assert.False(t, exists, "table %s exists after DeleteTable", b)
+	tables, err = database.ListTables(db)
+	assert.FatalError(t, err)
+	assert.True(t, contains(tables, a), "ListTables does not contain %s", a)
+	assert.False(t, contains(tables, b), "ListTables contains %s after DeleteTable", b)
+}
+
+func testNestedBuckets(t *testing.T, db database.DB) {
+	root := []byte("nosqltest-nested")
+	moved := []byte("nosqltest-moved")
+	for _, bucket := range [][]byte{root, moved} {
+		_ = db.DeleteTable(bucket)
+		t.Cleanup(func() {
+			_ = db.DeleteTable(bucket)
+		})
+	}
+	join := func(names ...string) []byte {
+		return []byte(strings.Join(names, database.BucketSeparator))
+	}
+	child, grandchild := join(string(root), "a"), join(string(root), "a", "b")
+	key := []byte("key")
+
+	assertExists := func(t *testing.T, bucket []byte, want bool) {
+		t.Helper()
+		exists, err := database.TableExists(db, bucket)
+		assert.FatalError(t, err)
+		assert.Equals(t, want, exists, "TableExists(%s)", bucket)
+	}
+	assertValue := func(t *testing.T, bucket []byte, want string) {
+		t.Helper()
+		v, err := db.Get(bucket, key)
+		assert.FatalError(t, err, "Get(%s)", bucket)
+		assert.Equals(t, []byte(want), v)
+	}
+
+	// Parents are created implicitly, and nested buckets do not share keys.
+	assert.FatalError(t, db.CreateTable(grandchild))
+	assertExists(t, root, true)
+	assertExists(t, child, true)
+	for _, bucket := range [][]byte{root, child, grandchild} {
+		assert.FatalError(t, db.Set(bucket, key, bucket))
+	}
+	for _, bucket := range [][]byte{root, child, grandchild} {
+		assertValue(t, bucket, string(bucket))
+		entries, err := db.List(bucket)
+		assert.FatalError(t, err)
+		assert.Len(t, 1, entries)
+	}
+
+	children, err := database.ListChildren(db, root)
+	assert.FatalError(t, err)
+	assert.Equals(t, [][]byte{child}, children)
+	children, err = database.ListChildren(db, child)
+	assert.FatalError(t, err)
+	assert.Equals(t, [][]byte{grandchild}, children)
+	children, err = database.ListChildren(db, grandchild)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, children)
+	_, err = database.ListChildren(db, moved)
+	assert.True(t, database.IsErrBucketNotFound(err))
+
+	// Renames move the subtree.
+	assert.True(t, database.IsErrBucketNotFound(database.RenameTable(db, moved, root)))
+	assert.True(t, database.IsErrBucketExists(database.RenameTable(db, grandchild, root)))
+	assert.True(t, database.IsErrInvalidKey(database.RenameTable(db, child, join(string(child), "c"))))
+	assert.True(t, database.IsErrInvalidKey(database.RenameTable(db, child, child)))
+	assert.FatalError(t, database.RenameTable(db, child, join(string(moved), "x")))
+	assertExists(t, root, true)
+	assertExists(t, child, false)
+	assertExists(t, grandchild, false)
+	assertExists(t, moved, true)
+	assertValue(t, root, string(root))
+	assertValue(t, join(string(moved), "x"), string(child))
+	assertValue(t, join(string(moved), "x", "b"), string(grandchild))
+	children, err = database.ListChildren(db, root)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, children)
+
+	// A re-created bucket does not have the old data.
+	assert.FatalError(t, db.CreateTable(grandchild))
+	_, err = db.Get(child, key)
+	assert.True(t, database.IsErrNotFound(err))
+	assert.False(t, database.IsErrBucketNotFound(err))
+
+	// Deletes are recursive, also in transactions.
+	assert.FatalError(t, db.DeleteTable(moved))
+	assertExists(t, moved, false)
+	assertExists(t, join(string(moved), "x"), false)
+	assertExists(t, join(string(moved), "x", "b"), false)
+
+	tx := new(database.Tx)
+	tx.DeleteTable(child)
+	assert.FatalError(t, db.Update(tx))
+	assertExists(t, root, true)
+	assertExists(t, child, false)
+	assertExists(t, grandchild, false)
+	tables, err := database.ListTables(db)
+	assert.FatalError(t, err)
+	for _, table := range tables {
+		assert.False(t, database.IsNestedBucket(table, root), "bucket %s was not deleted", table)
+	}
+
+	// Empty names and reserved names are not valid.
+	for _, bucket := range [][]byte{join(string(root), ""), join(string(root), "", "b"), []byte(database.SequencesTable)} {
+		assert.True(t, database.IsErrInvalidKey(db.CreateTable(bucket)), "CreateTable(%s)", bucket)
+	}
+	assert.True(t, database.IsErrInvalidKey(database.RenameTable(db, root, []byte(database.SequencesTable))))
+}
+
+func testCopyTable(t *testing.T, db database.DB) {
+	src := newTable(t, db, "nosqltest-copy-src")
+	nested := []byte("nosqltest-copy-src/nested")
+	dst := []byte("nosqltest-copy-dst/copy")
+	_ = db.DeleteTable([]byte("nosqltest-copy-dst"))
+	t.Cleanup(func() {
+		_ = db.DeleteTable([]byte("nosqltest-copy-dst"))
+	})
+
+	assert.FatalError(t, db.CreateTable(nested))
+	for i := 0; i < 10; i++ {
+		key, value := []byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))
+		assert.FatalError(t, db.Set(src, key, value))
+		assert.FatalError(t, db.Set(nested, key, append([]byte("nested-"), value...)))
+	}
+	wantSrc, err := db.List(src)
+	assert.FatalError(t, err)
+	wantNested, err := db.List(nested)
+	assert.FatalError(t, err)
+
+	assert.True(t, database.IsErrBucketNotFound(database.CopyTable(db, []byte("nosqltest-copy-missing"), dst)))
+	assert.True(t, database.IsErrInvalidKey(database.CopyTable(db, src, src)))
+	assert.True(t, database.IsErrInvalidKey(database.CopyTable(db, src, []byte("nosqltest-copy-src/copy"))))
+	assert.True(t, database.IsErrBucketExists(database.CopyTable(db, nested, src)))
+
+	assert.FatalError(t, database.CopyTable(db, src, dst))
+	assertEntries := func(bucket []byte, want []*database.Entry) {
+		t.Helper()
+		entries, err := db.List(bucket)
+		assert.FatalError(t, err)
+		assert.Len(t, len(want), entries)
+		for i, e := range sortEntries(entries) {
+			assert.Equals(t, want[i].Key, e.Key)
+			assert.Equals(t, want[i].Value, e.Value)
+			assert.Equals(t, bucket, e.Bucket)
+		}
+	}
+	assertEntries(src, sortEntries(wantSrc))
+	assertEntries(nested, sortEntries(wantNested))
+	assertEntries(dst, sortEntries(wantSrc))
+	assertEntries([]byte("nosqltest-copy-dst/copy/nested"), sortEntries(wantNested))
+
+	// The copy is independent of the source.
+	assert.FatalError(t, db.Set(dst, []byte("key-0"), []byte("changed")))
+	v, err := db.Get(src, []byte("key-0"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("value-0"), v)
+	assert.True(t, database.IsErrBucketExists(database.CopyTable(db, src, dst)))
+}
+
+func testGetSetDel(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-getsetdel")
+	key := []byte("key")
+
+	_, err := db.Get(bucket, key)
+	assert.True(t, database.IsErrNotFound(err))
+
+	assert.FatalError(t, db.Set(bucket, key, []byte("value")))
+	v, err := db.Get(bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("value"), v)
+
+	// Values returned must not be affected by later writes.
+	assert.FatalError(t, db.Set(bucket, key, []byte("other")))
+	assert.Equals(t, []byte("value"), v)
+	v, err = db.Get(bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("other"), v)
+
+	// Empty values are values.
+	assert.FatalError(t, db.Set(bucket, key, []byte{}))
+	v, err = db.Get(bucket, key)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, v)
+
+	// Keys are independent.
+	assert.FatalError(t, db.Set(bucket, []byte("key2"), []byte("value2")))
+	assert.FatalError(t, db.Del(bucket, key))
+	_, err = db.Get(bucket, key)
+	assert.True(t, database.IsErrNotFound(err))
+	v, err = db.Get(bucket, []byte("key2"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("value2"), v)
+
+	// Deleting a missing key is not an error.
+	assert.FatalError(t, db.Del(bucket, key))
+
+	// Tables are independent.
+	other := newTable(t, db, "nosqltest-getsetdel-other")
+	_, err = db.Get(other, []byte("key2"))
+	assert.True(t, database.IsErrNotFound(err))
+}
+
+func testGetMulti(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-getmulti")
+	other := newTable(t, db, "nosqltest-getmulti-other")
+	missing := []byte("nosqltest-getmulti-missing")
+	_ = db.DeleteTable(missing)
+
+	assert.FatalError(t, db.Set(bucket, []byte("a"), []byte("value-a")))
+	assert.FatalError(t, db.Set(bucket, []byte("b"), []byte{}))
+	assert.FatalError(t, db.Set(bucket, []byte{0, 0xff}, []byte{1, 2}))
+	assert.FatalError(t, db.Set(other, []byte("a"), []byte("other-a")))
+
+	keys := [][]byte{[]byte("a"), []byte("missing"), []byte("b"), {0, 0xff}, []byte("a")}
+	values, err := database.GetMulti(db, bucket, keys)
+	assert.FatalError(t, err)
+	assert.Len(t, 3, values)
+	assert.Equals(t, []byte("value-a"), values["a"])
+	assert.Len(t, 0, values["b"])
+	assert.Equals(t, []byte{1, 2}, values[string([]byte{0, 0xff})])
+	assert.Equals(t, [][]byte{[]byte("missing")}, database.MissingKeys(keys, values))
+
+	values, err = database.GetMulti(db, bucket, nil)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, values)
+
+	multi, err := database.GetMultiBuckets(db, map[string][][]byte{
+		string(bucket): {[]byte("a"), []byte("c")},
+		string(other):  {[]byte("a"), []byte("b")},
+	})
+	assert.FatalError(t, err)
+	assert.Len(t, 2, multi)
+	assert.Equals(t, map[string][]byte{"a": []byte("value-a")}, multi[string(bucket)])
+	assert.Equals(t, map[string][]byte{"a": []byte("other-a")}, multi[string(other)])
+
+	multi, err = database.GetMultiBuckets(db, map[string][][]byte{string(other): nil})
+	assert.FatalError(t, err)
+	assert.Len(t, 1, multi)
+	assert.Len(t, 0, multi[string(other)])
+
+	// Reads never create buckets.
+	_, err = database.GetMulti(db, missing, keys)
+	assert.True(t, database.IsErrBucketNotFound(err))
+	_, err = database.GetMultiBuckets(db, map[string][][]byte{
+		string(bucket):  {[]byte("a")},
+		string(missing): {[]byte("a")},
+	})
+	assert.True(t, database.IsErrBucketNotFound(err))
+	exists, err := database.TableExists(db, missing)
+	assert.FatalError(t, err)
+	assert.False(t, exists)
+}
+
+func testBulkLoad(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-bulkload")
+	assert.FatalError(t, db.Set(bucket, []byte("key-0001"), []byte("old")))
+	assert.FatalError(t, db.Set(bucket, []byte("other"), []byte("other")))
+
+	// The iterator reuses its buffers, and returns the keys in reverse order.
+	const n = 2500
+	var (
+		i          int
+		key, value []byte
+	)
+	iter := database.IteratorFunc(func() ([]byte, []byte, error) {
+		if i >= n {
+			return nil, nil, io.EOF
+		}
+		i++
+		key = fmt.Appendf(key[:0], "key-%04d", n-i)
+		value = fmt.Appendf(value[:0], "value-%d", n-i)
+		return key, value, nil
+	})
+	var progress []int
+	assert.FatalError(t, database.BulkLoad(db, bucket, iter, database.WithBatchSize(1000), database.WithProgress(func(loaded int) {
+		progress = append(progress, loaded)
+	})))
+	assert.Equals(t, []int{1000, 2000, 2500}, progress)
+
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, n+1, entries)
+	for j, e := range sortEntries(entries)[:n] {
+		assert.Equals(t, fmt.Sprintf("key-%04d", j), string(e.Key))
+		assert.Equals(t, fmt.Sprintf("value-%d", j), string(e.Value))
+	}
+
+	// The last value of a duplicated key wins, and empty iterators are valid.
+	assert.FatalError(t, database.BulkLoad(db, bucket, database.EntriesIterator([]*database.Entry{
+		{Key: []byte("dup"), Value: []byte("1")},
+		{Key: []byte("dup"), Value: []byte("2")},
+	})))
+	v, err := db.Get(bucket, []byte("dup"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("2"), v)
+	progress = nil
+	assert.FatalError(t, database.BulkLoad(db, bucket, database.EntriesIterator(nil), database.WithProgress(func(loaded int) {
+		progress = append(progress, loaded)
+	})))
+	assert.Len(t, 0, progress)
+
+	// The batches written before an error are kept.
+	other := newTable(t, db, "nosqltest-bulkload-other")
+	errIter := errors.New("iterator failed")
+	i = 0
+	iter = database.IteratorFunc(func() ([]byte, []byte, error) {
+		if i >= 3 {
+			return nil, nil, errIter
+		}
+		i++
+		return []byte(fmt.Sprintf("key-%d", i)), []byte("value"), nil
+	})
+	err = database.BulkLoad(db, other, iter, database.WithBatchSize(2))
+	assert.True(t, errors.Is(err, errIter))
+	entries, err = db.List(other)
+	assert.FatalError(t, err)
+	assert.Len(t, 2, entries)
+}
+
+func testDeleteRange(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-deleterange")
+	nested := []byte("nosqltest-deleterange/nested")
+	assert.FatalError(t, db.CreateTable(nested))
+	assert.FatalError(t, db.Set(nested, []byte("a"), []byte("nested")))
+
+	set := func(keys ...string) {
+		t.Helper()
+		for _, k := range keys {
+			assert.FatalError(t, db.Set(bucket, []byte(k), []byte("value-"+k)))
+		}
+	}
+	assertKeys := func(bucket []byte, want ...string) {
+		t.Helper()
+		entries, err := db.List(bucket)
+		assert.FatalError(t, err)
+		keys := []string{}
+		for _, e := range sortEntries(entries) {
+			keys = append(keys, string(e.Key))
+		}
+		assert.Equals(t, append([]string{}, want...), keys)
+	}
+
+	set("a", "b", "c", "d", "e")
+	assert.FatalError(t, database.DeleteKeyRange(db, bucket, []byte("b"), []byte("d")))
+	assertKeys(bucket, "a", "d", "e")
+	assert.FatalError(t, database.DeleteKeyRange(db, bucket, nil, []byte("b")))
+	assertKeys(bucket, "d", "e")
+	assert.FatalError(t, database.DeleteKeyRange(db, bucket, []byte("e"), nil))
+	assertKeys(bucket, "d")
+
+	// An empty range does not delete anything.
+	assert.FatalError(t, database.DeleteKeyRange(db, bucket, []byte("x"), []byte("a")))
+	assertKeys(bucket, "d")
+
+	set("pre", "pre-1", "pre-2", "prf", "p")
+	assert.FatalError(t, database.DeleteKeyPrefix(db, bucket, []byte("pre")))
+	assertKeys(bucket, "d", "p", "prf")
+	assert.FatalError(t, database.DeleteKeyPrefix(db, bucket, []byte{0xff}))
+	assertKeys(bucket, "d", "p", "prf")
+
+	// Truncate keeps the bucket and the buckets nested in it.
+	assert.FatalError(t, database.TruncateTable(db, bucket))
+	assertKeys(bucket)
+	assertKeys(nested, "a")
+	exists, err := database.TableExists(db, bucket)
+	assert.FatalError(t, err)
+	assert.True(t, exists)
+
+	// The commands are part of the transaction.
+	set("a", "b", "c", "key-1", "key-2")
+	tx := new(database.Tx)
+	tx.DeleteRange(bucket, []byte("a"), []byte("c"))
+	tx.DeletePrefix(bucket, []byte("key-"))
+	tx.Set(bucket, []byte("z"), []byte("value-z"))
+	assert.FatalError(t, db.Update(tx))
+	assertKeys(bucket, "c", "z")
+
+	tx = new(database.Tx)
+	tx.Truncate(bucket)
+	tx.Set(bucket, []byte("y"), []byte("value-y"))
+	assert.FatalError(t, db.Update(tx))
+	assertKeys(bucket, "y")
+
+	tx = new(database.Tx)
+	tx.Truncate(bucket)
+	tx.Cmp(bucket, []byte("y"), []byte("value-y"))
+	assert.Error(t, db.Update(tx))
+	assertKeys(bucket, "y")
+	assertKeys(nested, "a")
+}
+
+func testCmpAndSwap(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-cmpandswap")
+	key := []byte("key")
+
+	// A nil old value matches a missing key.
+	v, swapped, err := db.CmpAndSwap(bucket, key, nil, []byte("one"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("one"), v)
+
+	// A nil old value does not match an existing key.
+	v, swapped, err = db.CmpAndSwap(bucket, key, nil, []byte("two"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.Equals(t, []byte("one"), v)
+
+	// The current value is returned on mismatches.
+	v, swapped, err = db.CmpAndSwap(bucket, key, []byte("zero"), []byte("two"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.Equals(t, []byte("one"), v)
+
+	// The new value is returned on matches.
+	v, swapped, err = db.CmpAndSwap(bucket, key, []byte("one"), []byte("two"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("two"), v)
+	v, err = db.Get(bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("two"), v)
+
+	// An empty old value is equivalent to a nil one, and matches a missing
+	// key.
+	_, swapped, err = db.CmpAndSwap(bucket, []byte("empty"), []byte{}, []byte("one"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+
+	// A nil old value matches an empty value.
+	assert.FatalError(t, db.Set(bucket, []byte("empty"), []byte{}))
+	v, swapped, err = db.CmpAndSwap(bucket, []byte("empty"), nil, []byte("one"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("one"), v)
+
+	// A value can be swapped to an empty value.
+	_, swapped, err = db.CmpAndSwap(bucket, []byte("empty"), []byte("one"), []byte{})
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	v, err = db.Get(bucket, []byte("empty"))
+	assert.FatalError(t, err)
+	assert.Len(t, 0, v)
+}
+
+func testVersions(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-versions")
+	key := []byte("key")
+
+	_, _, err := database.GetVersioned(db, bucket, key)
+	assert.True(t, database.IsErrNotFound(err))
+
+	// Version 0 creates a missing key, and only a missing key.
+	assert.FatalError(t, database.SetIfVersion(db, bucket, key, []byte("one"), 0))
+	assert.True(t, database.IsErrVersionMismatch(database.SetIfVersion(db, bucket, key, []byte("two"), 0)))
+	v, v1, err := database.GetVersioned(db, bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("one"), v)
+	assert.NotEquals(t, uint64(0), v1)
+
+	// Reads do not change the version.
+	_, version, err := database.GetVersioned(db, bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, v1, version)
+
+	// The current version is required to write.
+	assert.FatalError(t, database.SetIfVersion(db, bucket, key, []byte("two"), v1))
+	v, v2, err := database.GetVersioned(db, bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("two"), v)
+	assert.NotEquals(t, v1, v2)
+	assert.True(t, database.IsErrVersionMismatch(database.SetIfVersion(db, bucket, key, []byte("three"), v1)))
+	v, err = db.Get(bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("two"), v)
+
+	// Every write changes the version.
+	seen := map[uint64]bool{v1: true, v2: true}
+	assertNewVersion := func(t *testing.T, expected []byte) uint64 {
+		t.Helper()
+		v, version, err := database.GetVersioned(db, bucket, key)
+		assert.FatalError(t, err)
+		assert.Equals(t, expected, v)
+		assert.False(t, seen[version], "version %d was used before", version)
+		seen[version] = true
+		return version
+	}
+	assert.FatalError(t, db.Set(bucket, key, []byte("three")))
+	assertNewVersion(t, []byte("three"))
+	_, swapped, err := db.CmpAndSwap(bucket, key, []byte("three"), []byte("four"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assertNewVersion(t, []byte("four"))
+	tx := new(database.Tx)
+	tx.Set(bucket, key, []byte("five"))
+	assert.FatalError(t, db.Update(tx))
+	version = assertNewVersion(t, []byte("five"))
+	assert.True(t, database.IsErrVersionMismatch(database.SetIfVersion(db, bucket, key, []byte("six"), v2)))
+
+	// A deleted key does not reuse its versions.
+	assert.FatalError(t, db.Del(bucket, key))
+	_, _, err = database.GetVersioned(db, bucket, key)
+	assert.True(t, database.IsErrNotFound(err))
+	assert.True(t, database.IsErrVersionMismatch(database.SetIfVersion(db, bucket, key, []byte("six"), version)))
+	assert.FatalError(t, database.SetIfVersion(db, bucket, key, []byte("six"), 0))
+	assertNewVersion(t, []byte("six"))
+
+	// Empty values are versioned.
+	assert.FatalError(t, database.SetIfVersion(db, bucket, []byte("empty"), []byte{}, 0))
+	v, _, err = database.GetVersioned(db, bucket, []byte("empty"))
+	assert.FatalError(t, err)
+	assert.Len(t, 0, v)
+
+	// The versions are not listed as entries.
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, 2, entries)
+}
+
+func testIncr(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-incr")
+	key := []byte("counter")
+
+	// A missing key is a counter with value 0.
+	for _, tc := range []struct {
+		delta, want int64
+	}{
+		{5, 5}, {-12, -7}, {0, -7}, {1 << 40, 1<<40 - 7},
+	} {
+		n, err := database.IncrCounter(db, bucket, key, tc.delta)
+		assert.FatalError(t, err)
+		assert.Equals(t, tc.want, n)
+	}
+
+	// Counters are stored as base 10 integers.
+	v, err := db.Get(bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, database.FormatCounter(1<<40-7), v)
+	assert.FatalError(t, db.Set(bucket, key, database.FormatCounter(100)))
+	n, err := database.IncrCounter(db, bucket, key, 1)
+	assert.FatalError(t, err)
+	assert.Equals(t, int64(101), n)
+
+	// The values that are not counters, and the overflows, fail without
+	// changing the value.
+	other := []byte("not-a-counter")
+	assert.FatalError(t, db.Set(bucket, other, []byte("one")))
+	_, err = database.IncrCounter(db, bucket, other, 1)
+	assert.Error(t, err)
+	v, err = db.Get(bucket, other)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("one"), v)
+	_, err = database.IncrCounter(db, bucket, key, math.MaxInt64)
+	assert.Error(t, err)
+	v, err = db.Get(bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("101"), v)
+
+	// Increments are writes.
+	_, v1, err := database.GetVersioned(db, bucket, key)
+	assert.FatalError(t, err)
+	_, err = database.IncrCounter(db, bucket, key, 0)
+	assert.FatalError(t, err)
+	_, v2, err := database.GetVersioned(db, bucket, key)
+	assert.FatalError(t, err)
+	assert.NotEquals(t, v1, v2)
+
+	// Increments on a transaction are rolled back with it.
+	tx := new(database.Tx)
+	tx.Incr(bucket, key, 10)
+	tx.Incr(bucket, []byte("other"), -1)
+	assert.FatalError(t, db.Update(tx))
+	assert.Equals(t, int64(111), tx.Operations[0].Counter)
+	assert.Equals(t, int64(-1), tx.Operations[1].Counter)
+	tx = new(database.Tx)
+	tx.Incr(bucket, key, 10)
+	tx.Get(bucket, []byte("missing"))
+	assert.True(t, database.IsErrNotFound(db.Update(tx)))
+	v, err = db.Get(bucket, key)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("111"), v)
+}
+
+func testNextSequence(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-sequence")
+	nested := []byte("nosqltest-sequence/nested")
+	assert.FatalError(t, db.CreateTable(nested))
+
+	// Sequences start at 1, and the nested buckets have their own.
+	next := func(t *testing.T, bucket []byte) uint64 {
+		t.Helper()
+		seq, err := database.NextTableSequence(db, bucket)
+		assert.FatalError(t, err)
+		return seq
+	}
+	assert.Equals(t, uint64(1), next(t, bucket))
+	last := next(t, bucket)
+	assert.True(t, last > 1)
+	assert.Equals(t, uint64(1), next(t, nested))
+
+	// Sequences are not entries and survive Truncate.
+	assert.FatalError(t, db.Set(bucket, []byte("key"), []byte("value")))
+	n, err := database.Count(db, bucket)
+	assert.FatalError(t, err)
+	assert.Equals(t, 1, n)
+	assert.FatalError(t, database.TruncateTable(db, bucket))
+	seq := next(t, bucket)
+	assert.True(t, seq > last, "sequence %d is not greater than %d", seq, last)
+	last = seq
+
+	// Transactions get the next numbers.
+	tx := new(database.Tx)
+	tx.NextSequence(bucket)
+	tx.NextSequence(bucket)
+	assert.FatalError(t, db.Update(tx))
+	assert.True(t, tx.Operations[0].Sequence > last)
+	assert.True(t, tx.Operations[1].Sequence > tx.Operations[0].Sequence)
+	last = tx.Operations[1].Sequence
+
+	// Renamed and copied buckets continue the sequence.
+	renamed := []byte("nosqltest-sequence-renamed")
+	copied := []byte("nosqltest-sequence-copied")
+	_ = db.DeleteTable(renamed)
+	_ = db.DeleteTable(copied)
+	t.Cleanup(func() {
+		_ = db.DeleteTable(renamed)
+		_ = db.DeleteTable(copied)
+	})
+	assert.FatalError(t, database.RenameTable(db, bucket, renamed))
+	seq = next(t, renamed)
+	assert.True(t, seq > last, "sequence %d is not greater than %d", seq, last)
+	last = seq
+	assert.FatalError(t, database.CopyTable(db, renamed, copied))
+	seq = next(t, copied)
+	assert.True(t, seq > last, "sequence %d is not greater than %d", seq, last)
+	seq = next(t, []byte("nosqltest-sequence-renamed/nested"))
+	assert.True(t, seq > 1, "sequence %d is not greater than 1", seq)
+
+	// Deleted buckets lose their sequence.
+	assert.FatalError(t, db.CreateTable(bucket))
+	assert.Equals(t, uint64(1), next(t, bucket))
+	assert.FatalError(t, db.DeleteTable(renamed))
+	assert.FatalError(t, db.CreateTable(renamed))
+	assert.Equals(t, uint64(1), next(t, renamed))
+	exists, err := database.TableExists(db, []byte("nosqltest-sequence-renamed/nested"))
+	assert.FatalError(t, err)
+	assert.False(t, exists)
+
+	// Sequences are not listed as tables.
+	tables, err := database.ListTables(db)
+	assert.FatalError(t, err)
+	var listed []string
+	for _, table := range tables {
+		if bytes.HasPrefix(table, bucket) {
+			listed = append(listed, string(table))
+		}
+	}
+	assert.Equals(t, []string{
+		"nosqltest-sequence", "nosqltest-sequence-copied", "nosqltest-sequence-copied/nested", "nosqltest-sequence-renamed",
+	}, listed)
+}
+
+func testUpdate(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-update")
+	assert.FatalError(t, db.Set(bucket, []byte("a"), []byte("1")))
+	assert.FatalError(t, db.Set(bucket, []byte("b"), []byte("2")))
+
+	// Operations are applied in order, and see the previous ones.
+	tx := new(database.Tx)
+	tx.Get(bucket, []byte("a"))
+	tx.Set(bucket, []byte("a"), []byte("10"))
+	tx.Get(bucket, []byte("a"))
+	tx.Del(bucket, []byte("b"))
+	tx.Set(bucket, []byte("c"), []byte("3"))
+	tx.Operations = append(tx.Operations, &database.TxEntry{
+		Bucket: bucket, Key: []byte("c"), CmpValue: []byte("3"), Value: []byte("30"), Cmd: database.CmpAndSwap,
+	}, &database.TxEntry{
+		Bucket: bucket, Key: []byte("d"), CmpValue: []byte("4"), Value: []byte("40"), Cmd: database.CmpAndSwap,
+	})
+	assert.FatalError(t, db.Update(tx))
+
+	ops := tx.Operations
+	assert.Equals(t, []byte("1"), ops[0].Result)
+	assert.Equals(t, []byte("10"), ops[2].Result)
+	assert.True(t, ops[5].Swapped)
+	assert.Equals(t, []byte("30"), ops[5].Result)
+	assert.False(t, ops[6].Swapped)
+	assert.Len(t, 0, ops[6].Result)
+
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	entries = sortEntries(entries)
+	assert.Len(t, 2, entries)
+	assert.Equals(t, []byte("a"), entries[0].Key)
+	assert.Equals(t, []byte("10"), entries[0].Value)
+	assert.Equals(t, []byte("c"), entries[1].Key)
+	assert.Equals(t, []byte("30"), entries[1].Value)
+
+	// Empty transactions are valid.
+	assert.FatalError(t, db.Update(new(database.Tx)))
+}
+
+func testView(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-view")
+	other := newTable(t, db, "nosqltest-view-other")
+	missing := []byte("nosqltest-view-missing")
+	_ = db.DeleteTable(missing)
+	assert.FatalError(t, db.Set(bucket, []byte("a"), []byte("1")))
+	assert.FatalError(t, db.Set(bucket, []byte("b"), []byte{}))
+	assert.FatalError(t, db.Set(other, []byte("a"), []byte("other")))
+
+	tx := new(database.Tx)
+	tx.Get(bucket, []byte("a"))
+	tx.Get(other, []byte("a"))
+	tx.Get(bucket, []byte("b"))
+	assert.FatalError(t, database.View(db, tx))
+	assert.Equals(t, []byte("1"), tx.Operations[0].Result)
+	assert.Equals(t, []byte("other"), tx.Operations[1].Result)
+	assert.Len(t, 0, tx.Operations[2].Result)
+
+	tx = new(database.Tx)
+	tx.Get(bucket, []byte("a"))
+	tx.Get(bucket, []byte("missing"))
+	err := database.View(db, tx)
+	assert.True(t, database.IsErrNotFound(err))
+	assert.False(t, database.IsErrBucketNotFound(err))
+
+	// Reads never create buckets.
+	tx = new(database.Tx)
+	tx.Get(missing, []byte("a"))
+	assert.True(t, database.IsErrBucketNotFound(database.View(db, tx)))
+	exists, err := database.TableExists(db, missing)
+	assert.FatalError(t, err)
+	assert.False(t, exists)
+
+	// Write commands are rejected, and nothing is written.
+	writes := []func(tx *database.Tx){
+		func(tx *database.Tx) { tx.Set(bucket, []byte("a"), []byte("10")) },
+		func(tx *database.Tx) { tx.Del(bucket, []byte("a")) },
+		func(tx *database.Tx) { tx.Cas(bucket, []byte("a"), []byte("10")) },
+		func(tx *database.Tx) { tx.Truncate(bucket) },
+		func(tx *database.Tx) { tx.Incr(bucket, []byte("a"), 1) },
+		func(tx *database.Tx) { tx.NextSequence(bucket) },
+		func(tx *database.Tx) { tx.CreateTable(missing) },
+		func(tx *database.Tx) { tx.DeleteTable(other) },
+	}
+	for _, write := range writes {
+		tx := new(database.Tx)
+		tx.Get(bucket, []byte("a"))
+		write(tx)
+		assert.True(t, database.IsErrReadOnly(database.View(db, tx)))
+	}
+	v, err := db.Get(bucket, []byte("a"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("1"), v)
+	exists, err = database.TableExists(db, other)
+	assert.FatalError(t, err)
+	assert.True(t, exists)
+	exists, err = database.TableExists(db, missing)
+	assert.FatalError(t, err)
+	assert.False(t, exists)
+
+	// Empty transactions are valid.
+	assert.FatalError(t, database.View(db, new(database.Tx)))
+}
+
+func testUpdateRollback(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-rollback")
+	assert.FatalError(t, db.Set(bucket, []byte("a"), []byte("1")))
+
+	// A failing read rolls back the whole transaction.
+	tx := new(database.Tx)
+	tx.Set(bucket, []byte("a"), []byte("10"))
+	tx.Del(bucket, []byte("a"))
+	tx.Set(bucket, []byte("b"), []byte("2"))
+	tx.Get(bucket, []byte("missing"))
+	tx.Set(bucket, []byte("c"), []byte("3"))
+	err := db.Update(tx)
+	assert.True(t, database.IsErrNotFound(err))
+
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, 1, entries)
+	assert.Equals(t, []byte("a"), entries[0].Key)
+	assert.Equals(t, []byte("1"), entries[0].Value)
+
+	// Unsupported operations fail and roll back the transaction.
+	tx = new(database.Tx)
+	tx.Set(bucket, []byte("a"), []byte("10"))
+	tx.Cmp(bucket, []byte("a"), []byte("1"))
+	assert.Error(t, db.Update(tx))
+	v, err := db.Get(bucket, []byte("a"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("1"), v)
+}
+
+func testList(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-list")
+	other := newTable(t, db, "nosqltest-list-other")
+
+	want := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		k, v := fmt.Sprintf("key-%03d", i), fmt.Sprintf("value-%d", i)
+		want[k] = v
+		assert.FatalError(t, db.Set(bucket, []byte(k), []byte(v)))
+	}
+	assert.FatalError(t, db.Set(other, []byte("key-000"), []byte("other")))
+
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, len(want), entries)
+	for _, e := range entries {
+		assert.Equals(t, bucket, e.Bucket)
+		assert.Equals(t, want[string(e.Key)], string(e.Value))
+	}
+
+	entries, err = db.List(other)
+	assert.FatalError(t, err)
+	assert.Len(t, 1, entries)
+	assert.Equals(t, []byte("other"), entries[0].Value)
+}
+
+func testListKeys(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-listkeys")
+	nested := []byte("nosqltest-listkeys/nested")
+	missing := []byte("nosqltest-listkeys-missing")
+	_ = db.DeleteTable(missing)
+
+	keys, err := database.ListKeys(db, bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, keys)
+	n, err := database.Count(db, bucket)
+	assert.FatalError(t, err)
+	assert.Equals(t, 0, n)
+
+	// Nested buckets and their entries are not listed nor counted.
+	assert.FatalError(t, db.CreateTable(nested))
+	assert.FatalError(t, db.Set(nested, []byte("nested"), []byte("value")))
+	want := [][]byte{{0}, []byte("a"), []byte("ab"), []byte("b"), []byte("nestee"), {0xff}}
+	for i := len(want) - 1; i >= 0; i-- {
+		assert.FatalError(t, db.Set(bucket, want[i], bytes.Repeat([]byte("v"), 1024)))
+	}
+	keys, err = database.ListKeys(db, bucket)
+	assert.FatalError(t, err)
+	assert.Equals(t, want, keys)
+	n, err = database.Count(db, bucket)
+	assert.FatalError(t, err)
+	assert.Equals(t, len(want), n)
+
+	assert.FatalError(t, db.Del(bucket, []byte("a")))
+	n, err = database.Count(db, bucket)
+	assert.FatalError(t, err)
+	assert.Equals(t, len(want)-1, n)
+	n, err = database.Count(db, nested)
+	assert.FatalError(t, err)
+	assert.Equals(t, 1, n)
+
+	_, err = database.ListKeys(db, missing)
+	assert.True(t, database.IsErrBucketNotFound(err))
+	_, err = database.Count(db, missing)
+	assert.True(t, database.IsErrBucketNotFound(err))
+}
+
+func testBinaryData(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-binary")
+
+	var all []byte
+	for i := 0; i < 256; i++ {
+		all = append(all, byte(i))
+	}
+	keys := [][]byte{
+		{0}, {0, 0}, {0xff}, {0xff, 0}, {'a', 0, 'b'}, all[:255], []byte("ключ"),
+	}
+	for i, k := range keys {
+		v := append([]byte{byte(i)}, all...)
+		assert.FatalError(t, db.Set(bucket, k, v))
+		got, err := db.Get(bucket, k)
+		assert.FatalError(t, err)
+		assert.Equals(t, v, got)
+	}
+
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, len(keys), entries)
+	for _, e := range entries {
+		var found bool
+		for i, k := range keys {
+			if bytes.Equal(k, e.Key) {
+				found = true
+				assert.Equals(t, byte(i), e.Value[0])
+			}
+		}
+		assert.True(t, found, fmt.Sprintf("unexpected key %x", e.Key))
+	}
+}
+
+func testLargeData(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-large")
+
+	// Keys up to 255 bytes and values up to 60KiB must be supported.
+	key := bytes.Repeat([]byte("k"), 255)
+	value := make([]byte, 60*1024)
+	for i := range value {
+		value[i] = byte(i % 251)
+	}
+	assert.FatalError(t, db.Set(bucket, key, value))
+	got, err := db.Get(bucket, key)
+	assert.FatalError(t, err)
+	assert.True(t, bytes.Equal(value, got))
+
+	tx := new(database.Tx)
+	tx.Set(bucket, []byte("tx"), value)
+	tx.Get(bucket, key)
+	assert.FatalError(t, db.Update(tx))
+	assert.True(t, bytes.Equal(value, tx.Operations[1].Result))
+
+	entries, err := db.List(bucket)
+	assert.FatalError(t, err)
+	assert.Len(t, 2, entries)
+	for _, e := range entries {
+		assert.True(t, bytes.Equal(value, e.Value))
+	}
+}
+
+// testConcurrency runs writers on their own keys, and CmpAndSwap and Incr
+// increments on shared keys. Transient errors, like transaction conflicts,
+// are retried, except on Incr, that must not fail.
+func testConcurrency(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-concurrency")
+	const workers, iterations = 8, 25
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[uint64]bool)
+	)
+	errs := make(chan error, workers*iterations)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				key := []byte(fmt.Sprintf("worker-%d-%d", w, i))
+				if err := db.Set(bucket, key, key); err != nil && !retry.IsTransient(err) {
+					errs <- err
+					return
+				}
+				if err := increment(db, bucket, []byte("counter")); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := database.IncrCounter(db, bucket, []byte("incr"), 1); err != nil {
+					errs <- err
+					return
+				}
+				seq, err := database.NextTableSequence(db, bucket)
+				if err != nil && !retry.IsTransient(err) {
+					errs <- err
+					return
+				}
+				if err == nil {
+					mu.Lock()
+					if seen[seq] {
+						errs <- fmt.Errorf("sequence %d returned twice", seq)
+					}
+					seen[seq] = true
+					mu.Unlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.FatalError(t, err)
+	}
+
+	v, err := db.Get(bucket, []byte("counter"))
+	assert.FatalError(t, err)
+	assert.Equals(t, fmt.Sprint(workers*iterations), string(v))
+
+	// Incr is atomic, none of the increments fails or is lost.
+	v, err = db.Get(bucket, []byte("incr"))
+	assert.FatalError(t, err)
+	assert.Equals(t, fmt.Sprint(workers*iterations), string(v))
+}
+
+// increment increments the decimal value of the given key using CmpAndSwap.
+func increment(db database.DB, bucket, key []byte) error {
+	for {
+		current, err := db.Get(bucket, key)
+		if err != nil && !database.IsErrNotFound(err) {
+			return err
+		}
+		var n int
+		if len(current) > 0 {
+			if _, err := fmt.Sscan(string(current), &n); err != nil {
+				return err
+			}
+		}
+		_, swapped, err := db.CmpAndSwap(bucket, key, current, []byte(fmt.Sprint(n+1)))
+		switch {
+		case err != nil && !retry.IsTransient(err):
+			return err
+		case swapped:
+			return nil
+		}
+	}
+}
+
+func testErrNotFound(t *testing.T, db database.DB) {
+	bucket := newTable(t, db, "nosqltest-notfound")
+	missing := []byte("nosqltest-missing-table")
+	_ = db.DeleteTable(missing)
+
+	_, err := db.Get(bucket, []byte("missing"))
+	assert.True(t, database.IsErrNotFound(err))
+	assert.False(t, database.IsErrBucketNotFound(err))
+
+	// Missing buckets are also not found errors.
+	_, err = db.List(missing)
+	assert.True(t, database.IsErrNotFound(err))
+	assert.True(t, database.IsErrBucketNotFound(err))
+	err = db.DeleteTable(missing)
+	assert.True(t, database.IsErrNotFound(err))
+	assert.True(t, database.IsErrBucketNotFound(err))
+
+	tx := new(database.Tx)
+	tx.Get(bucket, []byte("missing"))
+	err = db.Update(tx)
+	assert.True(t, database.IsErrNotFound(err))
+	assert.False(t, database.IsErrBucketNotFound(err))
+
+	// Other errors must not be classified as not found.
+	tx = new(database.Tx)
+	tx.Cmp(bucket, []byte("missing"), nil)
+	err = db.Update(tx)
+	assert.Error(t, err)
+	assert.False(t, database.IsErrNotFound(err))
+}
+
+// testBuckets checks the operations on buckets that do not exist. Reads
+// always fail with database.ErrBucketNotFound, and writes either fail the
+// same way or create the bucket if autoCreate is set.
+func testBuckets(t *testing.T, db database.DB, autoCreate bool) {
+	bucket := newTable(t, db, "nosqltest-buckets")
+	missing := []byte("nosqltest-buckets-missing")
+	_ = db.DeleteTable(missing)
+	t.Cleanup(func() {
+		_ = db.DeleteTable(missing)
+	})
+	key, value := []byte("key"), []byte("value")
+
+	assertMissing := func(t *testing.T) {
+		t.Helper()
+		_, err := db.List(missing)
+		assert.True(t, database.IsErrBucketNotFound(err), "bucket %s exists", missing)
+	}
+
+	_, err := db.Get(missing, key)
+	assert.True(t, database.IsErrBucketNotFound(err))
+	_, err = db.List(missing)
+	assert.True(t, database.IsErrBucketNotFound(err))
+	assert.True(t, database.IsErrBucketNotFound(db.DeleteTable(missing)))
+	tx := new(database.Tx)
+	tx.Get(missing, key)
+	assert.True(t, database.IsErrBucketNotFound(db.Update(tx)))
+	assertMissing(t)
+
+	writes := []struct {
+		name string
+		fn   func(bucket []byte) error
+	}{
+		{"Set", func(b []byte) error { return db.Set(b, key, value) }},
+		{"Del", func(b []byte) error { return db.Del(b, key) }},
+		{"BulkLoad", func(b []byte) error {
+			return database.BulkLoad(db, b, database.EntriesIterator([]*database.Entry{{Key: key, Value: value}}))
+		}},
+		{"Truncate", func(b []byte) error { return database.TruncateTable(db, b) }},
+		{"DeleteRange", func(b []byte) error { return database.DeleteKeyRange(db, b, key, nil) }},
+		{"DeletePrefix", func(b []byte) error { return database.DeleteKeyPrefix(db, b, key) }},
+		{"CmpAndSwap", func(b []byte) error {
+			_, _, err := db.CmpAndSwap(b, key, []byte("other"), value)
+			return err
+		}},
+		{"SetIfVersion", func(b []byte) error { return database.SetIfVersion(db, b, key, value, 0) }},
+		{"Incr", func(b []byte) error {
+			_, err := database.IncrCounter(db, b, key, 1)
+			return err
+		}},
+		{"NextSequence", func(b []byte) error {
+			_, err := database.NextTableSequence(db, b)
+			return err
+		}},
+		{"Update/Set", func(b []byte) error {
+			tx := new(database.Tx)
+			tx.Set(b, key, value)
+			return db.Update(tx)
+		}},
+		{"Update/Del", func(b []byte) error {
+			tx := new(database.Tx)
+			tx.Del(b, key)
+			return db.Update(tx)
+		}},
+		{"Update/CmpAndSwap", func(b []byte) error {
+			tx := new(database.Tx)
+			tx.Operations = append(tx.Operations, &database.TxEntry{
+				Bucket: b, Key: key, CmpValue: []byte("other"), Value: value, Cmd: database.CmpAndSwap,
+			})
+			return db.Update(tx)
+		}},
+	}
+
+	for _, w := range writes {
+		t.Run(w.name, func(t *testing.T) {
+			_ = db.DeleteTable(missing)
+			err := w.fn(missing)
+			if autoCreate {
+				assert.NoError(t, err)
+				entries, err := db.List(missing)
+				assert.FatalError(t, err)
+				assert.True(t, len(entries) <= 1)
+				return
+			}
+			assert.True(t, database.IsErrBucketNotFound(err))
+			assertMissing(t)
+		})
+	}
+
+	// A failed transaction does not write on existing buckets.
+	if !autoCreate {
+		tx := new(database.Tx)
+		tx.Set(bucket, key, value)
+		tx.Set(missing, key, value)
+		assert.True(t, database.IsErrBucketNotFound(db.Update(tx)))
+		_, err := db.Get(bucket, key)
+		assert.True(t, database.IsErrNotFound(err))
+		assert.False(t, database.IsErrBucketNotFound(err))
+		assertMissing(t)
+		return
+	}
+
+	// The buckets are created even if the transaction fails, but the writes
+	// are rolled back.
+	_ = db.DeleteTable(missing)
+	tx = new(database.Tx)
+	tx.Set(missing, key, value)
+	tx.Get(bucket, key)
+	err = db.Update(tx)
+	assert.True(t, database.IsErrNotFound(err))
+	assert.False(t, database.IsErrBucketNotFound(err))
+	entries, err := db.List(missing)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, entries)
+}
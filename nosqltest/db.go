@@ -0,0 +1,148 @@
+package nosqltest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+// Environment variables with the data source names used by NewDB on the SQL
+// drivers. The DSN must not include a database name, as a new one is created
+// for each test.
+const (
+	MySQLDataSourceEnv      = "NOSQL_MYSQL_DSN"
+	PostgreSQLDataSourceEnv = "NOSQL_POSTGRESQL_DSN"
+)
+
+type dbOptions struct {
+	dataSourceName string
+	options        []database.Option
+	fixtures       []Fixtures
+	fixtureFiles   []string
+}
+
+// DBOption is the modifier type used to configure NewDB.
+type DBOption func(o *dbOptions)
+
+// WithDataSourceName sets the data source name of the server used on the SQL
+// drivers, by default it's read from MySQLDataSourceEnv or
+// PostgreSQLDataSourceEnv. It's ignored on the other drivers.
+func WithDataSourceName(dataSourceName string) DBOption {
+	return func(o *dbOptions) {
+		o.dataSourceName = dataSourceName
+	}
+}
+
+// WithOptions appends options used to open the database.
+func WithOptions(opts ...database.Option) DBOption {
+	return func(o *dbOptions) {
+		o.options = append(o.options, opts...)
+	}
+}
+
+// WithFixtures seeds the database with the given fixtures.
+func WithFixtures(f Fixtures) DBOption {
+	return func(o *dbOptions) {
+		o.fixtures = append(o.fixtures, f)
+	}
+}
+
+// WithFixtureFile seeds the database with the fixtures in the given JSON
+// file. See LoadFixtures for the format of the file.
+func WithFixtureFile(path string) DBOption {
+	return func(o *dbOptions) {
+		o.fixtureFiles = append(o.fixtureFiles, path)
+	}
+}
+
+// NewDB opens an isolated database of the given driver for the test t, and
+// closes it when the test and its subtests complete.
+//
+// File based drivers are opened in a directory created with t.TempDir. The SQL
+// drivers create a database with a unique name on the server configured with
+// WithDataSourceName, or on the environment, and drop it on cleanup; the test
+// is skipped if no server is configured. Writes are not synced to disk, as
+// with database.WithNoSync, and the test is also skipped if the driver was
+// excluded from the build using build tags.
+func NewDB(t testing.TB, driver string, opts ...DBOption) database.DB {
+	t.Helper()
+	o := &dbOptions{}
+	for _, fn := range opts {
+		fn(o)
+	}
+
+	var (
+		dataSourceName string
+		dbOptions      = append([]database.Option{database.WithNoSync()}, o.options...)
+	)
+	switch driver = strings.ToLower(driver); driver {
+	case nosql.MySQLDriver, nosql.PostgreSQLDriver:
+		env, drop := MySQLDataSourceEnv, dropMySQLDatabase
+		if driver == nosql.PostgreSQLDriver {
+			env, drop = PostgreSQLDataSourceEnv, dropPostgreSQLDatabase
+		}
+		if dataSourceName = o.dataSourceName; dataSourceName == "" {
+			dataSourceName = os.Getenv(env)
+		}
+		if dataSourceName == "" {
+			t.Skipf("Not running %s tests, %s is not set", driver, env)
+		}
+		name, err := uniqueName()
+		if err != nil {
+			t.Fatalf("error generating database name: %v", err)
+		}
+		dbOptions = append(dbOptions, database.WithDatabase(name))
+		// Cleanups run in reverse order, so the database is dropped after
+		// closing the connections.
+		t.Cleanup(func() {
+			if err := drop(dataSourceName, name); err != nil {
+				t.Errorf("error dropping %s database %s: %v", driver, name, err)
+			}
+		})
+	default:
+		dataSourceName = filepath.Join(t.TempDir(), driver)
+	}
+
+	db, err := nosql.New(driver, dataSourceName, dbOptions...)
+	if database.IsErrOpNotSupported(err) {
+		t.Skipf("Not running %s tests, the driver is not compiled in", driver)
+	}
+	if err != nil {
+		t.Fatalf("error opening %s database: %v", driver, err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("error closing %s database: %v", driver, err)
+		}
+	})
+
+	for _, path := range o.fixtureFiles {
+		f, err := LoadFixtures(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.fixtures = append(o.fixtures, f)
+	}
+	for _, f := range o.fixtures {
+		if err := Seed(db, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+// uniqueName returns a random name for a SQL database.
+func uniqueName() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "nosqltest_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,69 @@
+package nosqltest
+
+import (
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestNewDB(t *testing.T) {
+	for _, driver := range []string{"badgerv1", "badgerv2", "bbolt"} {
+		t.Run(driver, func(t *testing.T) {
+			db := NewDB(t, driver, WithFixtureFile("testdata/fixtures.json"), WithFixtures(Fixtures{
+				"users": {"bob": []byte("Bob")},
+			}))
+
+			v, err := db.Get([]byte("users"), []byte("alice"))
+			assert.FatalError(t, err)
+			assert.Equals(t, []byte(`{"name": "Alice"}`), v)
+			v, err = db.Get([]byte("users"), []byte{0, 1})
+			assert.FatalError(t, err)
+			assert.Equals(t, []byte{0, 1, 2, 3}, v)
+			v, err = db.Get([]byte("users"), []byte("bob"))
+			assert.FatalError(t, err)
+			assert.Equals(t, []byte("Bob"), v)
+
+			entries, err := db.List([]byte("empty"))
+			assert.FatalError(t, err)
+			assert.Len(t, 0, entries)
+		})
+	}
+}
+
+func TestNewDB_sql(t *testing.T) {
+	for _, driver := range []string{"mysql", "postgresql"} {
+		t.Run(driver, func(t *testing.T) {
+			// Skipped unless the environment configures a server.
+			db := NewDB(t, driver, WithFixtures(Fixtures{"bucket": {"key": []byte("value")}}))
+			v, err := db.Get([]byte("bucket"), []byte("key"))
+			assert.FatalError(t, err)
+			assert.Equals(t, []byte("value"), v)
+		})
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	f, err := LoadFixtures("testdata/fixtures.json")
+	assert.FatalError(t, err)
+	assert.Equals(t, Fixtures{
+		"users": {
+			"alice":              []byte(`{"name": "Alice"}`),
+			string([]byte{0, 1}): {0, 1, 2, 3},
+		},
+		"empty": {},
+	}, f)
+
+	_, err = LoadFixtures("testdata/missing.json")
+	assert.Error(t, err)
+}
+
+func Test_encodeBytes(t *testing.T) {
+	for _, b := range [][]byte{nil, []byte("value"), {0xff, 0xfe}, []byte("base64:value")} {
+		s := encodeBytes(b)
+		got, err := decodeString(s)
+		assert.FatalError(t, err)
+		assert.Equals(t, string(b), string(got))
+	}
+	assert.Equals(t, "value", encodeBytes([]byte("value")))
+	assert.Equals(t, "base64://4=", encodeBytes([]byte{0xff, 0xfe}))
+}
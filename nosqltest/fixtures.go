@@ -0,0 +1,113 @@
+package nosqltest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+)
+
+// Fixtures is the data used to seed a database, it maps bucket names to the
+// keys and values stored on them.
+type Fixtures map[string]map[string][]byte
+
+// base64Prefix is the prefix of the keys and values encoded in base64 in the
+// JSON files.
+const base64Prefix = "base64:"
+
+// LoadFixtures reads the fixtures in the given JSON file. The file contains
+// an object that maps bucket names to objects with the keys and values:
+//
+//	{
+//		"users": {
+//			"alice": "{\"name\": \"Alice\"}",
+//			"bob": "base64:AAECAw=="
+//		}
+//	}
+//
+// Keys and values with the "base64:" prefix are decoded from base64, so they
+// can hold binary data.
+func LoadFixtures(path string) (Fixtures, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading fixtures")
+	}
+	var raw map[string]map[string]string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, errors.Wrapf(err, "error parsing fixtures %s", path)
+	}
+
+	f := make(Fixtures, len(raw))
+	for bucket, kv := range raw {
+		f[bucket] = make(map[string][]byte, len(kv))
+		for k, v := range kv {
+			key, err := decodeString(k)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error parsing fixtures %s: invalid key %s/%s", path, bucket, k)
+			}
+			value, err := decodeString(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error parsing fixtures %s: invalid value of %s/%s", path, bucket, k)
+			}
+			f[bucket][string(key)] = value
+		}
+	}
+	return f, nil
+}
+
+// Seed creates the buckets in the fixtures, if they don't exist, and stores
+// their keys and values in db.
+func Seed(db database.DB, f Fixtures) error {
+	for _, bucket := range sortedKeys(f) {
+		if err := db.CreateTable([]byte(bucket)); err != nil {
+			return errors.Wrapf(err, "error seeding bucket %s", bucket)
+		}
+		if len(f[bucket]) == 0 {
+			continue
+		}
+		tx := new(database.Tx)
+		for k, v := range f[bucket] {
+			tx.Set([]byte(bucket), []byte(k), v)
+		}
+		if err := db.Update(tx); err != nil {
+			return errors.Wrapf(err, "error seeding bucket %s", bucket)
+		}
+	}
+	return nil
+}
+
+// encodeBytes returns b as a string if it's printable UTF-8, and as base64
+// with the base64Prefix otherwise.
+func encodeBytes(b []byte) string {
+	if isPrintable(b) && !strings.HasPrefix(string(b), base64Prefix) {
+		return string(b)
+	}
+	return base64Prefix + base64.StdEncoding.EncodeToString(b)
+}
+
+// isPrintable returns true if b is valid UTF-8 and contains only printable
+// characters or whitespace.
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeString decodes a string encoded with encodeBytes.
+func decodeString(s string) ([]byte, error) {
+	if strings.HasPrefix(s, base64Prefix) {
+		return base64.StdEncoding.DecodeString(strings.TrimPrefix(s, base64Prefix))
+	}
+	return []byte(s), nil
+}
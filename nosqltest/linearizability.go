@@ -0,0 +1,378 @@
+package nosqltest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/smallstep/nosql/database"
+)
+
+// Default values of LinearizabilityConfig.
+const (
+	DefaultLinearizabilitySeed       = 1
+	DefaultLinearizabilityClients    = 8
+	DefaultLinearizabilityOperations = 50
+	DefaultLinearizabilityKeys       = 2
+)
+
+// LinearizabilityConfig configures RunLinearizability. Zero values are
+// replaced by their defaults.
+type LinearizabilityConfig struct {
+	// Seed is the seed used to generate the operations of the first client,
+	// the n-th client uses Seed+n.
+	Seed int64
+	// Clients is the number of goroutines running operations concurrently.
+	Clients int
+	// Operations is the number of operations run by each client.
+	Operations int
+	// Keys is the number of keys the operations are run on.
+	Keys int
+}
+
+// RunLinearizability runs concurrent Get, CmpAndSwap and Update operations
+// on the database returned by newDB from multiple goroutines, records the
+// history of the operations and fails the test if the history is not
+// linearizable, that is, if there is no sequential order of the operations,
+// consistent with their real-time order, that explains all the results
+// observed.
+//
+// Operations that fail with an error other than database.ErrNotFound might
+// or might not have been applied, the checker considers both options.
+//
+// The bucket used is deleted before and after the test, so newDB can return
+// a database shared with other tests.
+func RunLinearizability(t *testing.T, newDB Factory, cfg LinearizabilityConfig) {
+	t.Helper()
+	if cfg.Seed == 0 {
+		cfg.Seed = DefaultLinearizabilitySeed
+	}
+	if cfg.Clients <= 0 {
+		cfg.Clients = DefaultLinearizabilityClients
+	}
+	if cfg.Operations <= 0 {
+		cfg.Operations = DefaultLinearizabilityOperations
+	}
+	if cfg.Keys <= 0 {
+		cfg.Keys = DefaultLinearizabilityKeys
+	}
+
+	db := newDB(t)
+	bucket := newTable(t, db, "nosqltest-linearizability")
+
+	var (
+		clock   atomic.Int64
+		wg      sync.WaitGroup
+		history = make([][]*linOp, cfg.Clients)
+	)
+	for i := 0; i < cfg.Clients; i++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			c := &linClient{
+				id:     client,
+				db:     db,
+				bucket: bucket,
+				clock:  &clock,
+				//nolint:gosec // operations do not require a secure random source
+				rand: rand.New(rand.NewSource(cfg.Seed + int64(client))),
+				seen: make([]string, cfg.Keys),
+			}
+			for n := 0; n < cfg.Operations; n++ {
+				history[client] = append(history[client], c.run(n))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var ops []*linOp
+	for _, h := range history {
+		ops = append(ops, h...)
+	}
+	if !checkLinearizable(ops, cfg.Keys) {
+		t.Fatalf("history is not linearizable (seed %d):\n%s", cfg.Seed, formatHistory(ops))
+	}
+}
+
+// linEntry is an entry of a Get, CmpAndSwap or Update operation. Values are
+// strings, the empty string represents a missing key.
+type linEntry struct {
+	kind    opKind
+	key     int
+	old     string
+	new     string
+	value   string
+	swapped bool
+}
+
+// call returns the invocation of the entry.
+func (e linEntry) call() string {
+	switch e.kind {
+	case opGet:
+		return fmt.Sprintf("Get(k%d)", e.key)
+	case opCmpAndSwap:
+		return fmt.Sprintf("CmpAndSwap(k%d, %q, %q)", e.key, e.old, e.new)
+	default:
+		return fmt.Sprintf("unknown(%d)", e.kind)
+	}
+}
+
+func (e linEntry) String() string {
+	if e.kind == opCmpAndSwap {
+		return fmt.Sprintf("%s = %q, %t", e.call(), e.value, e.swapped)
+	}
+	return fmt.Sprintf("%s = %q", e.call(), e.value)
+}
+
+// linOp is an operation in a history. Call and ret are the logical times of
+// the invocation and the response of the operation, ret is math.MaxInt64 if
+// the outcome of the operation is unknown.
+type linOp struct {
+	client    int
+	call, ret int64
+	update    bool
+	entries   []linEntry
+	err       error
+}
+
+func (op *linOp) String() string {
+	s := make([]string, len(op.entries))
+	for i, e := range op.entries {
+		if op.err != nil {
+			s[i] = e.call()
+		} else {
+			s[i] = e.String()
+		}
+	}
+	desc := strings.Join(s, ", ")
+	if op.update {
+		desc = "Update(" + desc + ")"
+	}
+	if op.err != nil {
+		return fmt.Sprintf("client %d [%d, ?]: %s: %v", op.client, op.call, desc, op.err)
+	}
+	return fmt.Sprintf("client %d [%d, %d]: %s", op.client, op.call, op.ret, desc)
+}
+
+// step applies the operation to state and returns the new state, or false if
+// the results of the operation are not possible on state.
+func (op *linOp) step(state []string) ([]string, bool) {
+	unknown := op.ret == math.MaxInt64
+	next := append([]string{}, state...)
+	for _, e := range op.entries {
+		current := next[e.key]
+		switch e.kind {
+		case opGet:
+			if !unknown && e.value != current {
+				return nil, false
+			}
+		case opCmpAndSwap:
+			swapped := current == e.old
+			if swapped {
+				next[e.key] = e.new
+			}
+			if unknown {
+				continue
+			}
+			if e.swapped != swapped || (swapped && e.value != e.new) || (!swapped && e.value != current) {
+				return nil, false
+			}
+		}
+	}
+	return next, true
+}
+
+// linClient runs random operations and records their history. It remembers
+// the last value seen on each key, so most CmpAndSwap operations succeed.
+type linClient struct {
+	id     int
+	db     database.DB
+	bucket []byte
+	clock  *atomic.Int64
+	rand   *rand.Rand
+	seen   []string
+}
+
+func (c *linClient) run(n int) *linOp {
+	newValue := fmt.Sprintf("c%d-%d", c.id, n)
+	op := &linOp{client: c.id}
+
+	switch c.rand.Intn(3) {
+	case 0:
+		e := linEntry{kind: opGet, key: c.rand.Intn(len(c.seen))}
+		op.call = c.clock.Add(1)
+		v, err := c.db.Get(c.bucket, c.keyName(e.key))
+		op.ret = c.clock.Add(1)
+		if err != nil && !database.IsErrNotFound(err) {
+			op.err = err
+		}
+		e.value = string(v)
+		op.entries = append(op.entries, e)
+	case 1:
+		e := linEntry{kind: opCmpAndSwap, key: c.rand.Intn(len(c.seen)), new: newValue}
+		e.old = c.seen[e.key]
+		op.call = c.clock.Add(1)
+		v, swapped, err := c.db.CmpAndSwap(c.bucket, c.keyName(e.key), []byte(e.old), []byte(e.new))
+		op.ret = c.clock.Add(1)
+		op.err = err
+		e.value, e.swapped = string(v), swapped
+		op.entries = append(op.entries, e)
+	default:
+		op.update = true
+		tx := new(database.Tx)
+		for _, k := range c.rand.Perm(len(c.seen))[:min(2, len(c.seen))] {
+			e := linEntry{kind: opCmpAndSwap, key: k, old: c.seen[k], new: fmt.Sprintf("%s-k%d", newValue, k)}
+			tx.Operations = append(tx.Operations, &database.TxEntry{
+				Bucket:   c.bucket,
+				Key:      c.keyName(k),
+				CmpValue: []byte(e.old),
+				Value:    []byte(e.new),
+				Cmd:      database.CmpAndSwap,
+			})
+			op.entries = append(op.entries, e)
+		}
+		op.call = c.clock.Add(1)
+		err := c.db.Update(tx)
+		op.ret = c.clock.Add(1)
+		op.err = err
+		for i, q := range tx.Operations {
+			op.entries[i].value, op.entries[i].swapped = string(q.Result), q.Swapped
+		}
+	}
+
+	if op.err != nil {
+		op.ret = math.MaxInt64
+		return op
+	}
+	for _, e := range op.entries {
+		c.seen[e.key] = e.value
+	}
+	return op
+}
+
+func (c *linClient) keyName(k int) []byte {
+	return []byte(fmt.Sprintf("k%d", k))
+}
+
+// event is the invocation or the response of an operation, events are kept
+// in a doubly linked list that the checker modifies while searching a
+// linearization.
+type event struct {
+	id         int
+	op         *linOp
+	call       bool
+	match      *event
+	prev, next *event
+}
+
+func (e *event) time() int64 {
+	if e.call {
+		return e.op.call
+	}
+	return e.op.ret
+}
+
+// lift removes the invocation e and its response from the list.
+func (e *event) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// unlift reinserts the invocation e and its response on the list.
+func (e *event) unlift() {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// checkLinearizable implements the Wing & Gong linearizability checker with
+// the memoization proposed by Lowe, the algorithm used by porcupine. The
+// operations are run on a set of registers, one per key, initially empty.
+func checkLinearizable(history []*linOp, keys int) bool {
+	events := make([]*event, 0, 2*len(history))
+	for i, op := range history {
+		call := &event{id: i, op: op, call: true}
+		ret := &event{id: i, op: op, match: call}
+		call.match = ret
+		events = append(events, call, ret)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].time() < events[j].time()
+	})
+	head := new(event)
+	prev := head
+	for _, e := range events {
+		e.prev, prev.next = prev, e
+		prev = e
+	}
+
+	type frame struct {
+		entry *event
+		state []string
+	}
+	var (
+		calls      []frame
+		state      = make([]string, keys)
+		linearized = make([]byte, (len(history)+7)/8)
+		cache      = make(map[string]bool)
+	)
+	entry := head.next
+	for head.next != nil {
+		if !entry.call {
+			// The response of an operation is reached before linearizing
+			// it, backtrack.
+			if len(calls) == 0 {
+				return false
+			}
+			f := calls[len(calls)-1]
+			calls = calls[:len(calls)-1]
+			entry, state = f.entry, f.state
+			linearized[entry.id/8] &^= 1 << (entry.id % 8)
+			entry.unlift()
+			entry = entry.next
+			continue
+		}
+
+		if next, ok := entry.op.step(state); ok {
+			linearized[entry.id/8] |= 1 << (entry.id % 8)
+			key := string(linearized) + "|" + strings.Join(next, "\x00")
+			if !cache[key] {
+				cache[key] = true
+				calls = append(calls, frame{entry: entry, state: state})
+				state = next
+				entry.lift()
+				entry = head.next
+				continue
+			}
+			linearized[entry.id/8] &^= 1 << (entry.id % 8)
+		}
+		entry = entry.next
+	}
+	return true
+}
+
+func formatHistory(history []*linOp) string {
+	ops := append([]*linOp{}, history...)
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].call < ops[j].call
+	})
+	s := make([]string, len(ops))
+	for i, op := range ops {
+		s[i] = "  " + op.String()
+	}
+	return strings.Join(s, "\n")
+}
@@ -0,0 +1,86 @@
+package nosqltest
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func get(client int, call, ret int64, key int, value string) *linOp {
+	return &linOp{client: client, call: call, ret: ret, entries: []linEntry{
+		{kind: opGet, key: key, value: value},
+	}}
+}
+
+func cas(client int, call, ret int64, key int, old, new, value string, swapped bool) *linOp {
+	return &linOp{client: client, call: call, ret: ret, entries: []linEntry{
+		{kind: opCmpAndSwap, key: key, old: old, new: new, value: value, swapped: swapped},
+	}}
+}
+
+func failed(op *linOp) *linOp {
+	op.ret, op.err = math.MaxInt64, errors.New("failed")
+	return op
+}
+
+func Test_checkLinearizable(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []*linOp
+		want    bool
+	}{
+		{"empty", nil, true},
+		{"sequential", []*linOp{
+			cas(0, 1, 2, 0, "", "a", "a", true),
+			get(1, 3, 4, 0, "a"),
+			cas(1, 5, 6, 0, "a", "b", "b", true),
+			cas(0, 7, 8, 0, "a", "c", "b", false),
+		}, true},
+		{"concurrent", []*linOp{
+			cas(0, 1, 4, 0, "", "a", "a", true),
+			get(1, 2, 3, 0, ""),
+			get(2, 5, 6, 0, "a"),
+		}, true},
+		{"stale read", []*linOp{
+			cas(0, 1, 2, 0, "", "a", "a", true),
+			get(1, 3, 4, 0, ""),
+		}, false},
+		{"double swap", []*linOp{
+			cas(0, 1, 4, 0, "", "a", "a", true),
+			cas(1, 2, 3, 0, "", "b", "b", true),
+		}, false},
+		{"update", []*linOp{
+			cas(0, 1, 2, 0, "", "a", "a", true),
+			{client: 1, call: 3, ret: 4, update: true, entries: []linEntry{
+				{kind: opCmpAndSwap, key: 0, old: "a", new: "b", value: "b", swapped: true},
+				{kind: opCmpAndSwap, key: 1, old: "x", new: "y", swapped: false},
+			}},
+			get(2, 5, 6, 0, "b"),
+			get(2, 7, 8, 1, ""),
+		}, true},
+		{"partial update", []*linOp{
+			{client: 1, call: 1, ret: 4, update: true, entries: []linEntry{
+				{kind: opCmpAndSwap, key: 0, old: "", new: "a", value: "a", swapped: true},
+				{kind: opCmpAndSwap, key: 1, old: "", new: "b", value: "b", swapped: true},
+			}},
+			get(0, 2, 3, 0, "a"),
+			get(0, 5, 6, 1, ""),
+		}, false},
+		{"failed applied", []*linOp{
+			failed(cas(0, 1, 2, 0, "", "a", "", false)),
+			get(1, 3, 4, 0, "a"),
+		}, true},
+		{"failed not applied", []*linOp{
+			failed(cas(0, 1, 2, 0, "", "a", "", false)),
+			get(1, 3, 4, 0, ""),
+			cas(1, 5, 6, 0, "", "b", "b", true),
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equals(t, tt.want, checkLinearizable(tt.history, 2))
+		})
+	}
+}
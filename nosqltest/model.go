@@ -0,0 +1,512 @@
+package nosqltest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/nosql/database"
+)
+
+// Default values of DifferentialConfig.
+const (
+	DefaultDifferentialSeed      = 1
+	DefaultDifferentialSequences = 25
+	DefaultDifferentialLength    = 50
+)
+
+// DifferentialConfig configures RunDifferential. Zero values are replaced by
+// their defaults.
+type DifferentialConfig struct {
+	// Seed is the seed used to generate the first sequence, the n-th
+	// sequence uses Seed+n.
+	Seed int64
+	// Sequences is the number of sequences to generate.
+	Sequences int
+	// Length is the number of operations on each sequence.
+	Length int
+	// MissingBuckets enables data operations on buckets that do not exist,
+	// the model expects them to fail with database.ErrBucketNotFound.
+	MissingBuckets bool
+	// AutoCreateTables declares that the database was opened using
+	// database.WithAutoCreateTables, the model expects the write operations
+	// to create the buckets that do not exist.
+	AutoCreateTables bool
+}
+
+// RunDifferential generates random sequences of operations and applies them
+// both to the database returned by newDB and to a reference model, failing
+// the test on the first divergence. The sequence reported is shrunk to the
+// minimal sequence that reproduces the divergence.
+//
+// The buckets used are deleted before running each sequence, so newDB can
+// return a database shared with other tests.
+func RunDifferential(t *testing.T, newDB Factory, cfg DifferentialConfig) {
+	t.Helper()
+	if cfg.Seed == 0 {
+		cfg.Seed = DefaultDifferentialSeed
+	}
+	if cfg.Sequences <= 0 {
+		cfg.Sequences = DefaultDifferentialSequences
+	}
+	if cfg.Length <= 0 {
+		cfg.Length = DefaultDifferentialLength
+	}
+
+	db := newDB(t)
+	t.Cleanup(func() { resetModelBuckets(db) })
+	for i := 0; i < cfg.Sequences; i++ {
+		seed := cfg.Seed + int64(i)
+		//nolint:gosec // sequences do not require a secure random source
+		ops := generateOps(rand.New(rand.NewSource(seed)), cfg)
+		if d := runOps(db, ops, cfg.AutoCreateTables); d != nil {
+			ops, d = shrinkOps(db, ops, d, cfg)
+			t.Fatalf("seed %d: database diverges from the model after %d operations:\n%s\n%s",
+				seed, len(ops), formatOps(ops), d)
+		}
+	}
+}
+
+var (
+	modelBuckets = [][]byte{
+		[]byte("nosqltest-model-0"), []byte("nosqltest-model-1"), []byte("nosqltest-model-2"),
+	}
+	modelKeys = [][]byte{
+		[]byte("k0"), []byte("k1"), []byte("k2"), []byte("k3"),
+	}
+	modelValues = [][]byte{
+		{}, []byte("v0"), []byte("v1"), []byte("v2"),
+	}
+)
+
+// opKind is the type of a generated operation.
+type opKind int
+
+const (
+	opCreateTable opKind = iota
+	opDeleteTable
+	opGet
+	opSet
+	opDel
+	opCmpAndSwap
+	opList
+	opUpdate
+)
+
+// modelOp is an operation generated by RunDifferential. Update operations
+// use the entries in tx.
+type modelOp struct {
+	kind     opKind
+	bucket   []byte
+	key      []byte
+	value    []byte
+	oldValue []byte
+	tx       []modelOp
+}
+
+func (op modelOp) String() string {
+	switch op.kind {
+	case opCreateTable:
+		return fmt.Sprintf("CreateTable(%s)", op.bucket)
+	case opDeleteTable:
+		return fmt.Sprintf("DeleteTable(%s)", op.bucket)
+	case opGet:
+		return fmt.Sprintf("Get(%s, %s)", op.bucket, op.key)
+	case opSet:
+		return fmt.Sprintf("Set(%s, %s, %q)", op.bucket, op.key, op.value)
+	case opDel:
+		return fmt.Sprintf("Del(%s, %s)", op.bucket, op.key)
+	case opCmpAndSwap:
+		return fmt.Sprintf("CmpAndSwap(%s, %s, %s, %q)", op.bucket, op.key, formatValue(op.oldValue), op.value)
+	case opList:
+		return fmt.Sprintf("List(%s)", op.bucket)
+	case opUpdate:
+		s := make([]string, len(op.tx))
+		for i, q := range op.tx {
+			s[i] = q.String()
+		}
+		return fmt.Sprintf("Update(%s)", strings.Join(s, ", "))
+	default:
+		return fmt.Sprintf("unknown(%d)", op.kind)
+	}
+}
+
+func formatValue(v []byte) string {
+	if v == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%q", v)
+}
+
+func formatOps(ops []modelOp) string {
+	s := make([]string, len(ops))
+	for i, op := range ops {
+		s[i] = fmt.Sprintf("  %d: %s", i, op)
+	}
+	return strings.Join(s, "\n")
+}
+
+// model is the reference implementation of a database.
+type model map[string]map[string][]byte
+
+func (m model) clone() model {
+	c := make(model, len(m))
+	for b, kv := range m {
+		c[b] = make(map[string][]byte, len(kv))
+		for k, v := range kv {
+			c[b][k] = v
+		}
+	}
+	return c
+}
+
+// generateOps generates a sequence of operations. Data operations only use
+// existing buckets unless cfg.MissingBuckets is set.
+func generateOps(r *rand.Rand, cfg DifferentialConfig) []modelOp {
+	exists := make(map[string]bool)
+	pick := func(s [][]byte) []byte { return s[r.Intn(len(s))] }
+	pickBucket := func() ([]byte, bool) {
+		if cfg.MissingBuckets {
+			return pick(modelBuckets), true
+		}
+		var existing [][]byte
+		for _, b := range modelBuckets {
+			if exists[string(b)] {
+				existing = append(existing, b)
+			}
+		}
+		if len(existing) == 0 {
+			return nil, false
+		}
+		return pick(existing), true
+	}
+	dataOp := func(kinds ...opKind) (modelOp, bool) {
+		bucket, ok := pickBucket()
+		if !ok {
+			return modelOp{}, false
+		}
+		op := modelOp{kind: kinds[r.Intn(len(kinds))], bucket: bucket, key: pick(modelKeys)}
+		switch op.kind {
+		case opSet:
+			op.value = pick(modelValues)
+		case opCmpAndSwap:
+			op.value = pick(modelValues)
+			if r.Intn(3) > 0 {
+				op.oldValue = pick(modelValues)
+			}
+		case opList:
+			op.key = nil
+		}
+		return op, true
+	}
+
+	ops := make([]modelOp, 0, cfg.Length)
+	for len(ops) < cfg.Length {
+		var (
+			op modelOp
+			ok = true
+		)
+		switch n := r.Intn(20); {
+		case n < 2:
+			op = modelOp{kind: opCreateTable, bucket: pick(modelBuckets)}
+			exists[string(op.bucket)] = true
+		case n < 3:
+			op = modelOp{kind: opDeleteTable, bucket: pick(modelBuckets)}
+			delete(exists, string(op.bucket))
+		case n < 16:
+			op, ok = dataOp(opGet, opSet, opDel, opCmpAndSwap, opList)
+		default:
+			op = modelOp{kind: opUpdate}
+			for i := r.Intn(4) + 1; i > 0 && ok; i-- {
+				var q modelOp
+				if q, ok = dataOp(opGet, opSet, opDel, opCmpAndSwap); ok {
+					op.tx = append(op.tx, q)
+				}
+			}
+		}
+		if ok {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// divergence describes an operation with different outcomes on the database
+// and on the model.
+type divergence struct {
+	index    int
+	op       modelOp
+	got      string
+	expected string
+}
+
+func (d *divergence) String() string {
+	return fmt.Sprintf("operation %d: %s\n  database: %s\n  model:    %s", d.index, d.op, d.got, d.expected)
+}
+
+// runOps applies ops to a clean database and a new model, returning the
+// first divergence, if any.
+func runOps(db database.DB, ops []modelOp, autoCreate bool) *divergence {
+	resetModelBuckets(db)
+	m := make(model)
+	for i, op := range ops {
+		if autoCreate {
+			m.createWriteBuckets(op)
+		}
+		expected := m.apply(op)
+		got := applyToDB(db, op)
+		if got != expected {
+			return &divergence{index: i, op: op, got: got, expected: expected}
+		}
+	}
+	return nil
+}
+
+// shrinkOps returns the shortest sequence, obtained removing operations from
+// ops, that still diverges. Unless cfg.MissingBuckets is set, sequences with
+// data operations on buckets that do not exist are discarded, as generateOps
+// would not generate them.
+func shrinkOps(db database.DB, ops []modelOp, d *divergence, cfg DifferentialConfig) ([]modelOp, *divergence) {
+	ops = ops[:d.index+1]
+	for chunk := len(ops) / 2; chunk > 0; chunk /= 2 {
+		for start := 0; start+chunk <= len(ops); {
+			candidate := append(append([]modelOp{}, ops[:start]...), ops[start+chunk:]...)
+			if !cfg.MissingBuckets && !existingBuckets(candidate) {
+				start += chunk
+				continue
+			}
+			if cd := runOps(db, candidate, cfg.AutoCreateTables); cd != nil {
+				ops, d = candidate[:cd.index+1], cd
+				continue
+			}
+			start += chunk
+		}
+	}
+	return ops, d
+}
+
+// existingBuckets returns true if all the data operations in ops use buckets
+// created by a previous operation.
+func existingBuckets(ops []modelOp) bool {
+	exists := make(map[string]bool)
+	for _, op := range ops {
+		switch op.kind {
+		case opCreateTable:
+			exists[string(op.bucket)] = true
+		case opDeleteTable:
+			delete(exists, string(op.bucket))
+		case opUpdate:
+			for _, q := range op.tx {
+				if !exists[string(q.bucket)] {
+					return false
+				}
+			}
+		default:
+			if !exists[string(op.bucket)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func resetModelBuckets(db database.DB) {
+	for _, b := range modelBuckets {
+		_ = db.DeleteTable(b)
+	}
+}
+
+// createWriteBuckets creates the buckets used by the write operations in op,
+// as databases opened with database.WithAutoCreateTables do before running
+// them.
+func (m model) createWriteBuckets(op modelOp) {
+	ops := []modelOp{op}
+	if op.kind == opUpdate {
+		ops = op.tx
+	}
+	for _, q := range ops {
+		switch q.kind {
+		case opSet, opDel, opCmpAndSwap:
+			if _, ok := m[string(q.bucket)]; !ok {
+				m[string(q.bucket)] = make(map[string][]byte)
+			}
+		}
+	}
+}
+
+// apply applies op to the model and returns its outcome.
+func (m model) apply(op modelOp) string {
+	switch op.kind {
+	case opCreateTable:
+		if _, ok := m[string(op.bucket)]; !ok {
+			m[string(op.bucket)] = make(map[string][]byte)
+		}
+		return outcomeOK
+	case opDeleteTable:
+		if _, ok := m[string(op.bucket)]; !ok {
+			return outcomeBucketNotFound
+		}
+		delete(m, string(op.bucket))
+		return outcomeOK
+	case opList:
+		kv, ok := m[string(op.bucket)]
+		if !ok {
+			return outcomeBucketNotFound
+		}
+		entries := make([]*database.Entry, 0, len(kv))
+		for k, v := range kv {
+			entries = append(entries, &database.Entry{Key: []byte(k), Value: v})
+		}
+		return formatEntries(entries)
+	case opUpdate:
+		tx := m.clone()
+		results := make([]string, len(op.tx))
+		for i, q := range op.tx {
+			results[i] = tx.apply(q)
+			switch results[i] {
+			case outcomeNotFound, outcomeBucketNotFound, outcomeError:
+				return results[i]
+			}
+		}
+		for b := range m {
+			delete(m, b)
+		}
+		for b, kv := range tx {
+			m[b] = kv
+		}
+		return "ok " + strings.Join(results, "; ")
+	}
+
+	kv, ok := m[string(op.bucket)]
+	if !ok {
+		return outcomeBucketNotFound
+	}
+	current, exists := kv[string(op.key)]
+	switch op.kind {
+	case opGet:
+		if !exists {
+			return outcomeNotFound
+		}
+		return formatResult(current)
+	case opSet:
+		kv[string(op.key)] = op.value
+		return outcomeOK
+	case opDel:
+		delete(kv, string(op.key))
+		return outcomeOK
+	case opCmpAndSwap:
+		if !bytes.Equal(current, op.oldValue) {
+			return formatSwap(current, false)
+		}
+		kv[string(op.key)] = op.value
+		return formatSwap(op.value, true)
+	default:
+		return outcomeError
+	}
+}
+
+// applyToDB applies op to db and returns its outcome.
+func applyToDB(db database.DB, op modelOp) string {
+	switch op.kind {
+	case opCreateTable:
+		return formatErr(db.CreateTable(op.bucket))
+	case opDeleteTable:
+		return formatErr(db.DeleteTable(op.bucket))
+	case opGet:
+		v, err := db.Get(op.bucket, op.key)
+		if err != nil {
+			return formatErr(err)
+		}
+		return formatResult(v)
+	case opSet:
+		return formatErr(db.Set(op.bucket, op.key, op.value))
+	case opDel:
+		return formatErr(db.Del(op.bucket, op.key))
+	case opCmpAndSwap:
+		v, swapped, err := db.CmpAndSwap(op.bucket, op.key, op.oldValue, op.value)
+		if err != nil {
+			return formatErr(err)
+		}
+		return formatSwap(v, swapped)
+	case opList:
+		entries, err := db.List(op.bucket)
+		if err != nil {
+			return formatErr(err)
+		}
+		return formatEntries(entries)
+	case opUpdate:
+		tx := new(database.Tx)
+		for _, q := range op.tx {
+			e := &database.TxEntry{Bucket: q.bucket, Key: q.key, Value: q.value}
+			switch q.kind {
+			case opGet:
+				e.Cmd = database.Get
+			case opSet:
+				e.Cmd = database.Set
+			case opDel:
+				e.Cmd = database.Delete
+			case opCmpAndSwap:
+				e.Cmd, e.CmpValue = database.CmpAndSwap, q.oldValue
+			}
+			tx.Operations = append(tx.Operations, e)
+		}
+		if err := db.Update(tx); err != nil {
+			return formatErr(err)
+		}
+		results := make([]string, len(tx.Operations))
+		for i, e := range tx.Operations {
+			switch e.Cmd {
+			case database.Get:
+				results[i] = formatResult(e.Result)
+			case database.CmpAndSwap:
+				results[i] = formatSwap(e.Result, e.Swapped)
+			default:
+				results[i] = outcomeOK
+			}
+		}
+		return "ok " + strings.Join(results, "; ")
+	default:
+		return outcomeError
+	}
+}
+
+// Outcomes of the operations without a result.
+const (
+	outcomeOK             = "ok"
+	outcomeNotFound       = "not found"
+	outcomeBucketNotFound = "bucket not found"
+	outcomeError          = "error"
+)
+
+func formatErr(err error) string {
+	switch {
+	case err == nil:
+		return outcomeOK
+	case database.IsErrBucketNotFound(err):
+		return outcomeBucketNotFound
+	case database.IsErrNotFound(err):
+		return outcomeNotFound
+	default:
+		return outcomeError
+	}
+}
+
+// formatResult formats a value read, nil and empty values are equivalent.
+func formatResult(v []byte) string {
+	return fmt.Sprintf("ok %q", v)
+}
+
+func formatSwap(v []byte, swapped bool) string {
+	return fmt.Sprintf("ok %q swapped=%t", v, swapped)
+}
+
+func formatEntries(entries []*database.Entry) string {
+	entries = sortEntries(entries)
+	s := make([]string, len(entries))
+	for i, e := range entries {
+		s[i] = fmt.Sprintf("%s=%q", e.Key, e.Value)
+	}
+	return "ok [" + strings.Join(s, " ") + "]"
+}
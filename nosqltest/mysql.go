@@ -0,0 +1,29 @@
+//go:build !nomysql
+// +build !nomysql
+
+package nosqltest
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// dropMySQLDatabase drops the database with the given name on the MySQL
+// server.
+func dropMySQLDatabase(dataSourceName, name string) error {
+	parsedDSN, err := mysql.ParseDSN(dataSourceName)
+	if err != nil {
+		return errors.Wrap(err, "parse database from dataSource")
+	}
+	parsedDSN.DBName = ""
+	db, err := sql.Open("mysql", parsedDSN.FormatDSN())
+	if err != nil {
+		return errors.Wrap(err, "error connecting to mysql")
+	}
+	defer db.Close()
+	_, err = db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", name))
+	return errors.WithStack(err)
+}
@@ -0,0 +1,9 @@
+//go:build nomysql
+// +build nomysql
+
+package nosqltest
+
+// dropMySQLDatabase does nothing, the MySQL driver is not compiled in.
+func dropMySQLDatabase(dataSourceName, name string) error {
+	return nil
+}
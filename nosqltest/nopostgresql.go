@@ -0,0 +1,10 @@
+//go:build nopgx
+// +build nopgx
+
+package nosqltest
+
+// dropPostgreSQLDatabase does nothing, the PostgreSQL driver is not compiled
+// in.
+func dropPostgreSQLDatabase(dataSourceName, name string) error {
+	return nil
+}
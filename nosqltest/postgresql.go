@@ -0,0 +1,34 @@
+//go:build !nopgx
+// +build !nopgx
+
+package nosqltest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// dropPostgreSQLDatabase drops the database with the given name on the
+// PostgreSQL server.
+func dropPostgreSQLDatabase(dataSourceName, name string) error {
+	config, err := pgx.ParseConfig(dataSourceName)
+	if err != nil {
+		return errors.Wrap(err, "error parsing PostgreSQL DSN")
+	}
+	// A database can't be dropped while connected to it, so connect to
+	// "template1" as the driver does to create it.
+	config.Database = "template1"
+
+	ctx := context.Background()
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return errors.Wrap(err, "error connecting to PostgreSQL")
+	}
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s", pgx.Identifier{name}.Sanitize()))
+	return errors.WithStack(err)
+}
@@ -0,0 +1,177 @@
+package nosqltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+)
+
+// updateFlag is the name of the flag that makes AssertSnapshot rewrite the
+// golden files.
+const updateFlag = "update"
+
+func init() {
+	// The flag might be defined already by other packages with golden files.
+	if flag.Lookup(updateFlag) == nil {
+		flag.Bool(updateFlag, false, "update the golden files of nosqltest.AssertSnapshot")
+	}
+}
+
+// updateGolden reports whether the tests run with the -update flag.
+func updateGolden() bool {
+	f := flag.Lookup(updateFlag)
+	return f != nil && f.Value.String() == "true"
+}
+
+// AssertSnapshot compares the entries stored in the given buckets of db with
+// the ones in the golden file, and fails the test if they don't match. If no
+// buckets are given, the buckets in the golden file are used, or all the
+// buckets in db if the golden file is being created. Buckets that don't exist
+// in db are not part of the snapshot.
+//
+// The golden file uses the same format as LoadFixtures, with buckets and keys
+// sorted, so it can also be used to seed a database. Keys and values are
+// written as strings if they are printable UTF-8, and in base64 otherwise.
+//
+// If the tests run with the -update flag, the golden file is rewritten with
+// the current contents of db instead:
+//
+//	go test -run TestMigration -update
+func AssertSnapshot(t testing.TB, db database.DB, golden string, buckets ...string) {
+	t.Helper()
+
+	want, err := os.ReadFile(golden)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err) && updateGolden():
+	default:
+		t.Fatalf("error reading golden file: %v", err)
+	}
+
+	if len(buckets) == 0 {
+		var raw map[string]json.RawMessage
+		if len(want) > 0 {
+			if err := json.Unmarshal(want, &raw); err != nil {
+				t.Fatalf("error parsing golden file %s: %v", golden, err)
+			}
+		}
+		buckets = sortedKeys(raw)
+	}
+	if len(buckets) == 0 && len(want) == 0 {
+		// Write a new golden file with all the buckets.
+		tables, err := database.ListTables(db)
+		if err != nil {
+			t.Fatalf("error listing buckets: %v", err)
+		}
+		for _, table := range tables {
+			buckets = append(buckets, string(table))
+		}
+	}
+
+	got, err := Snapshot(db, buckets...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updateGolden() {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatalf("error writing golden file: %v", err)
+		}
+		if err := os.WriteFile(golden, got, 0600); err != nil {
+			t.Fatalf("error writing golden file: %v", err)
+		}
+		return
+	}
+
+	if !bytes.Equal(want, got) {
+		t.Errorf("database does not match %s, run the tests with -update to rewrite it:\n%s",
+			golden, diffLines(string(want), string(got)))
+	}
+}
+
+// Snapshot returns the entries stored in the given buckets of db in the format
+// used by AssertSnapshot.
+func Snapshot(db database.DB, buckets ...string) ([]byte, error) {
+	snapshot := make(map[string]map[string]string, len(buckets))
+	for _, bucket := range buckets {
+		entries, err := db.List([]byte(bucket))
+		switch {
+		case database.IsErrNotFound(err):
+			continue
+		case err != nil:
+			return nil, errors.Wrapf(err, "error listing bucket %s", bucket)
+		}
+		kv := make(map[string]string, len(entries))
+		for _, e := range entries {
+			kv[encodeBytes(e.Key)] = encodeBytes(e.Value)
+		}
+		snapshot[bucket] = kv
+	}
+
+	// The encoder sorts the keys of the maps.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(snapshot); err != nil {
+		return nil, errors.Wrap(err, "error encoding snapshot")
+	}
+	return buf.Bytes(), nil
+}
+
+// diffLines returns the lines removed from a, prefixed by "-", and the ones
+// added in b, prefixed by "+", in the order they appear.
+func diffLines(a, b string) string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and
+	// y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("-" + x[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+" + y[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,73 @@
+package nosqltest
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+// recorder is a testing.TB that records the errors.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertSnapshot(t *testing.T) {
+	db := NewDB(t, "bbolt", WithFixtureFile("testdata/fixtures.json"))
+	AssertSnapshot(t, db, "testdata/snapshot.json")
+	AssertSnapshot(t, db, "testdata/snapshot.json", "empty", "users", "missing")
+
+	// The golden file can be used as fixtures.
+	AssertSnapshot(t, NewDB(t, "badgerv2", WithFixtureFile("testdata/snapshot.json")), "testdata/snapshot.json")
+
+	assert.FatalError(t, db.Set([]byte("users"), []byte("alice"), []byte("<Alice>")))
+	r := &recorder{TB: t}
+	AssertSnapshot(r, db, "testdata/snapshot.json")
+	if assert.Len(t, 1, r.errors) {
+		assert.True(t, strings.HasSuffix(r.errors[0], "-\t\t\"alice\": \"{\\\"name\\\": \\\"Alice\\\"}\",\n+\t\t\"alice\": \"<Alice>\",\n"))
+	}
+}
+
+func TestAssertSnapshot_update(t *testing.T) {
+	assert.FatalError(t, flag.Set(updateFlag, "true"))
+	t.Cleanup(func() { flag.Set(updateFlag, "false") })
+
+	golden := filepath.Join(t.TempDir(), "testdata", "golden.json")
+	db := NewDB(t, "bbolt", WithFixtures(Fixtures{
+		"bucket": {"key": {0xff}, "\x00": []byte("value")},
+	}))
+	AssertSnapshot(t, db, golden, "bucket")
+
+	b, err := os.ReadFile(golden)
+	assert.FatalError(t, err)
+	assert.Equals(t, "{\n\t\"bucket\": {\n\t\t\"base64:AA==\": \"value\",\n\t\t\"key\": \"base64:/w==\"\n\t}\n}\n", string(b))
+
+	assert.FatalError(t, db.Del([]byte("bucket"), []byte("key")))
+	AssertSnapshot(t, db, golden)
+	b, err = os.ReadFile(golden)
+	assert.FatalError(t, err)
+	assert.Equals(t, "{\n\t\"bucket\": {\n\t\t\"base64:AA==\": \"value\"\n\t}\n}\n", string(b))
+
+	// New golden files without buckets have all the buckets.
+	assert.FatalError(t, db.CreateTable([]byte("empty")))
+	golden = filepath.Join(t.TempDir(), "all.json")
+	AssertSnapshot(t, db, golden)
+	b, err = os.ReadFile(golden)
+	assert.FatalError(t, err)
+	assert.Equals(t, "{\n\t\"bucket\": {\n\t\t\"base64:AA==\": \"value\"\n\t},\n\t\"empty\": {}\n}\n", string(b))
+}
+
+func Test_diffLines(t *testing.T) {
+	assert.Equals(t, "", diffLines("a\nb\n", "a\nb\n"))
+	assert.Equals(t, "-b\n+c\n+d\n", diffLines("a\nb\n", "a\nc\nd\n"))
+	assert.Equals(t, "+a\n", diffLines("b", "a\nb"))
+}
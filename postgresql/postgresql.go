@@ -7,9 +7,17 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	pgxstdlib "github.com/jackc/pgx/v4/stdlib"
 	"github.com/pkg/errors"
@@ -18,7 +26,20 @@ import (
 
 // DB is a wrapper over *sql.DB,
 type DB struct {
-	db *sql.DB
+	db         *sql.DB
+	ctx        context.Context
+	autoCreate bool
+	// sequences is set once the sequences table is known to exist.
+	sequences *atomic.Bool
+	// versioned caches whether the tables have the nversion column.
+	versioned *sync.Map
+}
+
+// sqlConn is the interface implemented by *sql.DB and *sql.Tx.
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 func quoteIdentifier(identifier string) string {
@@ -49,10 +70,8 @@ func createDatabase(config *pgx.ConnConfig) error {
 	defer conn.Close(context.Background())
 
 	_, err = conn.Exec(context.Background(), fmt.Sprintf("CREATE DATABASE %s", quoteIdentifier(db)))
-	if err != nil {
-		if !strings.Contains(err.Error(), "(SQLSTATE 42P04)") {
-			return errors.Wrapf(err, "error creating database %s (if not exists)", db)
-		}
+	if err != nil && pgCode(err) != pgDuplicateDatabase {
+		return errors.Wrapf(err, "error creating database %s (if not exists)", db)
 	}
 
 	return nil
@@ -67,6 +86,7 @@ func (db *DB) Open(dataSourceName string, opt ...database.Option) error {
 			return err
 		}
 	}
+	db.autoCreate = opts.AutoCreateTables
 
 	config, err := pgx.ParseConfig(dataSourceName)
 	if err != nil {
@@ -80,7 +100,7 @@ func (db *DB) Open(dataSourceName string, opt ...database.Option) error {
 	// Attempt to open the database.
 	db.db = pgxstdlib.OpenDB(*config)
 	err = db.db.Ping()
-	if err != nil && strings.Contains(err.Error(), "(SQLSTATE 3D000)") {
+	if err != nil && pgCode(err) == pgInvalidCatalogName {
 		// The database does not exist. Create it.
 		err = createDatabase(config)
 		if err != nil {
@@ -95,46 +115,470 @@ func (db *DB) Open(dataSourceName string, opt ...database.Option) error {
 		return errors.Wrapf(err, "error connecting to PostgreSQL database")
 	}
 
+	db.sequences = new(atomic.Bool)
+	db.versioned = new(sync.Map)
+	if opts.SchemaMigration {
+		return db.addVersionColumns()
+	}
+	return nil
+}
+
+// versionColumnsQry returns the columns used to find the tables without the
+// nversion column.
+const versionColumnsQry = "SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = current_schema() AND column_name IN ('nkey', 'nversion');"
+
+// addVersionColumns adds the nversion column to the tables created before
+// versions, it runs only if the database is opened using
+// database.WithSchemaMigration.
+func (db *DB) addVersionColumns() error {
+	rows, err := db.query(db.db, versionColumnsQry)
+	if err != nil {
+		return errors.Wrap(err, "error listing columns")
+	}
+	defer rows.Close()
+	var (
+		tables    []string
+		versioned = make(map[string]bool)
+	)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return errors.Wrap(err, "error getting column from row")
+		}
+		if column == "nkey" {
+			tables = append(tables, table)
+		} else {
+			versioned[table] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(mapError(err), "error accessing row")
+	}
+	rows.Close()
+
+	for _, table := range tables {
+		if versioned[table] {
+			continue
+		}
+		if _, err := db.exec(db.db, addVersionColumnQry([]byte(table))); err != nil {
+			return errors.Wrapf(err, "error adding version column to table %s", table)
+		}
+	}
+	return nil
+}
+
+// tableColumnsQry returns the nkey and nversion columns of a table.
+const tableColumnsQry = "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name IN ('nkey', 'nversion');"
+
+// isVersioned returns whether the given table has the nversion column. The
+// tables created before versions can be written without migrating them, but
+// their entries keep version 1 until they are migrated. The result is cached
+// if the table exists.
+func (db *DB) isVersioned(conn sqlConn, bucket []byte) (bool, error) {
+	if ok, found := db.versioned.Load(string(bucket)); found {
+		return ok.(bool), nil
+	}
+	rows, err := db.query(conn, tableColumnsQry, string(bucket))
+	if err != nil {
+		return false, errors.Wrapf(err, "error listing columns of table %s", bucket)
+	}
+	defer rows.Close()
+	var exists, versioned bool
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return false, errors.Wrap(err, "error getting column from row")
+		}
+		if column == "nkey" {
+			exists = true
+		} else {
+			versioned = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, errors.Wrap(mapError(err), "error accessing row")
+	}
+	// A missing table is reported by the statement using it.
+	if exists {
+		db.versioned.Store(string(bucket), versioned)
+	}
+	return versioned, nil
+}
+
+// checkVersioned returns an error if the given table exists but it does not
+// have the nversion column.
+func (db *DB) checkVersioned(bucket []byte) error {
+	versioned, err := db.isVersioned(db.db, bucket)
+	if err != nil || versioned {
+		return err
+	}
+	if _, exists := db.versioned.Load(string(bucket)); exists {
+		return errors.Errorf("%s: %s", bucket, errMigrationRequired)
+	}
 	return nil
 }
 
+// forgetVersioned removes the given tables from the cache of isVersioned.
+func (db *DB) forgetVersioned(buckets ...[]byte) {
+	for _, bucket := range buckets {
+		db.versioned.Delete(string(bucket))
+	}
+}
+
 // Close shutsdown the database driver.
 func (db *DB) Close() error {
 	return errors.WithStack(db.db.Close())
 }
 
+// WithContext returns a shallow copy of the database that runs all its
+// statements with the given context.
+func (db *DB) WithContext(ctx context.Context) database.DB {
+	c := *db
+	c.ctx = ctx
+	return &c
+}
+
+func (db *DB) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+func (db *DB) begin() (*sql.Tx, error) {
+	tx, err := db.db.BeginTx(db.context(), nil)
+	return tx, mapError(err)
+}
+
+// beginReadOnly starts a read-only transaction that reads a consistent
+// snapshot of the database.
+func (db *DB) beginReadOnly() (*sql.Tx, error) {
+	tx, err := db.db.BeginTx(db.context(), &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+	return tx, mapError(err)
+}
+
+func (db *DB) exec(conn sqlConn, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := database.StartStatement(db.context(), query)
+	res, err := conn.ExecContext(ctx, query, args...)
+	done(err)
+	return res, mapError(err)
+}
+
+func (db *DB) query(conn sqlConn, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := database.StartStatement(db.context(), query)
+	rows, err := conn.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, mapError(err)
+}
+
+// queryRow runs a query that is expected to return at most one row and
+// scans it into dest.
+func (db *DB) queryRow(conn sqlConn, dest interface{}, query string, args ...interface{}) error {
+	ctx, done := database.StartStatement(db.context(), query)
+	err := conn.QueryRowContext(ctx, query, args...).Scan(dest)
+	if errors.Is(err, sql.ErrNoRows) {
+		done(nil)
+	} else {
+		done(err)
+	}
+	return mapError(err)
+}
+
+// PostgreSQL SQLSTATE codes used by the driver.
+const (
+	pgDuplicateDatabase      = "42P04" // duplicate_database
+	pgInvalidCatalogName     = "3D000" // invalid_catalog_name
+	pgUndefinedTable         = "42P01" // undefined_table
+	pgUndefinedColumn        = "42703" // undefined_column
+	pgDuplicateTable         = "42P07" // duplicate_table
+	pgSerializationFailure   = "40001" // serialization_failure
+	pgDeadlockDetected       = "40P01" // deadlock_detected
+	pgProgramLimitExceeded   = "54000" // program_limit_exceeded
+	pgCheckViolation         = "23514" // check_violation
+	pgReadOnlySQLTransaction = "25006" // read_only_sql_transaction
+	pgAdminShutdown          = "57P01" // admin_shutdown
+	pgConnectionDoesNotExist = "08003" // connection_does_not_exist
+	pgConnectionFailure      = "08006" // connection_failure
+)
+
+// errDatabaseClosed is the message of the error returned by database/sql
+// after closing the database, it does not export a sentinel error for it.
+const errDatabaseClosed = "sql: database is closed"
+
+// pgCode returns the SQLSTATE code of a PostgreSQL error, or an empty string
+// if err is not one.
+func pgCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// errMigrationRequired is the message added to the errors caused by reading or
+// writing the version of a table created before versions.
+const errMigrationRequired = "table created by an older version, open the database using database.WithSchemaMigration to migrate it"
+
+// mapError returns an error that matches the database error equivalent to
+// the given PostgreSQL error, and keeps the PostgreSQL error as its cause.
+// The only check constraint on the tables is the length of the keys. Broken
+// connections are mapped to driver.ErrBadConn. A missing nversion column means
+// the table needs WithSchemaMigration.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var target error
+	switch pgCode(err) {
+	case pgUndefinedTable:
+		target = database.ErrBucketNotFound
+	case pgDuplicateTable:
+		target = database.ErrBucketExists
+	case pgSerializationFailure, pgDeadlockDetected:
+		target = database.ErrConflict
+	case pgProgramLimitExceeded:
+		target = database.ErrTxTooLarge
+	case pgCheckViolation:
+		target = database.ErrInvalidKey
+	case pgReadOnlySQLTransaction:
+		target = database.ErrReadOnly
+	case pgUndefinedColumn:
+		if strings.Contains(err.Error(), `"nversion"`) {
+			return errors.Wrap(err, errMigrationRequired)
+		}
+		return err
+	case pgAdminShutdown, pgConnectionDoesNotExist, pgConnectionFailure:
+		target = driver.ErrBadConn
+	case "":
+		if err.Error() != errDatabaseClosed {
+			return err
+		}
+		target = database.ErrClosed
+	default:
+		return err
+	}
+	if errors.Is(err, target) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", target, err)
+}
+
 func getAllQry(bucket []byte) string {
-	return fmt.Sprintf("SELECT * FROM %s", quoteIdentifier(string(bucket)))
+	return fmt.Sprintf("SELECT nkey, nvalue FROM %s", quoteIdentifier(string(bucket)))
 }
 
 func getQry(bucket []byte) string {
 	return fmt.Sprintf("SELECT nvalue FROM %s WHERE nkey = $1;", quoteIdentifier(string(bucket)))
 }
 
+// getMultiQry returns the statement, and its arguments, that selects the
+// given keys of every bucket. The rows include the index of their bucket.
+func getMultiQry(buckets [][]byte, keys [][][]byte) (string, []interface{}) {
+	selects := make([]string, len(buckets))
+	args := make([]interface{}, len(buckets))
+	for i, bucket := range buckets {
+		selects[i] = fmt.Sprintf("SELECT %d, nkey, nvalue FROM %s WHERE nkey = ANY($%d)", i, quoteIdentifier(string(bucket)), i+1)
+		args[i] = keys[i]
+	}
+	return strings.Join(selects, " UNION ALL ") + ";", args
+}
+
 func getQryForUpdate(bucket []byte) string {
 	return fmt.Sprintf("SELECT nvalue FROM %s WHERE nkey = $1 FOR UPDATE;", quoteIdentifier(string(bucket)))
 }
 
+func getVersionedQry(bucket []byte) string {
+	return fmt.Sprintf("SELECT nvalue, nversion FROM %s WHERE nkey = $1;", quoteIdentifier(string(bucket)))
+}
+
+// The version of an updated entry is the new version, or the next one if the
+// clock went backwards.
 func insertUpdateQry(bucket []byte) string {
+	return fmt.Sprintf("INSERT INTO %s AS t (nkey, nvalue, nversion) VALUES ($1, $2, $3) ON CONFLICT (nkey) DO UPDATE SET nvalue = excluded.nvalue, nversion = GREATEST(t.nversion + 1, excluded.nversion);", quoteIdentifier(string(bucket)))
+}
+
+// insertUpdateUnversionedQry is insertUpdateQry for the tables created before
+// versions.
+func insertUpdateUnversionedQry(bucket []byte) string {
 	return fmt.Sprintf("INSERT INTO %s (nkey, nvalue) VALUES ($1, $2) ON CONFLICT (nkey) DO UPDATE SET nvalue = excluded.nvalue;", quoteIdentifier(string(bucket)))
 }
 
+func insertIfNotExistsQry(bucket []byte) string {
+	return fmt.Sprintf("INSERT INTO %s (nkey, nvalue, nversion) VALUES ($1, $2, $3) ON CONFLICT (nkey) DO NOTHING;", quoteIdentifier(string(bucket)))
+}
+
+// insertIfNotExistsUnversionedQry is insertIfNotExistsQry for the tables
+// created before versions.
+func insertIfNotExistsUnversionedQry(bucket []byte) string {
+	return fmt.Sprintf("INSERT INTO %s (nkey, nvalue) VALUES ($1, $2) ON CONFLICT (nkey) DO NOTHING;", quoteIdentifier(string(bucket)))
+}
+
+func updateIfVersionQry(bucket []byte) string {
+	return fmt.Sprintf("UPDATE %s SET nvalue = $1, nversion = GREATEST(nversion + 1, $2) WHERE nkey = $3 AND nversion = $4;", quoteIdentifier(string(bucket)))
+}
+
+// bulkLoadTable is the temporary table used to load entries with COPY FROM.
+const bulkLoadTable = "nosql_bulk_load"
+
+func createBulkTableQry(bucket []byte) string {
+	return fmt.Sprintf("CREATE TEMPORARY TABLE %s (LIKE %s) ON COMMIT DROP;", bulkLoadTable, quoteIdentifier(string(bucket)))
+}
+
+// mergeBulkTableQry returns the statement that merges the temporary table on
+// the given one, with the versions of the entries if the table is versioned.
+func mergeBulkTableQry(bucket []byte, versioned bool) string {
+	if !versioned {
+		return fmt.Sprintf("INSERT INTO %s AS t (nkey, nvalue) SELECT nkey, nvalue FROM %s ON CONFLICT (nkey) DO UPDATE SET nvalue = excluded.nvalue;", quoteIdentifier(string(bucket)), bulkLoadTable)
+	}
+	return fmt.Sprintf("INSERT INTO %s AS t (nkey, nvalue, nversion) SELECT nkey, nvalue, nversion FROM %s ON CONFLICT (nkey) DO UPDATE SET nvalue = excluded.nvalue, nversion = GREATEST(t.nversion + 1, excluded.nversion);", quoteIdentifier(string(bucket)), bulkLoadTable)
+}
+
+func listKeysQry(bucket []byte) string {
+	return fmt.Sprintf("SELECT nkey FROM %s ORDER BY nkey;", quoteIdentifier(string(bucket)))
+}
+
+func countQry(bucket []byte) string {
+	return fmt.Sprintf("SELECT COUNT(*) FROM %s;", quoteIdentifier(string(bucket)))
+}
+
 func delQry(bucket []byte) string {
 	return fmt.Sprintf("DELETE FROM %s WHERE nkey = $1;", quoteIdentifier(string(bucket)))
 }
 
 func createTableQry(bucket []byte) string {
-	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (nkey BYTEA CHECK (octet_length(nkey) <= 255), nvalue BYTEA, PRIMARY KEY (nkey));", quoteIdentifier(string(bucket)))
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (nkey BYTEA CHECK (octet_length(nkey) <= 255), nvalue BYTEA, nversion BIGINT NOT NULL DEFAULT 1, PRIMARY KEY (nkey));", quoteIdentifier(string(bucket)))
 }
 
-func deleteTableQry(bucket []byte) string {
-	return fmt.Sprintf("DROP TABLE %s;", quoteIdentifier(string(bucket)))
+// The entries of the tables created before versions have version 1.
+func addVersionColumnQry(bucket []byte) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS nversion BIGINT NOT NULL DEFAULT 1;", quoteIdentifier(string(bucket)))
+}
+
+func deleteTableQry(buckets ...[]byte) string {
+	names := make([]string, len(buckets))
+	for i, bucket := range buckets {
+		names[i] = quoteIdentifier(string(bucket))
+	}
+	return fmt.Sprintf("DROP TABLE %s;", strings.Join(names, ", "))
+}
+
+func truncateQry(bucket []byte) string {
+	return fmt.Sprintf("TRUNCATE TABLE %s;", quoteIdentifier(string(bucket)))
+}
+
+// deleteRangeQry returns the statement, and its arguments, that deletes the
+// keys in the range from start, inclusive, to end, exclusive.
+func deleteRangeQry(bucket, start, end []byte) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if start != nil {
+		args = append(args, start)
+		conds = append(conds, fmt.Sprintf("nkey >= $%d", len(args)))
+	}
+	if end != nil {
+		args = append(args, end)
+		conds = append(conds, fmt.Sprintf("nkey < $%d", len(args)))
+	}
+	qry := fmt.Sprintf("DELETE FROM %s", quoteIdentifier(string(bucket)))
+	if len(conds) > 0 {
+		qry += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return qry + ";", args
+}
+
+// copyTableQry returns the statement that copies the rows of a table, and
+// their versions if both tables are versioned.
+func copyTableQry(srcBucket, dstBucket []byte, versioned bool) string {
+	if !versioned {
+		return fmt.Sprintf("INSERT INTO %s (nkey, nvalue) SELECT nkey, nvalue FROM %s;", quoteIdentifier(string(dstBucket)), quoteIdentifier(string(srcBucket)))
+	}
+	return fmt.Sprintf("INSERT INTO %s (nkey, nvalue, nversion) SELECT nkey, nvalue, nversion FROM %s;", quoteIdentifier(string(dstBucket)), quoteIdentifier(string(srcBucket)))
+}
+
+func renameTableQry(oldBucket, newBucket []byte) string {
+	return fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", quoteIdentifier(string(oldBucket)), quoteIdentifier(string(newBucket)))
+}
+
+// The primary key index is named after the table, it must be renamed too, or
+// creating a new table with the old name would fail.
+const primaryKeyQry = `SELECT conname FROM pg_catalog.pg_constraint
+	WHERE conrelid = $1::regclass AND contype = 'p';`
+
+func renameIndexQry(oldIndex, newIndex string) string {
+	return fmt.Sprintf("ALTER INDEX %s RENAME TO %s;", quoteIdentifier(oldIndex), quoteIdentifier(newIndex))
+}
+
+const relationExistsQry = `SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_class c
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = current_schema() AND c.relname = $1);`
+
+// maxIdentifierLength is the maximum length in bytes of the identifiers on a
+// default PostgreSQL build, NAMEDATALEN - 1.
+const maxIdentifierLength = 63
+
+// primaryKeyName returns the n-th name PostgreSQL tries for the primary key
+// of a new table, "<table>_pkey" for n = 0, or "<table>_pkey<n>" otherwise,
+// with the name of the table truncated so the result fits in an identifier
+// without splitting a character.
+func primaryKeyName(bucket []byte, n int) string {
+	label := "_pkey"
+	if n > 0 {
+		label += strconv.Itoa(n)
+	}
+	name := bucket
+	if max := maxIdentifierLength - len(label); len(name) > max {
+		for max > 0 && !utf8.RuneStart(name[max]) {
+			max--
+		}
+		name = name[:max]
+	}
+	return string(name) + label
+}
+
+// The tables are created in the current schema, the first one of the
+// search_path that exists.
+const listTablesQry = `SELECT c.relname FROM pg_catalog.pg_class c
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p') AND c.relname <> '` + sequencesTable + `';`
+
+const tableExistsQry = `SELECT COUNT(*) FROM pg_catalog.pg_class c
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p') AND c.relname = $1 AND c.relname <> '` + sequencesTable + `';`
+
+// sequencesTable is the table with the sequences of the other tables, it's
+// created by the first NextSequence, and it's not listed by ListTables.
+const sequencesTable = database.SequencesTable
+
+const createSequencesTableQry = "CREATE TABLE IF NOT EXISTS " + sequencesTable + " (nbucket BYTEA, nvalue BIGINT NOT NULL, PRIMARY KEY (nbucket));"
+
+const sequencesTableExistsQry = `SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_class c
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = current_schema() AND c.relname = '` + sequencesTable + `');`
+
+const nextSequenceQry = "INSERT INTO " + sequencesTable + " AS t (nbucket, nvalue) VALUES ($1, 1) ON CONFLICT (nbucket) DO UPDATE SET nvalue = t.nvalue + 1 RETURNING nvalue;"
+
+const renameSequenceQry = "UPDATE " + sequencesTable + " SET nbucket = $1 WHERE nbucket = $2;"
+
+const copySequenceQry = "INSERT INTO " + sequencesTable + " (nbucket, nvalue) SELECT $1, nvalue FROM " + sequencesTable + " WHERE nbucket = $2;"
+
+func deleteSequencesQry(n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE nbucket IN (%s);", sequencesTable, strings.Join(params, ", "))
 }
 
 // Get retrieves the column/row with given key.
 func (db *DB) Get(bucket, key []byte) ([]byte, error) {
 	var val string
-	err := db.db.QueryRow(getQry(bucket), key).Scan(&val)
+	err := db.queryRow(db.db, &val, getQry(bucket), key)
 	switch {
 	case err == sql.ErrNoRows:
 		return nil, errors.Wrapf(database.ErrNotFound, "%s/%s not found", bucket, key)
@@ -145,29 +589,200 @@ func (db *DB) Get(bucket, key []byte) ([]byte, error) {
 	}
 }
 
+// GetMulti returns the values stored in the given bucket and keys using a
+// single query. The keys that do not exist are not in the result.
+func (db *DB) GetMulti(bucket []byte, keys [][]byte) (map[string][]byte, error) {
+	values, err := db.getMulti([][]byte{bucket}, [][][]byte{keys})
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// GetMultiBuckets returns the values stored in the given buckets and keys
+// using a single query.
+func (db *DB) GetMultiBuckets(keys map[string][][]byte) (map[string]map[string][]byte, error) {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buckets := make([][]byte, len(names))
+	bucketKeys := make([][][]byte, len(names))
+	for i, name := range names {
+		buckets[i], bucketKeys[i] = []byte(name), keys[name]
+	}
+	values, err := db.getMulti(buckets, bucketKeys)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]map[string][]byte, len(names))
+	for i, name := range names {
+		ret[name] = values[i]
+	}
+	return ret, nil
+}
+
+// getMulti returns the values stored in the given keys of every bucket.
+func (db *DB) getMulti(buckets [][]byte, keys [][][]byte) ([]map[string][]byte, error) {
+	values := make([]map[string][]byte, len(buckets))
+	for i := range buckets {
+		values[i] = make(map[string][]byte, len(keys[i]))
+	}
+	if len(buckets) == 0 {
+		return values, nil
+	}
+
+	qry, args := getMultiQry(buckets, keys)
+	rows, err := db.query(db.db, qry, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get keys of %s", bytes.Join(buckets, []byte(", ")))
+	}
+	defer rows.Close()
+	var (
+		i          int
+		key, value []byte
+	)
+	for rows.Next() {
+		if err := rows.Scan(&i, &key, &value); err != nil {
+			return nil, errors.Wrap(err, "error getting key and value from row")
+		}
+		values[i][string(key)] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(mapError(err), "error accessing row")
+	}
+	return values, nil
+}
+
 // Set inserts the key and value into the given bucket(column).
 func (db *DB) Set(bucket, key, value []byte) error {
-	_, err := db.db.Exec(insertUpdateQry(bucket), key, value)
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	if err := db.set(db.db, bucket, key, value); err != nil {
+		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	}
+	return nil
+}
+
+// set inserts or updates the given entry using conn, and its version if the
+// table is versioned.
+func (db *DB) set(conn sqlConn, bucket, key, value []byte) error {
+	versioned, err := db.isVersioned(conn, bucket)
+	switch {
+	case err != nil:
+		return err
+	case versioned:
+		_, err = db.exec(conn, insertUpdateQry(bucket), key, value, newVersion())
+	default:
+		_, err = db.exec(conn, insertUpdateUnversionedQry(bucket), key, value)
+	}
+	return err
+}
+
+// insertIfNotExists inserts the given entry using conn if the key does not
+// exist, and it returns whether it was inserted.
+func (db *DB) insertIfNotExists(conn sqlConn, bucket, key, value []byte) (bool, error) {
+	versioned, err := db.isVersioned(conn, bucket)
+	if err != nil {
+		return false, err
+	}
+	var res sql.Result
+	if versioned {
+		res, err = db.exec(conn, insertIfNotExistsQry(bucket), key, value, newVersion())
+	} else {
+		res, err = db.exec(conn, insertIfNotExistsUnversionedQry(bucket), key, value)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, errors.WithStack(err)
+}
+
+// GetVersioned retrieves the value and the version of the given bucket and
+// key.
+func (db *DB) GetVersioned(bucket, key []byte) ([]byte, uint64, error) {
+	if err := db.checkVersioned(bucket); err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	}
+	rows, err := db.query(db.db, getVersionedQry(bucket), key)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, 0, errors.Wrapf(mapError(err), "failed to get %s/%s", bucket, key)
+		}
+		return nil, 0, errors.Wrapf(database.ErrNotFound, "%s/%s not found", bucket, key)
+	}
+	var (
+		value   []byte
+		version uint64
+	)
+	if err := rows.Scan(&value, &version); err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, version, nil
+}
+
+// SetIfVersion inserts the key and value into the given bucket only if the
+// row has the given version, or if the version is 0 and the row does not
+// exist.
+func (db *DB) SetIfVersion(bucket, key, value []byte, version uint64) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	if err := db.checkVersioned(bucket); err != nil {
+		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	}
+
+	var (
+		res sql.Result
+		err error
+	)
+	if version == 0 {
+		res, err = db.exec(db.db, insertIfNotExistsQry(bucket), key, value, newVersion())
+	} else {
+		res, err = db.exec(db.db, updateIfVersionQry(bucket), value, newVersion(), key, version)
+	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
 	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	} else if n == 0 {
+		return errors.Wrapf(database.ErrVersionMismatch, "%s/%s does not have version %d", bucket, key, version)
+	}
 	return nil
 }
 
+// newVersion returns the version of a new entry, the current time in
+// microseconds, so an entry that is deleted and created again does not reuse
+// a version.
+func newVersion() int64 {
+	return time.Now().UnixMicro()
+}
+
 // Del deletes a row from the database.
 func (db *DB) Del(bucket, key []byte) error {
-	_, err := db.db.Exec(delQry(bucket), key)
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	_, err := db.exec(db.db, delQry(bucket), key)
 	return errors.Wrapf(err, "failed to delete %s/%s", bucket, key)
 }
 
 // List returns the full list of entries in a column.
 func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
-	rows, err := db.db.Query(getAllQry(bucket))
+	rows, err := db.query(db.db, getAllQry(bucket))
 	if err != nil {
-		estr := err.Error()
-		if strings.Contains(estr, "(SQLSTATE 42P01)") {
-			return nil, errors.Wrapf(database.ErrNotFound, estr)
-		}
 		return nil, errors.Wrapf(err, "error querying table %s", bucket)
 	}
 	defer rows.Close()
@@ -188,20 +803,172 @@ func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
 	}
 	err = rows.Err()
 	if err != nil {
-		return nil, errors.Wrap(err, "error accessing row")
+		return nil, errors.Wrap(mapError(err), "error accessing row")
 	}
 	return entries, nil
 }
 
+// ListKeys returns the keys of all the entries in a table, in order.
+func (db *DB) ListKeys(bucket []byte) ([][]byte, error) {
+	rows, err := db.query(db.db, listKeysQry(bucket))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error querying table %s", bucket)
+	}
+	defer rows.Close()
+	var keys [][]byte
+	for rows.Next() {
+		var key []byte
+		if err := rows.Scan(&key); err != nil {
+			return nil, errors.Wrap(err, "error getting key from row")
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(mapError(err), "error accessing row")
+	}
+	return keys, nil
+}
+
+// Count returns the number of entries in a table.
+func (db *DB) Count(bucket []byte) (int, error) {
+	var n int
+	if err := db.queryRow(db.db, &n, countQry(bucket)); err != nil {
+		return 0, errors.Wrapf(err, "error counting entries of table %s", bucket)
+	}
+	return n, nil
+}
+
+// BulkLoad writes the entries of the iterator in the given table. Each batch
+// is written with COPY FROM on a temporary table that is merged on the table,
+// COPY FROM cannot update existing keys.
+func (db *DB) BulkLoad(bucket []byte, iter database.Iterator, opts ...database.BulkOption) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	exists, err := db.TableExists(bucket)
+	switch {
+	case err != nil:
+		return err
+	case !exists:
+		return errors.Wrapf(database.ErrBucketNotFound, "table %s does not exist", bucket)
+	}
+
+	versioned, err := db.isVersioned(db.db, bucket)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.db.Conn(db.context())
+	if err != nil {
+		return errors.WithStack(mapError(err))
+	}
+	defer conn.Close()
+
+	o := database.NewBulkOptions(opts...)
+	return o.Run(iter, 0, func(batch []*database.Entry) error {
+		return conn.Raw(func(driverConn interface{}) error {
+			return db.copyFrom(driverConn.(*pgxstdlib.Conn).Conn(), bucket, batch, versioned)
+		})
+	})
+}
+
+// copyFrom writes the given entries in a transaction using COPY FROM, with
+// their version if the table is versioned.
+func (db *DB) copyFrom(conn *pgx.Conn, bucket []byte, batch []*database.Entry, versioned bool) error {
+	columns := []string{"nkey", "nvalue"}
+	if versioned {
+		columns = append(columns, "nversion")
+	}
+	// The merge cannot update the same row twice, only the last value of a
+	// key is copied.
+	version := newVersion()
+	index := make(map[string]int, len(batch))
+	rows := make([][]interface{}, 0, len(batch))
+	for _, e := range batch {
+		if i, ok := index[string(e.Key)]; ok {
+			rows[i][1] = e.Value
+			continue
+		}
+		index[string(e.Key)] = len(rows)
+		row := []interface{}{e.Key, e.Value}
+		if versioned {
+			row = append(row, version)
+		}
+		rows = append(rows, row)
+	}
+
+	ctx := db.context()
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(mapError(err))
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck // the rollback after a commit is a no-op
+
+	exec := func(query string) error {
+		ctx, done := database.StartStatement(ctx, query)
+		_, err := tx.Exec(ctx, query)
+		done(err)
+		return mapError(err)
+	}
+	if err := exec(createBulkTableQry(bucket)); err != nil {
+		return errors.Wrapf(err, "failed to load entries on table %s", bucket)
+	}
+	copyCtx, done := database.StartStatement(ctx, "COPY "+bulkLoadTable+" ("+strings.Join(columns, ", ")+") FROM STDIN")
+	_, err = tx.CopyFrom(copyCtx, pgx.Identifier{bulkLoadTable}, columns, pgx.CopyFromRows(rows))
+	done(err)
+	if err != nil {
+		return errors.Wrapf(mapError(err), "failed to load entries on table %s", bucket)
+	}
+	if err := exec(mergeBulkTableQry(bucket, versioned)); err != nil {
+		return errors.Wrapf(err, "failed to load entries on table %s", bucket)
+	}
+	return errors.Wrapf(mapError(tx.Commit(ctx)), "failed to load entries on table %s", bucket)
+}
+
+// Truncate deletes all the entries in a table, but keeps the table and the
+// tables nested in it.
+func (db *DB) Truncate(bucket []byte) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	if _, err := db.exec(db.db, truncateQry(bucket)); err != nil {
+		return errors.Wrapf(err, "failed to truncate table %s", bucket)
+	}
+	return nil
+}
+
+// DeleteRange deletes the entries in a table with a key in the range from
+// start, inclusive, to end, exclusive.
+func (db *DB) DeleteRange(bucket, start, end []byte) error {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return err
+	}
+	qry, args := deleteRangeQry(bucket, start, end)
+	if _, err := db.exec(db.db, qry, args...); err != nil {
+		return errors.Wrapf(err, "failed to delete range of table %s", bucket)
+	}
+	return nil
+}
+
+// DeletePrefix deletes the entries in a table with a key that starts with the
+// given prefix.
+func (db *DB) DeletePrefix(bucket, prefix []byte) error {
+	return db.DeleteRange(bucket, prefix, database.PrefixEnd(prefix))
+}
+
 // CmpAndSwap modifies the value at the given bucket and key (to newValue)
 // only if the existing (current) value matches oldValue.
 func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
-	sqlTx, err := db.db.Begin()
+	if err := db.autoCreateTables(bucket); err != nil {
+		return nil, false, err
+	}
+
+	sqlTx, err := db.begin()
 	if err != nil {
 		return nil, false, errors.WithStack(err)
 	}
 
-	val, swapped, err := cmpAndSwap(sqlTx, bucket, key, oldValue, newValue)
+	val, swapped, err := db.cmpAndSwap(sqlTx, bucket, key, oldValue, newValue)
 	switch {
 	case err != nil:
 		if err := sqlTx.Rollback(); err != nil {
@@ -210,7 +977,7 @@ func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool,
 		return nil, false, err
 	case swapped:
 		if err := sqlTx.Commit(); err != nil {
-			return nil, false, errors.Wrapf(err, "failed to commit PostgreSQL transaction")
+			return nil, false, errors.Wrapf(mapError(err), "failed to commit PostgreSQL transaction")
 		}
 		return val, swapped, nil
 	default:
@@ -221,9 +988,9 @@ func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool,
 	}
 }
 
-func cmpAndSwap(sqlTx *sql.Tx, bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+func (db *DB) cmpAndSwap(sqlTx *sql.Tx, bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
 	var current []byte
-	err := sqlTx.QueryRow(getQryForUpdate(bucket), key).Scan(&current)
+	err := db.queryRow(sqlTx, &current, getQryForUpdate(bucket), key)
 
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return nil, false, err
@@ -232,15 +999,160 @@ func cmpAndSwap(sqlTx *sql.Tx, bucket, key, oldValue, newValue []byte) ([]byte,
 		return current, false, nil
 	}
 
-	if _, err = sqlTx.Exec(insertUpdateQry(bucket), key, newValue); err != nil {
+	if err = db.set(sqlTx, bucket, key, newValue); err != nil {
 		return nil, false, errors.Wrapf(err, "failed to set %s/%s", bucket, key)
 	}
 	return newValue, true, nil
 }
 
+// Incr adds delta to the counter stored in the given bucket and key, and
+// returns the new value. The transaction is retried if it fails with
+// database.ErrConflict, a serialization failure if the default isolation level
+// of the server is serializable.
+func (db *DB) Incr(bucket, key []byte, delta int64) (n int64, err error) {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return 0, err
+	}
+	// A conflicting transaction is rolled back, it's safe to run it again.
+	err = database.RetryConflict(db.context(), func() (err error) {
+		n, err = db.incrTx(bucket, key, delta)
+		return err
+	})
+	return n, errors.Wrapf(err, "failed to increment %s/%s", bucket, key)
+}
+
+// incrTx runs incr on its own transaction.
+func (db *DB) incrTx(bucket, key []byte, delta int64) (int64, error) {
+	sqlTx, err := db.begin()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	n, err := db.incr(sqlTx, bucket, key, delta)
+	if err != nil {
+		if err := sqlTx.Rollback(); err != nil {
+			return 0, errors.Wrap(err, "failed to rollback transaction")
+		}
+		return 0, err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return 0, errors.Wrap(mapError(err), "failed to commit PostgreSQL transaction")
+	}
+	return n, nil
+}
+
+// incr locks the stored value and checks that it's a counter before
+// overwriting it, so the values are parsed like on the other drivers. A key
+// inserted by a concurrent transaction after the lock is read again, the
+// statements of a read committed transaction see the committed rows.
+func (db *DB) incr(conn sqlConn, bucket, key []byte, delta int64) (int64, error) {
+	for attempt := 0; ; attempt++ {
+		var current []byte
+		err := db.queryRow(conn, &current, getQryForUpdate(bucket), key)
+		switch {
+		case err == nil:
+			n, err := database.AddCounter(current, delta)
+			if err != nil {
+				return 0, err
+			}
+			if err := db.set(conn, bucket, key, database.FormatCounter(n)); err != nil {
+				return 0, err
+			}
+			return n, nil
+		case !errors.Is(err, sql.ErrNoRows):
+			return 0, err
+		case attempt > 0:
+			return 0, errors.Wrapf(database.ErrConflict, "key %s/%s was deleted by a concurrent transaction", bucket, key)
+		}
+
+		n, err := database.AddCounter(nil, delta)
+		if err != nil {
+			return 0, err
+		}
+		if inserted, err := db.insertIfNotExists(conn, bucket, key, database.FormatCounter(n)); err != nil || inserted {
+			return n, err
+		}
+	}
+}
+
+// NextSequence returns the next number of the sequence of the given table,
+// using a single statement on the sequences table.
+func (db *DB) NextSequence(bucket []byte) (uint64, error) {
+	if err := db.autoCreateTables(bucket); err != nil {
+		return 0, err
+	}
+	if err := db.createSequencesTable(); err != nil {
+		return 0, err
+	}
+	return db.nextSequence(db.db, bucket)
+}
+
+// createSequencesTable creates the sequences table if it's not known to
+// exist. It runs outside of the transactions that use the table, so a
+// rollback does not delete it.
+func (db *DB) createSequencesTable() error {
+	if db.sequences.Load() {
+		return nil
+	}
+	if _, err := db.exec(db.db, createSequencesTableQry); err != nil {
+		return errors.Wrap(err, "failed to create sequences table")
+	}
+	db.sequences.Store(true)
+	return nil
+}
+
+// hasSequencesTable returns true if the sequences table exists. The
+// operations that update the sequences of other tables do nothing if it does
+// not exist.
+func (db *DB) hasSequencesTable(conn sqlConn) (bool, error) {
+	if db.sequences.Load() {
+		return true, nil
+	}
+	var exists bool
+	if err := db.queryRow(conn, &exists, sequencesTableExistsQry); err != nil {
+		return false, errors.Wrap(err, "failed to check sequences table")
+	}
+	if exists {
+		db.sequences.Store(true)
+	}
+	return exists, nil
+}
+
+func (db *DB) nextSequence(conn sqlConn, bucket []byte) (uint64, error) {
+	var n int
+	if err := db.queryRow(conn, &n, tableExistsQry, string(bucket)); err != nil {
+		return 0, errors.Wrapf(err, "failed to check table %s", bucket)
+	}
+	if n == 0 {
+		return 0, errors.Wrapf(database.ErrBucketNotFound, "table %s does not exist", bucket)
+	}
+	var seq uint64
+	if err := db.queryRow(conn, &seq, nextSequenceQry, bucket); err != nil {
+		return 0, errors.Wrapf(err, "failed to get next sequence of table %s", bucket)
+	}
+	return seq, nil
+}
+
 // Update performs multiple commands on one read-write transaction.
 func (db *DB) Update(tx *database.Tx) error {
-	sqlTx, err := db.db.Begin()
+	var buckets [][]byte
+	for _, q := range tx.Operations {
+		if q.Cmd.IsWrite() {
+			buckets = append(buckets, q.Bucket)
+		}
+	}
+	if err := db.autoCreateTables(buckets...); err != nil {
+		return err
+	}
+	for _, q := range tx.Operations {
+		if q.Cmd == database.NextSequence {
+			if err := db.createSequencesTable(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	sqlTx, err := db.begin()
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -254,22 +1166,16 @@ func (db *DB) Update(tx *database.Tx) error {
 		// create or delete buckets
 		switch q.Cmd {
 		case database.CreateTable:
-			_, err := sqlTx.Exec(createTableQry(q.Bucket))
-			if err != nil {
-				return rollback(errors.Wrapf(err, "failed to create table %s", q.Bucket))
+			if err := db.createTable(sqlTx, q.Bucket); err != nil {
+				return rollback(err)
 			}
 		case database.DeleteTable:
-			_, err := sqlTx.Exec(deleteTableQry(q.Bucket))
-			if err != nil {
-				estr := err.Error()
-				if strings.Contains(estr, "(SQLSTATE 42P01)") {
-					return errors.Wrapf(database.ErrNotFound, estr)
-				}
-				return errors.Wrapf(err, "failed to delete table %s", q.Bucket)
+			if err := db.deleteTable(sqlTx, q.Bucket); err != nil {
+				return rollback(err)
 			}
 		case database.Get:
 			var val string
-			err := sqlTx.QueryRow(getQry(q.Bucket), q.Key).Scan(&val)
+			err := db.queryRow(sqlTx, &val, getQry(q.Bucket), q.Key)
 			switch {
 			case err == sql.ErrNoRows:
 				return rollback(errors.Wrapf(database.ErrNotFound, "%s/%s not found", q.Bucket, q.Key))
@@ -279,49 +1185,377 @@ func (db *DB) Update(tx *database.Tx) error {
 				q.Result = []byte(val)
 			}
 		case database.Set:
-			if _, err = sqlTx.Exec(insertUpdateQry(q.Bucket), q.Key, q.Value); err != nil {
+			if err = db.set(sqlTx, q.Bucket, q.Key, q.Value); err != nil {
 				return rollback(errors.Wrapf(err, "failed to set %s/%s", q.Bucket, q.Key))
 			}
 		case database.Delete:
-			if _, err = sqlTx.Exec(delQry(q.Bucket), q.Key); err != nil {
+			if _, err = db.exec(sqlTx, delQry(q.Bucket), q.Key); err != nil {
 				return rollback(errors.Wrapf(err, "failed to delete %s/%s", q.Bucket, q.Key))
 			}
 		case database.CmpAndSwap:
-			q.Result, q.Swapped, err = cmpAndSwap(sqlTx, q.Bucket, q.Key, q.CmpValue, q.Value)
+			q.Result, q.Swapped, err = db.cmpAndSwap(sqlTx, q.Bucket, q.Key, q.CmpValue, q.Value)
 			if err != nil {
 				return rollback(errors.Wrapf(err, "failed to load-or-store %s/%s", q.Bucket, q.Key))
 			}
+		case database.Truncate:
+			if _, err = db.exec(sqlTx, truncateQry(q.Bucket)); err != nil {
+				return rollback(errors.Wrapf(err, "failed to truncate table %s", q.Bucket))
+			}
+		case database.DeleteRange, database.DeletePrefix:
+			start, end := deleteRange(q)
+			qry, args := deleteRangeQry(q.Bucket, start, end)
+			if _, err = db.exec(sqlTx, qry, args...); err != nil {
+				return rollback(errors.Wrapf(err, "failed to delete entries of table %s", q.Bucket))
+			}
+		case database.Incr:
+			if q.Counter, err = db.incr(sqlTx, q.Bucket, q.Key, q.Delta); err != nil {
+				return rollback(errors.Wrapf(err, "failed to increment %s/%s", q.Bucket, q.Key))
+			}
+		case database.NextSequence:
+			if q.Sequence, err = db.nextSequence(sqlTx, q.Bucket); err != nil {
+				return rollback(err)
+			}
 		case database.CmpOrRollback:
-			return database.ErrOpNotSupported
+			return rollback(errors.WithStack(database.ErrOpNotSupported))
 		default:
-			return database.ErrOpNotSupported
+			return rollback(errors.WithStack(database.ErrOpNotSupported))
 		}
 	}
 
-	if err = errors.WithStack(sqlTx.Commit()); err != nil {
+	if err = errors.WithStack(mapError(sqlTx.Commit())); err != nil {
 		return rollback(err)
 	}
 	return nil
 }
 
-// CreateTable creates a table in the database.
-func (db *DB) CreateTable(bucket []byte) error {
-	_, err := db.db.Exec(createTableQry(bucket))
+// View performs multiple read commands on one read-only transaction, all of
+// them reading the same snapshot of the database.
+func (db *DB) View(tx *database.Tx) error {
+	if err := tx.CheckReadOnly(); err != nil {
+		return err
+	}
+
+	sqlTx, err := db.beginReadOnly()
 	if err != nil {
-		return errors.Wrapf(err, "failed to create table %s", bucket)
+		return errors.WithStack(err)
+	}
+	rollback := func(err error) error {
+		if rollbackErr := sqlTx.Rollback(); rollbackErr != nil {
+			return errors.Wrap(err, "VIEW failed, unable to rollback transaction")
+		}
+		return errors.Wrap(err, "VIEW failed")
+	}
+	for _, q := range tx.Operations {
+		switch q.Cmd {
+		case database.Get:
+			var val string
+			err := db.queryRow(sqlTx, &val, getQry(q.Bucket), q.Key)
+			switch {
+			case err == sql.ErrNoRows:
+				return rollback(errors.Wrapf(database.ErrNotFound, "%s/%s not found", q.Bucket, q.Key))
+			case err != nil:
+				return rollback(errors.Wrapf(err, "failed to get %s/%s", q.Bucket, q.Key))
+			default:
+				q.Result = []byte(val)
+			}
+		default:
+			return rollback(errors.WithStack(database.ErrOpNotSupported))
+		}
+	}
+
+	if err = errors.WithStack(mapError(sqlTx.Commit())); err != nil {
+		return rollback(err)
 	}
 	return nil
 }
 
-// DeleteTable deletes a table in the database.
+// autoCreateTables creates the given tables if the database was opened with
+// database.WithAutoCreateTables. The tables are created before starting any
+// transaction, as some servers commit the current transaction on DDL
+// statements.
+func (db *DB) autoCreateTables(buckets ...[]byte) error {
+	if !db.autoCreate {
+		return nil
+	}
+	created := make(map[string]bool, len(buckets))
+	for _, bucket := range buckets {
+		if created[string(bucket)] {
+			continue
+		}
+		if err := db.createTable(db.db, bucket); err != nil {
+			return err
+		}
+		created[string(bucket)] = true
+	}
+	return nil
+}
+
+// CreateTable creates a table in the database, and the tables of its parents
+// if it's a nested bucket.
+func (db *DB) CreateTable(bucket []byte) error {
+	return db.createTable(db.db, bucket)
+}
+
+// DeleteTable deletes a table, and the tables nested in it, in the database.
 func (db *DB) DeleteTable(bucket []byte) error {
-	_, err := db.db.Exec(deleteTableQry(bucket))
+	return db.deleteTable(db.db, bucket)
+}
+
+// RenameTable renames a table, and the tables nested in it, in one
+// transaction. The parents of the new table are created first if necessary.
+func (db *DB) RenameTable(oldBucket, newBucket []byte) error {
+	if bytes.Equal(oldBucket, newBucket) || database.IsNestedBucket(newBucket, oldBucket) {
+		return errors.Wrapf(database.ErrInvalidKey, "cannot rename table %s to %s", oldBucket, newBucket)
+	}
+	if err := database.ValidateBucket(newBucket); err != nil {
+		return err
+	}
+
+	sqlTx, err := db.begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := db.renameTable(sqlTx, oldBucket, newBucket); err != nil {
+		if rollbackErr := sqlTx.Rollback(); rollbackErr != nil {
+			return errors.Wrap(err, "failed to rename table, unable to rollback transaction")
+		}
+		return err
+	}
+	return errors.Wrap(mapError(sqlTx.Commit()), "failed to commit PostgreSQL transaction")
+}
+
+// CopyTable copies a table, and the tables nested in it, to a new table in one
+// transaction. The parents of the new table are created first if necessary.
+func (db *DB) CopyTable(srcBucket, dstBucket []byte) error {
+	if bytes.Equal(srcBucket, dstBucket) || database.IsNestedBucket(dstBucket, srcBucket) {
+		return errors.Wrapf(database.ErrInvalidKey, "cannot copy table %s to %s", srcBucket, dstBucket)
+	}
+	if err := database.ValidateBucket(dstBucket); err != nil {
+		return err
+	}
+
+	sqlTx, err := db.begin()
 	if err != nil {
-		estr := err.Error()
-		if strings.Contains(estr, "(SQLSTATE 42P01)") {
-			return errors.Wrapf(database.ErrNotFound, estr)
+		return errors.WithStack(err)
+	}
+	if err := db.copyTable(sqlTx, srcBucket, dstBucket); err != nil {
+		if rollbackErr := sqlTx.Rollback(); rollbackErr != nil {
+			return errors.Wrap(err, "failed to copy table, unable to rollback transaction")
+		}
+		return err
+	}
+	return errors.Wrap(mapError(sqlTx.Commit()), "failed to commit PostgreSQL transaction")
+}
+
+// createTable creates a table, and the tables of its parents, using conn.
+func (db *DB) createTable(conn sqlConn, bucket []byte) error {
+	if err := database.ValidateBucket(bucket); err != nil {
+		return err
+	}
+	for _, table := range append(database.ParentBuckets(bucket), bucket) {
+		if _, err := db.exec(conn, createTableQry(table)); err != nil {
+			return errors.Wrapf(err, "failed to create table %s", table)
 		}
+	}
+	return nil
+}
+
+// deleteTable deletes a table, and the tables nested in it, using a single
+// DROP TABLE statement on conn.
+func (db *DB) deleteTable(conn sqlConn, bucket []byte) error {
+	tables, err := db.listTables(conn)
+	if err != nil {
+		return err
+	}
+	// A missing table is reported by the server.
+	buckets := [][]byte{bucket}
+	if containsTable(tables, bucket) {
+		buckets = append(buckets, database.NestedBuckets(tables, bucket)...)
+	}
+	defer db.forgetVersioned(buckets...)
+	if _, err := db.exec(conn, deleteTableQry(buckets...)); err != nil {
 		return errors.Wrapf(err, "failed to delete table %s", bucket)
 	}
+	if ok, err := db.hasSequencesTable(conn); err != nil || !ok {
+		return err
+	}
+	args := make([]interface{}, len(buckets))
+	for i, bucket := range buckets {
+		args[i] = bucket
+	}
+	if _, err := db.exec(conn, deleteSequencesQry(len(buckets)), args...); err != nil {
+		return errors.Wrapf(err, "failed to delete sequences of table %s", bucket)
+	}
 	return nil
 }
+
+// renameTable renames a table, the tables nested in it, and their primary
+// keys in the given transaction.
+func (db *DB) renameTable(sqlTx *sql.Tx, oldBucket, newBucket []byte) error {
+	tables, err := db.listTables(sqlTx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !containsTable(tables, oldBucket):
+		return errors.Wrapf(database.ErrBucketNotFound, "table %s does not exist", oldBucket)
+	case containsTable(tables, newBucket):
+		return errors.Wrapf(database.ErrBucketExists, "table %s already exists", newBucket)
+	}
+
+	if parents := database.ParentBuckets(newBucket); len(parents) > 0 {
+		if err := db.createTable(sqlTx, parents[len(parents)-1]); err != nil {
+			return err
+		}
+	}
+	hasSequences, err := db.hasSequencesTable(sqlTx)
+	if err != nil {
+		return err
+	}
+	var renamedTables [][]byte
+	defer func() { db.forgetVersioned(renamedTables...) }()
+	for _, table := range append([][]byte{oldBucket}, database.NestedBuckets(tables, oldBucket)...) {
+		renamed := append(append([]byte{}, newBucket...), table[len(oldBucket):]...)
+		renamedTables = append(renamedTables, table, renamed)
+		var index string
+		if err := db.queryRow(sqlTx, &index, primaryKeyQry, quoteIdentifier(string(table))); err != nil {
+			return errors.Wrapf(err, "failed to get primary key of table %s", table)
+		}
+		if _, err := db.exec(sqlTx, renameTableQry(table, renamed)); err != nil {
+			return errors.Wrapf(err, "failed to rename table %s to %s", table, renamed)
+		}
+		if err := db.renameIndex(sqlTx, index, renamed); err != nil {
+			return errors.Wrapf(err, "failed to rename primary key of table %s", table)
+		}
+		if !hasSequences {
+			continue
+		}
+		if _, err := db.exec(sqlTx, renameSequenceQry, renamed, table); err != nil {
+			return errors.Wrapf(err, "failed to rename sequence of table %s", table)
+		}
+	}
+	return nil
+}
+
+// renameIndex renames the primary key index of a renamed table to the name
+// PostgreSQL would give to the primary key of a new table with that name, the
+// first one of the primaryKeyName candidates that is not taken.
+func (db *DB) renameIndex(sqlTx *sql.Tx, index string, bucket []byte) error {
+	for n := 0; ; n++ {
+		name := primaryKeyName(bucket, n)
+		if name == index {
+			return nil
+		}
+		var exists bool
+		if err := db.queryRow(sqlTx, &exists, relationExistsQry, name); err != nil {
+			return err
+		}
+		if !exists {
+			_, err := db.exec(sqlTx, renameIndexQry(index, name))
+			return err
+		}
+	}
+}
+
+// copyTable creates a table, and the tables nested in it, with the rows of the
+// given tables in the given transaction.
+func (db *DB) copyTable(sqlTx *sql.Tx, srcBucket, dstBucket []byte) error {
+	tables, err := db.listTables(sqlTx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !containsTable(tables, srcBucket):
+		return errors.Wrapf(database.ErrBucketNotFound, "table %s does not exist", srcBucket)
+	case containsTable(tables, dstBucket):
+		return errors.Wrapf(database.ErrBucketExists, "table %s already exists", dstBucket)
+	}
+
+	hasSequences, err := db.hasSequencesTable(sqlTx)
+	if err != nil {
+		return err
+	}
+	for _, table := range append([][]byte{srcBucket}, database.NestedBuckets(tables, srcBucket)...) {
+		copied := append(append([]byte{}, dstBucket...), table[len(srcBucket):]...)
+		if err := db.createTable(sqlTx, copied); err != nil {
+			return err
+		}
+		versioned, err := db.isVersioned(sqlTx, table)
+		if err != nil {
+			return err
+		}
+		if _, err = db.exec(sqlTx, copyTableQry(table, copied, versioned)); err != nil {
+			return errors.Wrapf(err, "failed to copy table %s to %s", table, copied)
+		}
+		if !hasSequences {
+			continue
+		}
+		if _, err := db.exec(sqlTx, copySequenceQry, copied, table); err != nil {
+			return errors.Wrapf(err, "failed to copy sequence of table %s", table)
+		}
+	}
+	return nil
+}
+
+// ListTables returns the names of the tables in the current schema.
+func (db *DB) ListTables() ([][]byte, error) {
+	return db.listTables(db.db)
+}
+
+// listTables returns the names of the tables in the current schema using
+// conn.
+func (db *DB) listTables(conn sqlConn) ([][]byte, error) {
+	rows, err := db.query(conn, listTablesQry)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing tables")
+	}
+	defer rows.Close()
+	var tables [][]byte
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "error getting table name from row")
+		}
+		tables = append(tables, []byte(name))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(mapError(err), "error accessing row")
+	}
+	// ORDER BY would depend on the collation of the database.
+	sort.Slice(tables, func(i, j int) bool {
+		return bytes.Compare(tables[i], tables[j]) < 0
+	})
+	return tables, nil
+}
+
+// TableExists returns true if the table exists in the current schema.
+func (db *DB) TableExists(bucket []byte) (bool, error) {
+	var n int
+	if err := db.queryRow(db.db, &n, tableExistsQry, string(bucket)); err != nil {
+		return false, errors.Wrapf(err, "failed to check table %s", bucket)
+	}
+	return n > 0, nil
+}
+
+// containsTable returns true if tables contains bucket.
+func containsTable(tables [][]byte, bucket []byte) bool {
+	for _, table := range tables {
+		if bytes.Equal(table, bucket) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteRange returns the range of keys deleted by the DeleteRange or
+// DeletePrefix commands.
+func deleteRange(q *database.TxEntry) (start, end []byte) {
+	switch q.Cmd {
+	case database.DeleteRange:
+		return q.Key, q.End
+	case database.DeletePrefix:
+		return q.Key, database.PrefixEnd(q.Key)
+	default:
+		return nil, nil
+	}
+}
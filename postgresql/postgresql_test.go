@@ -0,0 +1,37 @@
+//go:build !nopgx
+// +build !nopgx
+
+package postgresql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestPrimaryKeyName(t *testing.T) {
+	long := strings.Repeat("a", 60)
+	tests := []struct {
+		name   string
+		bucket string
+		n      int
+		want   string
+	}{
+		{"short", "bucket", 0, "bucket_pkey"},
+		{"short-n", "bucket", 2, "bucket_pkey2"},
+		{"nested", "parent/child", 0, "parent/child_pkey"},
+		{"limit", strings.Repeat("a", 58), 0, strings.Repeat("a", 58) + "_pkey"},
+		{"long", long, 0, strings.Repeat("a", 58) + "_pkey"},
+		{"long-n", long, 1, strings.Repeat("a", 57) + "_pkey1"},
+		{"long-n10", long, 10, strings.Repeat("a", 56) + "_pkey10"},
+		{"multibyte", strings.Repeat("a", 57) + "ñbc", 0, strings.Repeat("a", 57) + "_pkey"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := primaryKeyName([]byte(tt.bucket), tt.n)
+			assert.Equals(t, tt.want, got)
+			assert.True(t, len(got) <= maxIdentifierLength)
+		})
+	}
+}
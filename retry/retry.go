@@ -0,0 +1,382 @@
+// Package retry implements a database.DB decorator that retries the write
+// operations failing with a transient error using an exponential backoff
+// with jitter.
+package retry
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/smallstep/nosql/database"
+)
+
+// Default retry policy.
+const (
+	DefaultMaxAttempts = 5
+	DefaultBaseDelay   = 10 * time.Millisecond
+	DefaultMaxDelay    = time.Second
+)
+
+// Classifier reports whether an error is transient, and the operation that
+// returned it can be retried.
+type Classifier func(err error) bool
+
+type options struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	classifier  Classifier
+}
+
+// Option is the modifier type used to configure the retry decorator.
+type Option func(o *options)
+
+// WithMaxAttempts sets the maximum number of times an operation is run,
+// including the first one. It defaults to DefaultMaxAttempts.
+func WithMaxAttempts(n int) Option {
+	return func(o *options) {
+		o.maxAttempts = n
+	}
+}
+
+// WithBackoff sets the base and the maximum delay between attempts. The n-th
+// retry waits a random duration between 0 and min(maxDelay, base * 2^n).
+func WithBackoff(base, maxDelay time.Duration) Option {
+	return func(o *options) {
+		o.baseDelay = base
+		o.maxDelay = maxDelay
+	}
+}
+
+// WithClassifier sets the function used to decide if an error is transient.
+// It defaults to IsTransient.
+func WithClassifier(fn Classifier) Option {
+	return func(o *options) {
+		o.classifier = fn
+	}
+}
+
+// DB is a database.DB that retries the Open, Set, CmpAndSwap, NextSequence,
+// Update and View operations of the wrapped database when they fail with a
+// transient error.
+type DB struct {
+	db          database.DB
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	isTransient Classifier
+	ctx         context.Context
+}
+
+// Wrap returns a DB that retries the write operations run on db.
+func Wrap(db database.DB, opts ...Option) *DB {
+	o := &options{
+		maxAttempts: DefaultMaxAttempts,
+		baseDelay:   DefaultBaseDelay,
+		maxDelay:    DefaultMaxDelay,
+		classifier:  IsTransient,
+	}
+	for _, fn := range opts {
+		fn(o)
+	}
+	if o.maxAttempts < 1 {
+		o.maxAttempts = 1
+	}
+	return &DB{
+		db:          db,
+		maxAttempts: o.maxAttempts,
+		baseDelay:   o.baseDelay,
+		maxDelay:    o.maxDelay,
+		isTransient: o.classifier,
+	}
+}
+
+// Middleware returns a database.Middleware that wraps a database with Wrap.
+func Middleware(opts ...Option) database.Middleware {
+	return func(db database.DB) database.DB {
+		return Wrap(db, opts...)
+	}
+}
+
+// Unwrap returns the wrapped database.
+func (w *DB) Unwrap() database.DB {
+	return w.db
+}
+
+// WithContext returns a shallow copy of the database with the wrapped
+// database bound to ctx, if it's a database.ContextBinder. The retries stop
+// waiting and fail with the error of ctx when ctx is done.
+func (w *DB) WithContext(ctx context.Context) database.DB {
+	c := *w
+	c.ctx = ctx
+	if b, ok := w.db.(database.ContextBinder); ok {
+		c.db = b.WithContext(ctx)
+	}
+	return &c
+}
+
+// Open opens the wrapped database, retrying on transient errors, like the
+// timeout waiting for the lock of a bolt database.
+func (w *DB) Open(dataSourceName string, opt ...database.Option) error {
+	return w.do(func() error {
+		return w.db.Open(dataSourceName, opt...)
+	})
+}
+
+// Close closes the wrapped database.
+func (w *DB) Close() error {
+	return w.db.Close()
+}
+
+// Get returns the value stored in the given bucket and key.
+func (w *DB) Get(bucket, key []byte) ([]byte, error) {
+	return w.db.Get(bucket, key)
+}
+
+// GetVersioned returns the value and the version stored in the given bucket
+// and key, if the wrapped database implements database.Versioned.
+func (w *DB) GetVersioned(bucket, key []byte) ([]byte, uint64, error) {
+	return database.GetVersioned(w.db, bucket, key)
+}
+
+// GetMulti returns the values stored in the given bucket and keys, if the
+// wrapped database implements database.MultiGetter.
+func (w *DB) GetMulti(bucket []byte, keys [][]byte) (map[string][]byte, error) {
+	return database.GetMulti(w.db, bucket, keys)
+}
+
+// GetMultiBuckets returns the values stored in the given buckets and keys, if
+// the wrapped database implements database.MultiGetter.
+func (w *DB) GetMultiBuckets(keys map[string][][]byte) (map[string]map[string][]byte, error) {
+	return database.GetMultiBuckets(w.db, keys)
+}
+
+// Set stores the given value on bucket and key, retrying on transient
+// errors.
+func (w *DB) Set(bucket, key, value []byte) error {
+	return w.do(func() error {
+		return w.db.Set(bucket, key, value)
+	})
+}
+
+// CmpAndSwap modifies the value at the given bucket and key (to newValue)
+// only if the existing (current) value matches oldValue, retrying on
+// transient errors.
+//
+// Note that if a transient error hides a successful commit, the retried
+// operation will see newValue as the current value and report that the swap
+// did not happen.
+func (w *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) (ret []byte, swapped bool, err error) {
+	err = w.do(func() (err error) {
+		ret, swapped, err = w.db.CmpAndSwap(bucket, key, oldValue, newValue)
+		return
+	})
+	return
+}
+
+// SetIfVersion stores the given value on bucket and key only if the stored
+// entry has the given version, if the wrapped database implements
+// database.Versioned. It is not retried because a transient error might hide
+// a successful commit that changed the version.
+func (w *DB) SetIfVersion(bucket, key, value []byte, version uint64) error {
+	return database.SetIfVersion(w.db, bucket, key, value, version)
+}
+
+// Incr adds delta to the counter stored in the given bucket and key, if the
+// wrapped database implements database.Counter. It is not retried because a
+// transient error might hide a successful commit, and the retry would add
+// delta again.
+func (w *DB) Incr(bucket, key []byte, delta int64) (int64, error) {
+	return database.IncrCounter(w.db, bucket, key, delta)
+}
+
+// NextSequence returns the next number of the sequence of a bucket, if the
+// wrapped database implements database.Counter, retrying on transient errors.
+// A retry might leave a gap in the sequence.
+func (w *DB) NextSequence(bucket []byte) (seq uint64, err error) {
+	err = w.do(func() (err error) {
+		seq, err = database.NextTableSequence(w.db, bucket)
+		return
+	})
+	return
+}
+
+// Del deletes the value stored in the given bucket and key.
+func (w *DB) Del(bucket, key []byte) error {
+	return w.db.Del(bucket, key)
+}
+
+// BulkLoad writes the entries of the iterator in a bucket, if the wrapped
+// database implements database.BulkLoader. It is not retried because the
+// iterator cannot be replayed.
+func (w *DB) BulkLoad(bucket []byte, iter database.Iterator, opts ...database.BulkOption) error {
+	return database.BulkLoad(w.db, bucket, iter, opts...)
+}
+
+// Truncate deletes all the entries in a bucket of the wrapped database if it
+// implements database.RangeDeleter.
+func (w *DB) Truncate(bucket []byte) error {
+	return database.TruncateTable(w.db, bucket)
+}
+
+// DeleteRange deletes the entries in a bucket with a key in the range from
+// start, inclusive, to end, exclusive, if the wrapped database implements
+// database.RangeDeleter.
+func (w *DB) DeleteRange(bucket, start, end []byte) error {
+	return database.DeleteKeyRange(w.db, bucket, start, end)
+}
+
+// DeletePrefix deletes the entries in a bucket with a key that starts with
+// the given prefix, if the wrapped database implements database.RangeDeleter.
+func (w *DB) DeletePrefix(bucket, prefix []byte) error {
+	return database.DeleteKeyPrefix(w.db, bucket, prefix)
+}
+
+// List returns the full list of entries in a bucket.
+func (w *DB) List(bucket []byte) ([]*database.Entry, error) {
+	return w.db.List(bucket)
+}
+
+// ListKeys returns the keys of all the entries in a bucket, if the wrapped
+// database implements database.KeyLister.
+func (w *DB) ListKeys(bucket []byte) ([][]byte, error) {
+	return database.ListKeys(w.db, bucket)
+}
+
+// Count returns the number of entries in a bucket, if the wrapped database
+// implements database.KeyLister.
+func (w *DB) Count(bucket []byte) (int, error) {
+	return database.Count(w.db, bucket)
+}
+
+// Update performs multiple commands on one read-write transaction, retrying
+// the whole transaction on transient errors. Like Incr, the transactions with
+// Incr commands are not retried, because the retry might add their deltas
+// again.
+func (w *DB) Update(tx *database.Tx) error {
+	if hasIncr(tx) {
+		return w.db.Update(tx)
+	}
+	return w.do(func() error {
+		return w.db.Update(tx)
+	})
+}
+
+// View performs multiple read commands on one read-only transaction, if the
+// wrapped database implements database.Viewer, retrying the whole transaction
+// on transient errors.
+func (w *DB) View(tx *database.Tx) error {
+	return w.do(func() error {
+		return database.View(w.db, tx)
+	})
+}
+
+// CreateTable creates a table or a bucket in the wrapped database.
+func (w *DB) CreateTable(bucket []byte) error {
+	return w.db.CreateTable(bucket)
+}
+
+// DeleteTable deletes a table or a bucket in the wrapped database.
+func (w *DB) DeleteTable(bucket []byte) error {
+	return w.db.DeleteTable(bucket)
+}
+
+// RenameTable renames a table or a bucket in the wrapped database if it
+// implements database.TableRenamer.
+func (w *DB) RenameTable(oldBucket, newBucket []byte) error {
+	return database.RenameTable(w.db, oldBucket, newBucket)
+}
+
+// CopyTable copies a table or a bucket in the wrapped database if it
+// implements database.TableCopier.
+func (w *DB) CopyTable(srcBucket, dstBucket []byte) error {
+	return database.CopyTable(w.db, srcBucket, dstBucket)
+}
+
+// ListTables returns the tables or buckets in the wrapped database if it
+// implements database.TableLister.
+func (w *DB) ListTables() ([][]byte, error) {
+	return database.ListTables(w.db)
+}
+
+// TableExists returns true if the table or bucket exists in the wrapped
+// database if it implements database.TableLister.
+func (w *DB) TableExists(bucket []byte) (bool, error) {
+	return database.TableExists(w.db, bucket)
+}
+
+// Compact triggers a value log garbage collection on the wrapped database if
+// it implements database.Compactor.
+func (w *DB) Compact(discardRatio float64) error {
+	return database.Compact(w.db, discardRatio)
+}
+
+// hasIncr returns true if the transaction has Incr commands.
+func hasIncr(tx *database.Tx) bool {
+	for _, op := range tx.Operations {
+		if op.Cmd == database.Incr {
+			return true
+		}
+	}
+	return false
+}
+
+// do runs fn until it succeeds, it fails with a non transient error, the
+// maximum number of attempts is reached, or the bound context is done.
+func (w *DB) do(fn func() error) (err error) {
+	for attempt := 0; attempt < w.maxAttempts; attempt++ {
+		if attempt > 0 {
+			if err := w.wait(w.backoff(attempt - 1)); err != nil {
+				return err
+			}
+		}
+		if err = fn(); err == nil || !w.isTransient(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// wait waits for the given duration, it returns the error of the bound
+// context if it's done before.
+func (w *DB) wait(d time.Duration) error {
+	if w.ctx == nil {
+		time.Sleep(d)
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// backoff returns the delay before the n-th retry using an exponential
+// backoff with full jitter.
+func (w *DB) backoff(n int) time.Duration {
+	d := w.maxDelay
+	if n < 32 {
+		if exp := w.baseDelay << uint(n); exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	//nolint:gosec // jitter does not require a secure random source
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// IsTransient returns true if err is database.ErrConflict, the error the
+// drivers return on transaction conflicts, deadlocks, lock wait timeouts and
+// bolt open timeouts, or driver.ErrBadConn, the error the SQL drivers return on broken
+// connections. Use WithClassifier to retry other errors.
+func IsTransient(err error) bool {
+	return database.IsErrConflict(err) || errors.Is(err, driver.ErrBadConn)
+}
@@ -0,0 +1,221 @@
+package retry
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/bolt"
+	"github.com/smallstep/nosql/database"
+)
+
+// flakyDB is a database.DB that fails the first writes with the given error.
+type flakyDB struct {
+	database.DB
+	err      error
+	failures int
+	calls    int
+}
+
+func (db *flakyDB) fail() error {
+	db.calls++
+	if db.calls <= db.failures {
+		return db.err
+	}
+	return nil
+}
+
+func (db *flakyDB) Open(dataSourceName string, opt ...database.Option) error {
+	if err := db.fail(); err != nil {
+		return err
+	}
+	return db.DB.Open(dataSourceName, opt...)
+}
+
+func (db *flakyDB) Set(bucket, key, value []byte) error {
+	if err := db.fail(); err != nil {
+		return err
+	}
+	return db.DB.Set(bucket, key, value)
+}
+
+func (db *flakyDB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	if err := db.fail(); err != nil {
+		return nil, false, err
+	}
+	return db.DB.CmpAndSwap(bucket, key, oldValue, newValue)
+}
+
+func (db *flakyDB) Incr(bucket, key []byte, delta int64) (int64, error) {
+	if err := db.fail(); err != nil {
+		return 0, err
+	}
+	return database.IncrCounter(db.DB, bucket, key, delta)
+}
+
+func (db *flakyDB) NextSequence(bucket []byte) (uint64, error) {
+	if err := db.fail(); err != nil {
+		return 0, err
+	}
+	return database.NextTableSequence(db.DB, bucket)
+}
+
+func (db *flakyDB) Update(tx *database.Tx) error {
+	if err := db.fail(); err != nil {
+		return err
+	}
+	return db.DB.Update(tx)
+}
+
+func newFlaky(t *testing.T, err error, failures int) *flakyDB {
+	db := &bolt.DB{}
+	assert.FatalError(t, db.Open(filepath.Join(t.TempDir(), "bolt.db")))
+	t.Cleanup(func() { db.Close() })
+	assert.FatalError(t, db.CreateTable([]byte("bucket")))
+	return &flakyDB{DB: db, err: err, failures: failures}
+}
+
+func TestDB(t *testing.T) {
+	bucket, key := []byte("bucket"), []byte("key")
+	conflict := fmt.Errorf("%w: %w", database.ErrConflict, errors.New("native conflict"))
+	fast := WithBackoff(time.Microsecond, time.Millisecond)
+
+	t.Run("ok/set", func(t *testing.T) {
+		flaky := newFlaky(t, conflict, 2)
+		db := Wrap(flaky, fast)
+		assert.FatalError(t, db.Set(bucket, key, []byte("value")))
+		assert.Equals(t, 3, flaky.calls)
+	})
+
+	t.Run("ok/open", func(t *testing.T) {
+		flaky := &flakyDB{DB: &bolt.DB{}, err: conflict, failures: 2}
+		db := Wrap(flaky, fast)
+		assert.FatalError(t, db.Open(filepath.Join(t.TempDir(), "bolt.db")))
+		t.Cleanup(func() { db.Close() })
+		assert.Equals(t, 3, flaky.calls)
+	})
+
+	t.Run("ok/cas", func(t *testing.T) {
+		flaky := newFlaky(t, conflict, 1)
+		db := Wrap(flaky, fast)
+		ret, swapped, err := db.CmpAndSwap(bucket, key, nil, []byte("value"))
+		assert.FatalError(t, err)
+		assert.True(t, swapped)
+		assert.Equals(t, []byte("value"), ret)
+		assert.Equals(t, 2, flaky.calls)
+	})
+
+	t.Run("ok/next-sequence", func(t *testing.T) {
+		flaky := newFlaky(t, conflict, 2)
+		db := Wrap(flaky, fast)
+		seq, err := db.NextSequence(bucket)
+		assert.FatalError(t, err)
+		assert.Equals(t, uint64(1), seq)
+		assert.Equals(t, 3, flaky.calls)
+	})
+
+	t.Run("ok/update", func(t *testing.T) {
+		flaky := newFlaky(t, conflict, 4)
+		db := Wrap(flaky, fast)
+		tx := new(database.Tx)
+		tx.Set(bucket, key, []byte("value"))
+		assert.FatalError(t, db.Update(tx))
+		assert.Equals(t, 5, flaky.calls)
+	})
+
+	t.Run("fail/max-attempts", func(t *testing.T) {
+		flaky := newFlaky(t, conflict, 10)
+		db := Wrap(flaky, fast, WithMaxAttempts(3))
+		err := db.Set(bucket, key, []byte("value"))
+		assert.True(t, database.IsErrConflict(err))
+		assert.Equals(t, 3, flaky.calls)
+	})
+
+	t.Run("fail/not-transient", func(t *testing.T) {
+		flaky := newFlaky(t, errors.New("permanent"), 10)
+		db := Wrap(flaky, fast)
+		assert.Error(t, db.Set(bucket, key, []byte("value")))
+		assert.Equals(t, 1, flaky.calls)
+	})
+
+	t.Run("fail/incr", func(t *testing.T) {
+		flaky := newFlaky(t, conflict, 1)
+		db := Wrap(flaky, fast)
+		_, err := db.Incr(bucket, key, 1)
+		assert.True(t, database.IsErrConflict(err))
+		assert.Equals(t, 1, flaky.calls)
+	})
+
+	t.Run("fail/update-incr", func(t *testing.T) {
+		flaky := newFlaky(t, conflict, 1)
+		db := Wrap(flaky, fast)
+		tx := new(database.Tx)
+		tx.Set(bucket, key, []byte("value"))
+		tx.Incr(bucket, []byte("counter"), 1)
+		assert.True(t, database.IsErrConflict(db.Update(tx)))
+		assert.Equals(t, 1, flaky.calls)
+	})
+
+	t.Run("fail/context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		flaky := newFlaky(t, conflict, 10)
+		db := Wrap(flaky, WithBackoff(time.Hour, time.Hour)).WithContext(ctx)
+		err := db.Set(bucket, key, []byte("value"))
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equals(t, 1, flaky.calls)
+	})
+
+	t.Run("ok/classifier", func(t *testing.T) {
+		permanent := errors.New("permanent")
+		flaky := newFlaky(t, permanent, 1)
+		db := Wrap(flaky, fast, WithClassifier(func(err error) bool {
+			return errors.Is(err, permanent)
+		}))
+		assert.FatalError(t, db.Set(bucket, key, []byte("value")))
+		assert.Equals(t, 2, flaky.calls)
+	})
+}
+
+func TestDB_backoff(t *testing.T) {
+	db := Wrap(nil, WithBackoff(10*time.Millisecond, 50*time.Millisecond))
+	for n := 0; n < 100; n++ {
+		d := db.backoff(n)
+		assert.True(t, d >= 0)
+		switch {
+		case n < 2:
+			assert.True(t, d < 10*time.Millisecond<<uint(n))
+		default:
+			assert.True(t, d < 50*time.Millisecond)
+		}
+	}
+	assert.Equals(t, time.Duration(0), Wrap(nil, WithBackoff(0, 0)).backoff(3))
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"bad-conn", pkgerrors.WithStack(driver.ErrBadConn), true},
+		{"mapped-bad-conn", fmt.Errorf("%w: %w", driver.ErrBadConn, errors.New("native")), true},
+		{"conflict", pkgerrors.Wrap(database.ErrConflict, "update"), true},
+		{"mapped-conflict", fmt.Errorf("%w: %w", database.ErrConflict, errors.New("native")), true},
+		{"not-found", database.ErrNotFound, false},
+		{"bucket-not-found", database.ErrBucketNotFound, false},
+		{"other", errors.New("other"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equals(t, tt.want, IsTransient(tt.err))
+		})
+	}
+}
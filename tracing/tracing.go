@@ -0,0 +1,511 @@
+// Package tracing implements a database.DB decorator that reports every
+// operation of the wrapped database as an OpenTelemetry span.
+//
+// Drivers implementing database.ContextBinder, like the SQL drivers, will
+// report the statements they run as child spans of the operation span. The
+// span context is bound to the wrapped database, so every middleware between
+// the tracing decorator and the driver must implement database.ContextBinder
+// forwarding the context to the database it wraps, like the ones in the
+// logging, retry and faulty packages do. Otherwise, the statements are not
+// reported, so either use the tracing decorator as the innermost middleware,
+// or implement database.ContextBinder on the middleware in between.
+package tracing
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/smallstep/nosql/database"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer used by this package.
+const InstrumentationName = "github.com/smallstep/nosql/tracing"
+
+// Attribute keys used on the spans.
+const (
+	DriverKey      = attribute.Key("db.system")
+	StatementKey   = attribute.Key("db.statement")
+	OperationKey   = attribute.Key("nosql.operation")
+	BucketKey      = attribute.Key("nosql.bucket")
+	NewBucketKey   = attribute.Key("nosql.bucket.new")
+	KeyLengthKey   = attribute.Key("nosql.key.length")
+	ValueSizeKey   = attribute.Key("nosql.value.size")
+	EntriesKey     = attribute.Key("nosql.entries")
+	KeysKey        = attribute.Key("nosql.keys")
+	UpdateOpsKey   = attribute.Key("nosql.update.ops")
+	ViewOpsKey     = attribute.Key("nosql.view.ops")
+	CASSwappedKey  = attribute.Key("nosql.cas.swapped")
+	VersionKey     = attribute.Key("nosql.version")
+	CounterKey     = attribute.Key("nosql.counter")
+	SequenceKey    = attribute.Key("nosql.sequence")
+	TablesKey      = attribute.Key("nosql.tables")
+	TableExistsKey = attribute.Key("nosql.table.exists")
+)
+
+type options struct {
+	provider trace.TracerProvider
+	driver   string
+}
+
+// Option is the modifier type used to configure the tracing decorator.
+type Option func(o *options)
+
+// WithTracerProvider sets the TracerProvider used to create the spans. It
+// defaults to the global TracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.provider = tp
+	}
+}
+
+// WithDriver sets the driver name reported on the db.system attribute. It
+// defaults to the name of the package of the innermost wrapped database, with
+// the major version appended, e.g. "bolt", "badgerv2" or "mysql".
+func WithDriver(name string) Option {
+	return func(o *options) {
+		o.driver = name
+	}
+}
+
+// DB is a database.DB that creates a span for every operation run on the
+// wrapped database.
+type DB struct {
+	db     database.DB
+	tracer trace.Tracer
+	driver string
+	ctx    context.Context
+}
+
+// Wrap returns a DB that traces all the operations run on db.
+func Wrap(db database.DB, opts ...Option) *DB {
+	o := &options{}
+	for _, fn := range opts {
+		fn(o)
+	}
+	if o.provider == nil {
+		o.provider = otel.GetTracerProvider()
+	}
+	if o.driver == "" {
+		o.driver = driverName(db)
+	}
+	return &DB{
+		db:     db,
+		tracer: o.provider.Tracer(InstrumentationName),
+		driver: o.driver,
+	}
+}
+
+// Middleware returns a database.Middleware that wraps a database with Wrap.
+func Middleware(opts ...Option) database.Middleware {
+	return func(db database.DB) database.DB {
+		return Wrap(db, opts...)
+	}
+}
+
+// Unwrap returns the wrapped database.
+func (w *DB) Unwrap() database.DB {
+	return w.db
+}
+
+// WithContext returns a shallow copy of the database that starts its spans as
+// children of the span in ctx.
+func (w *DB) WithContext(ctx context.Context) database.DB {
+	c := *w
+	c.ctx = ctx
+	return &c
+}
+
+// Open opens the wrapped database.
+func (w *DB) Open(dataSourceName string, opt ...database.Option) (err error) {
+	db, span := w.start("Open", nil)
+	defer func() { end(span, err) }()
+	return db.Open(dataSourceName, opt...)
+}
+
+// Close closes the wrapped database.
+func (w *DB) Close() (err error) {
+	db, span := w.start("Close", nil)
+	defer func() { end(span, err) }()
+	return db.Close()
+}
+
+// Get returns the value stored in the given bucket and key.
+func (w *DB) Get(bucket, key []byte) (ret []byte, err error) {
+	db, span := w.start("Get", bucket, KeyLengthKey.Int(len(key)))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(ValueSizeKey.Int(len(ret)))
+		}
+		end(span, err)
+	}()
+	return db.Get(bucket, key)
+}
+
+// GetMulti returns the values stored in the given bucket and keys, if the
+// wrapped database implements database.MultiGetter.
+func (w *DB) GetMulti(bucket []byte, keys [][]byte) (ret map[string][]byte, err error) {
+	db, span := w.start("GetMulti", bucket, KeysKey.Int(len(keys)))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(EntriesKey.Int(len(ret)))
+		}
+		end(span, err)
+	}()
+	return database.GetMulti(db, bucket, keys)
+}
+
+// GetMultiBuckets returns the values stored in the given buckets and keys, if
+// the wrapped database implements database.MultiGetter.
+func (w *DB) GetMultiBuckets(keys map[string][][]byte) (ret map[string]map[string][]byte, err error) {
+	db, span := w.start("GetMultiBuckets", nil, TablesKey.Int(len(keys)), KeysKey.Int(countKeys(keys)))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(EntriesKey.Int(countValues(ret)))
+		}
+		end(span, err)
+	}()
+	return database.GetMultiBuckets(db, keys)
+}
+
+// GetVersioned returns the value and the version stored in the given bucket
+// and key, if the wrapped database implements database.Versioned.
+func (w *DB) GetVersioned(bucket, key []byte) (ret []byte, version uint64, err error) {
+	db, span := w.start("GetVersioned", bucket, KeyLengthKey.Int(len(key)))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(ValueSizeKey.Int(len(ret)), VersionKey.Int64(int64(version)))
+		}
+		end(span, err)
+	}()
+	return database.GetVersioned(db, bucket, key)
+}
+
+// Set stores the given value on bucket and key.
+func (w *DB) Set(bucket, key, value []byte) (err error) {
+	db, span := w.start("Set", bucket, KeyLengthKey.Int(len(key)), ValueSizeKey.Int(len(value)))
+	defer func() { end(span, err) }()
+	return db.Set(bucket, key, value)
+}
+
+// CmpAndSwap modifies the value at the given bucket and key (to newValue)
+// only if the existing (current) value matches oldValue.
+func (w *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) (ret []byte, swapped bool, err error) {
+	db, span := w.start("CmpAndSwap", bucket, KeyLengthKey.Int(len(key)), ValueSizeKey.Int(len(newValue)))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(CASSwappedKey.Bool(swapped))
+		}
+		end(span, err)
+	}()
+	return db.CmpAndSwap(bucket, key, oldValue, newValue)
+}
+
+// SetIfVersion stores the given value on bucket and key only if the stored
+// entry has the given version, if the wrapped database implements
+// database.Versioned.
+func (w *DB) SetIfVersion(bucket, key, value []byte, version uint64) (err error) {
+	db, span := w.start("SetIfVersion", bucket, KeyLengthKey.Int(len(key)), ValueSizeKey.Int(len(value)), VersionKey.Int64(int64(version)))
+	defer func() { end(span, err) }()
+	return database.SetIfVersion(db, bucket, key, value, version)
+}
+
+// Incr adds delta to the counter stored in the given bucket and key, if the
+// wrapped database implements database.Counter.
+func (w *DB) Incr(bucket, key []byte, delta int64) (n int64, err error) {
+	db, span := w.start("Incr", bucket, KeyLengthKey.Int(len(key)))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(CounterKey.Int64(n))
+		}
+		end(span, err)
+	}()
+	return database.IncrCounter(db, bucket, key, delta)
+}
+
+// NextSequence returns the next number of the sequence of a bucket, if the
+// wrapped database implements database.Counter.
+func (w *DB) NextSequence(bucket []byte) (seq uint64, err error) {
+	db, span := w.start("NextSequence", bucket)
+	defer func() {
+		if err == nil {
+			span.SetAttributes(SequenceKey.Int64(int64(seq)))
+		}
+		end(span, err)
+	}()
+	return database.NextTableSequence(db, bucket)
+}
+
+// Del deletes the value stored in the given bucket and key.
+func (w *DB) Del(bucket, key []byte) (err error) {
+	db, span := w.start("Del", bucket, KeyLengthKey.Int(len(key)))
+	defer func() { end(span, err) }()
+	return db.Del(bucket, key)
+}
+
+// BulkLoad writes the entries of the iterator in a bucket, if the wrapped
+// database implements database.BulkLoader.
+func (w *DB) BulkLoad(bucket []byte, iter database.Iterator, opts ...database.BulkOption) (err error) {
+	var loaded int
+	db, span := w.start("BulkLoad", bucket)
+	defer func() {
+		span.SetAttributes(EntriesKey.Int(loaded))
+		end(span, err)
+	}()
+	opts = append(opts, database.WithProgress(func(n int) { loaded = n }))
+	return database.BulkLoad(db, bucket, iter, opts...)
+}
+
+// Truncate deletes all the entries in a bucket of the wrapped database if it
+// implements database.RangeDeleter.
+func (w *DB) Truncate(bucket []byte) (err error) {
+	db, span := w.start("Truncate", bucket)
+	defer func() { end(span, err) }()
+	return database.TruncateTable(db, bucket)
+}
+
+// DeleteRange deletes the entries in a bucket with a key in the range from
+// start, inclusive, to end, exclusive, if the wrapped database implements
+// database.RangeDeleter.
+func (w *DB) DeleteRange(bucket, startKey, endKey []byte) (err error) {
+	db, span := w.start("DeleteRange", bucket)
+	defer func() { end(span, err) }()
+	return database.DeleteKeyRange(db, bucket, startKey, endKey)
+}
+
+// DeletePrefix deletes the entries in a bucket with a key that starts with
+// the given prefix, if the wrapped database implements database.RangeDeleter.
+func (w *DB) DeletePrefix(bucket, prefix []byte) (err error) {
+	db, span := w.start("DeletePrefix", bucket, KeyLengthKey.Int(len(prefix)))
+	defer func() { end(span, err) }()
+	return database.DeleteKeyPrefix(db, bucket, prefix)
+}
+
+// List returns the full list of entries in a bucket.
+func (w *DB) List(bucket []byte) (entries []*database.Entry, err error) {
+	db, span := w.start("List", bucket)
+	defer func() {
+		if err == nil {
+			span.SetAttributes(EntriesKey.Int(len(entries)))
+		}
+		end(span, err)
+	}()
+	return db.List(bucket)
+}
+
+// ListKeys returns the keys of all the entries in a bucket, if the wrapped
+// database implements database.KeyLister.
+func (w *DB) ListKeys(bucket []byte) (keys [][]byte, err error) {
+	db, span := w.start("ListKeys", bucket)
+	defer func() {
+		if err == nil {
+			span.SetAttributes(EntriesKey.Int(len(keys)))
+		}
+		end(span, err)
+	}()
+	return database.ListKeys(db, bucket)
+}
+
+// Count returns the number of entries in a bucket, if the wrapped database
+// implements database.KeyLister.
+func (w *DB) Count(bucket []byte) (n int, err error) {
+	db, span := w.start("Count", bucket)
+	defer func() {
+		if err == nil {
+			span.SetAttributes(EntriesKey.Int(n))
+		}
+		end(span, err)
+	}()
+	return database.Count(db, bucket)
+}
+
+// Update performs multiple commands on one read-write transaction.
+func (w *DB) Update(tx *database.Tx) (err error) {
+	db, span := w.start("Update", nil, UpdateOpsKey.Int(len(tx.Operations)))
+	defer func() { end(span, err) }()
+	return db.Update(tx)
+}
+
+// View performs multiple read commands on one read-only transaction, if the
+// wrapped database implements database.Viewer.
+func (w *DB) View(tx *database.Tx) (err error) {
+	db, span := w.start("View", nil, ViewOpsKey.Int(len(tx.Operations)))
+	defer func() { end(span, err) }()
+	return database.View(db, tx)
+}
+
+// CreateTable creates a table or a bucket in the wrapped database.
+func (w *DB) CreateTable(bucket []byte) (err error) {
+	db, span := w.start("CreateTable", bucket)
+	defer func() { end(span, err) }()
+	return db.CreateTable(bucket)
+}
+
+// DeleteTable deletes a table or a bucket in the wrapped database.
+func (w *DB) DeleteTable(bucket []byte) (err error) {
+	db, span := w.start("DeleteTable", bucket)
+	defer func() { end(span, err) }()
+	return db.DeleteTable(bucket)
+}
+
+// RenameTable renames a table or a bucket in the wrapped database if it
+// implements database.TableRenamer.
+func (w *DB) RenameTable(oldBucket, newBucket []byte) (err error) {
+	db, span := w.start("RenameTable", oldBucket, NewBucketKey.String(string(newBucket)))
+	defer func() { end(span, err) }()
+	return database.RenameTable(db, oldBucket, newBucket)
+}
+
+// CopyTable copies a table or a bucket in the wrapped database if it
+// implements database.TableCopier.
+func (w *DB) CopyTable(srcBucket, dstBucket []byte) (err error) {
+	db, span := w.start("CopyTable", srcBucket, NewBucketKey.String(string(dstBucket)))
+	defer func() { end(span, err) }()
+	return database.CopyTable(db, srcBucket, dstBucket)
+}
+
+// ListTables returns the tables or buckets in the wrapped database if it
+// implements database.TableLister.
+func (w *DB) ListTables() (tables [][]byte, err error) {
+	db, span := w.start("ListTables", nil)
+	defer func() {
+		if err == nil {
+			span.SetAttributes(TablesKey.Int(len(tables)))
+		}
+		end(span, err)
+	}()
+	return database.ListTables(db)
+}
+
+// TableExists returns true if the table or bucket exists in the wrapped
+// database if it implements database.TableLister.
+func (w *DB) TableExists(bucket []byte) (exists bool, err error) {
+	db, span := w.start("TableExists", bucket)
+	defer func() {
+		if err == nil {
+			span.SetAttributes(TableExistsKey.Bool(exists))
+		}
+		end(span, err)
+	}()
+	return database.TableExists(db, bucket)
+}
+
+// Compact triggers a value log garbage collection on the wrapped database if
+// it implements database.Compactor.
+func (w *DB) Compact(discardRatio float64) (err error) {
+	db, span := w.start("Compact", nil)
+	defer func() { end(span, err) }()
+	return database.Compact(db, discardRatio)
+}
+
+// start starts the span of the given operation and returns the wrapped
+// database bound to the context of the span, if the driver supports it.
+func (w *DB) start(op string, bucket []byte, attrs ...attribute.KeyValue) (database.DB, trace.Span) {
+	ctx := w.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	attrs = append(attrs, OperationKey.String(op))
+	if w.driver != "" {
+		attrs = append(attrs, DriverKey.String(w.driver))
+	}
+	if bucket != nil {
+		attrs = append(attrs, BucketKey.String(string(bucket)))
+	}
+	ctx, span := w.tracer.Start(ctx, "nosql."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+
+	if b, ok := w.db.(database.ContextBinder); ok {
+		return b.WithContext(database.ContextWithStatementHook(ctx, w.startStatement)), span
+	}
+	return w.db, span
+}
+
+// startStatement is the database.StatementHook used to trace the statements
+// run by the SQL drivers.
+func (w *DB) startStatement(ctx context.Context, query string) (context.Context, func(error)) {
+	attrs := []attribute.KeyValue{StatementKey.String(query)}
+	if w.driver != "" {
+		attrs = append(attrs, DriverKey.String(w.driver))
+	}
+	ctx, span := w.tracer.Start(ctx, statementName(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		end(span, err)
+	}
+}
+
+// end records the error, if any, and ends the span. Missing keys are not
+// considered errors, but missing buckets are.
+func end(span trace.Span, err error) {
+	if err != nil && (!database.IsErrNotFound(err) || database.IsErrBucketNotFound(err)) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// driverName returns the name of the package of the innermost database in the
+// chain starting at db, with the major version appended if the package is a
+// major version subdirectory, or an empty string if db is nil.
+func driverName(db database.DB) string {
+	for u := database.Unwrap(db); u != nil; u = database.Unwrap(u) {
+		db = u
+	}
+	if db == nil {
+		return ""
+	}
+	t := reflect.TypeOf(db)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	parts := strings.Split(t.PkgPath(), "/")
+	name := parts[len(parts)-1]
+	if len(parts) > 1 && isMajorVersion(name) {
+		name = parts[len(parts)-2] + name
+	}
+	return name
+}
+
+// isMajorVersion returns true if s is a major version suffix like v2.
+func isMajorVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// statementName returns the span name of a statement, the SQL command used.
+func statementName(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "sql"
+	}
+	return strings.ToUpper(fields[0])
+}
+
+func countKeys(keys map[string][][]byte) (n int) {
+	for _, k := range keys {
+		n += len(k)
+	}
+	return
+}
+
+func countValues(values map[string]map[string][]byte) (n int) {
+	for _, v := range values {
+		n += len(v)
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/bolt"
+	"github.com/smallstep/nosql/database"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newBolt(t *testing.T) database.DB {
+	db := &bolt.DB{}
+	assert.FatalError(t, db.Open(filepath.Join(t.TempDir(), "bolt.db")))
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newRecorder() (*tracetest.SpanRecorder, Option) {
+	sr := tracetest.NewSpanRecorder()
+	return sr, WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestDB(t *testing.T) {
+	sr, opt := newRecorder()
+	db := Wrap(newBolt(t), opt, WithDriver("bbolt"))
+
+	bucket, key, value := []byte("bucket"), []byte("key"), []byte("value")
+	assert.FatalError(t, db.CreateTable(bucket))
+	assert.FatalError(t, db.Set(bucket, key, value))
+	_, swapped, err := db.CmpAndSwap(bucket, key, value, []byte("new-value"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	_, err = db.Get(bucket, []byte("missing"))
+	assert.True(t, database.IsErrNotFound(err))
+	assert.FatalError(t, db.Update(&database.Tx{Operations: []*database.TxEntry{
+		{Bucket: bucket, Key: key, Cmd: database.Get},
+		{Bucket: bucket, Key: key, Cmd: database.Delete},
+	}}))
+	assert.Error(t, db.Update(&database.Tx{Operations: []*database.TxEntry{
+		{Bucket: bucket, Key: key, Cmd: database.CmpOrRollback},
+	}}))
+
+	spans := sr.Ended()
+	assert.Len(t, 6, spans)
+
+	names := make([]string, len(spans))
+	for i, s := range spans {
+		names[i] = s.Name()
+		assert.Equals(t, "bbolt", attributes(s)[DriverKey].AsString())
+	}
+	assert.Equals(t, []string{"nosql.CreateTable", "nosql.Set", "nosql.CmpAndSwap", "nosql.Get", "nosql.Update", "nosql.Update"}, names)
+
+	set := attributes(spans[1])
+	assert.Equals(t, "bucket", set[BucketKey].AsString())
+	assert.Equals(t, "Set", set[OperationKey].AsString())
+	assert.Equals(t, int64(3), set[KeyLengthKey].AsInt64())
+	assert.Equals(t, int64(5), set[ValueSizeKey].AsInt64())
+
+	cas := attributes(spans[2])
+	assert.True(t, cas[CASSwappedKey].AsBool())
+	assert.Equals(t, int64(9), cas[ValueSizeKey].AsInt64())
+
+	// Missing keys are not errors.
+	assert.Equals(t, codes.Unset, spans[3].Status().Code)
+
+	assert.Equals(t, int64(2), attributes(spans[4])[UpdateOpsKey].AsInt64())
+	assert.Equals(t, codes.Error, spans[5].Status().Code)
+}
+
+// statementDB is a database.ContextBinder that runs a statement on Get.
+type statementDB struct {
+	database.DB
+	ctx context.Context
+}
+
+func (db *statementDB) WithContext(ctx context.Context) database.DB {
+	return &statementDB{DB: db.DB, ctx: ctx}
+}
+
+func (db *statementDB) Get(bucket, key []byte) ([]byte, error) {
+	_, done := database.StartStatement(db.ctx, "select nvalue from bucket where nkey = ?")
+	done(nil)
+	return db.DB.Get(bucket, key)
+}
+
+func TestDB_statements(t *testing.T) {
+	sr, opt := newRecorder()
+	parentSR, parentOpt := newRecorder()
+	var o options
+	parentOpt(&o)
+	ctx, parent := o.provider.Tracer("test").Start(context.Background(), "parent")
+
+	db := Wrap(&statementDB{DB: newBolt(t)}, opt, WithDriver("mysql")).WithContext(ctx)
+	assert.FatalError(t, db.CreateTable([]byte("bucket")))
+	_, err := db.Get([]byte("bucket"), []byte("key"))
+	assert.True(t, database.IsErrNotFound(err))
+	parent.End()
+
+	spans := sr.Ended()
+	assert.Len(t, 3, spans)
+	assert.Len(t, 1, parentSR.Ended())
+
+	stmt, get := spans[1], spans[2]
+	assert.Equals(t, "SELECT", stmt.Name())
+	assert.Equals(t, "select nvalue from bucket where nkey = ?", attributes(stmt)[StatementKey].AsString())
+	assert.Equals(t, get.SpanContext().SpanID(), stmt.Parent().SpanID())
+	assert.Equals(t, parent.SpanContext().SpanID(), get.Parent().SpanID())
+	assert.Equals(t, parent.SpanContext().TraceID(), stmt.SpanContext().TraceID())
+}
+
+func TestDB_driverAndMissingBucket(t *testing.T) {
+	sr, opt := newRecorder()
+	db := Wrap(Wrap(newBolt(t), opt), opt)
+
+	_, err := db.Get([]byte("missing"), []byte("key"))
+	assert.True(t, database.IsErrBucketNotFound(err))
+
+	spans := sr.Ended()
+	assert.Len(t, 2, spans)
+	for _, s := range spans {
+		assert.Equals(t, "bolt", attributes(s)[DriverKey].AsString())
+		// Missing buckets are errors.
+		assert.Equals(t, codes.Error, s.Status().Code)
+	}
+	assert.Equals(t, "", driverName(nil))
+}